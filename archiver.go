@@ -1,47 +1,135 @@
 package archiver
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"path"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
+	"github.com/klauspost/compress/zip"
 	"github.com/mholt/archiver/v4"
+	yzip "github.com/yeka/zip"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
 )
 
+// NewZipArchive 非 UTF-8 的文件名默认按 gbk 解码
+// 不使用 archiver 自带的解码, 它会把没有设置 UTF-8 标志但实际是 UTF-8 的文件名也按 gbk 解码
 func NewZipArchive(sourceArchive io.Reader) *ArchiverExtractor {
 	return &ArchiverExtractor{Extractor: archiver.Zip{
-		Compression: archiver.ZipMethodZstd, TextEncoding: "gbk",
-	}, sourceArchive: sourceArchive}
+		Compression: archiver.ZipMethodZstd,
+	}, sourceArchive: sourceArchive, formatName: "zip", nameEncoding: "gbk"}
 }
 
 func DetectArchive(sourceArchiveName string, sourceArchive io.Reader) (*ArchiverExtractor, error) {
 	archiverFmt, r, err := archiver.Identify(sourceArchiveName, sourceArchive)
-	if err != nil {
+	if errors.Is(err, archiver.ErrNoMatch) {
+		// 保留 ErrNoMatch, 调用方可以区分不是归档与不支持的归档类型
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	} else if err != nil {
 		return nil, err
 	}
 	if ext, ok := archiverFmt.(archiver.Extractor); ok {
-		return NewArchive(ext, r), nil
+		ae := NewArchive(ext, r)
+		ae.formatName = formatName(archiverFmt)
+		return ae, nil
+	}
+	if comp, ok := archiverFmt.(archiver.Compression); ok {
+		return &ArchiverExtractor{Extractor: newCompressedFile(comp, sourceArchiveName), sourceArchive: r, formatName: formatName(archiverFmt)}, nil
 	}
-	return nil, fmt.Errorf("type %s not support", archiverFmt.Name())
+	return nil, fmt.Errorf("%w: type %s", ErrUnsupportedFormat, archiverFmt.Name())
 }
 
 func NewArchive(extractor archiver.Extractor, sourceArchive io.Reader) *ArchiverExtractor {
+	var ae *ArchiverExtractor
 	if _, ok := extractor.(archiver.Zip); ok {
-		return NewZipArchive(sourceArchive)
+		ae = NewZipArchive(sourceArchive)
+	} else {
+		ae = &ArchiverExtractor{Extractor: extractor, sourceArchive: sourceArchive}
 	}
-	return &ArchiverExtractor{Extractor: extractor, sourceArchive: sourceArchive}
+	if f, ok := extractor.(archiver.Format); ok {
+		ae.formatName = formatName(f)
+	}
+	return ae
+}
+
+// formatName 去掉格式名称开头的 .
+func formatName(f archiver.Format) string {
+	return strings.TrimPrefix(f.Name(), ".")
+}
+
+// FormatName 返回识别出的归档格式, 例如 zip, tar, tar.gz, 7z, rar; 单个压缩文件为压缩格式, 例如 gz
+func (ae *ArchiverExtractor) FormatName() string {
+	return ae.formatName
 }
 
 type ArchiverExtractor struct {
 	archiver.Extractor
 	sourceArchive   io.Reader
+	formatName      string
 	fileHandlerFunc FileHanderFunc
 	pathsInArchive  []string
+	linkTargets     map[string]struct{}
+	password        string
+	encryptedFiles  map[string]*yzip.File
+	sniff           bool
+	sniffed         map[string]string
+	// nameEncoding zip 中非 UTF-8 文件名的编码
+	nameEncoding string
+	// implausibleSizeUnknown 为 true 时声明了不合理大小的文件视为大小未知
+	implausibleSizeUnknown bool
+	maxBufferBytes         int64
+	// maxDecompressedBytes 所有打开的文件累计解压的字节数上限, decompressed 为已解压的字节数
+	maxDecompressedBytes int64
+	decompressed         atomic.Int64
+	// resolveSymlinks 为 true 时解析路径中的符号链接, symlinkTargets 为第一次解析时收集的链接
+	resolveSymlinks bool
+	symlinkTargets  map[string]string
+	// caseInsensitive 为 true 时请求的路径忽略大小写匹配文件名
+	caseInsensitive bool
+	// extractObserver 每次遍历归档结束后调用, 用于统计耗时
+	extractObserver func(time.Duration, error)
+}
+
+// ErrFileNotFound 归档中不存在指定的文件
+var ErrFileNotFound = errors.New("file not found")
+
+// ErrUnsupportedFormat 无法识别归档格式, 或识别出的格式不能解压
+var ErrUnsupportedFormat = errors.New("unsupported archive format")
+
+// ErrUpstreamUnreachable 无法从归档来源读取数据, 例如源站连接失败或返回错误状态码
+var ErrUpstreamUnreachable = errors.New("upstream unreachable")
+
+// ErrTruncated 归档在读取过程中意外结束, 通常是源文件不完整
+var ErrTruncated = errors.New("archive appears truncated")
+
+// TruncatedError 记录归档被截断前已读取的文件数
+type TruncatedError struct {
+	Entries int
+	Err     error
 }
 
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("%s, %d entries read before failure", ErrTruncated, e.Entries)
+}
+
+func (e *TruncatedError) Is(target error) bool { return target == ErrTruncated }
+
+func (e *TruncatedError) Unwrap() error { return e.Err }
+
 type FileHanderFunc func(files *[]archiver.File) archiver.FileHandler
 
 // SetFileHandler
@@ -49,14 +137,75 @@ func (ae *ArchiverExtractor) SetFileHandlerFunc(fileHandlerFunc FileHanderFunc)
 	ae.fileHandlerFunc = fileHandlerFunc
 }
 
+// source 返回从头读取归档的 Reader. 支持随机读取时每次返回新的 Reader,
+// 多次遍历 (包括同时进行的遍历) 互不影响读取位置
+func (ae *ArchiverExtractor) source() io.Reader {
+	if ra, ok := ae.sourceArchive.(sizedReaderAt); ok {
+		return io.NewSectionReader(ra, 0, ra.Size())
+	}
+	return ae.sourceArchive
+}
+
+// SetExtractObserver 设置每次遍历归档结束后的回调, 参数为遍历的耗时和错误
+func (ae *ArchiverExtractor) SetExtractObserver(observe func(time.Duration, error)) {
+	ae.extractObserver = observe
+}
+
+// extract 遍历归档中的文件, 并将意外结束的错误转换为 TruncatedError
+func (ae *ArchiverExtractor) extract(ctx context.Context, pathsInArchive []string, handleFile archiver.FileHandler) (err error) {
+	if ae.extractObserver != nil {
+		start := time.Now()
+		defer func() { ae.extractObserver(time.Since(start), err) }()
+	}
+	entries := 0
+	err = ae.Extract(ctx, ae.source(), pathsInArchive, func(ctx context.Context, f archiver.File) error {
+		entries++
+		ae.normalizeName(&f)
+		// 归档根目录本身 (例如 tar 中的 ./) 不作为文件
+		if f.NameInArchive == "" || f.NameInArchive == "." {
+			return nil
+		}
+		if fh, ok := encryptedZipFile(f); ok {
+			f.Open = func() (io.ReadCloser, error) { return ae.openEncrypted(fh) }
+		}
+		if err := ae.checkSize(f); err != nil && ae.implausibleSizeUnknown {
+			f.FileInfo = unknownSizeFileInfo{f.FileInfo}
+		} else if err != nil {
+			// 仍然可以列出, 只是不能打开
+			f.Open = func() (io.ReadCloser, error) { return nil, err }
+		}
+		ae.limitOpen(&f)
+		if target, ok := hardLinkTarget(f); ok {
+			if ae.linkTargets == nil {
+				ae.linkTargets = make(map[string]struct{})
+			}
+			ae.linkTargets[target] = struct{}{}
+		}
+		return handleFile(ctx, f)
+	})
+	if err == nil {
+		return nil
+	}
+	// zip 缺少中央目录时无法识别, 同样视为被截断
+	_, isZip := ae.Extractor.(archiver.Zip)
+	if errors.Is(err, io.ErrUnexpectedEOF) || (isZip && errors.Is(err, zip.ErrFormat)) {
+		return &TruncatedError{Entries: entries, Err: err}
+	}
+	return err
+}
+
 // ExtractDirs 级联提取指定目录下的所有文件和目录
 func (ae *ArchiverExtractor) ExtractDirs(ctx context.Context, dir string) ([]archiver.File, error) {
 	files := make([]archiver.File, 0)
-	ff := DirFilter(&files, dir)
+	ff := dirFilter(&files, dir, ae.cutPrefix())
 	if ae.fileHandlerFunc != nil {
 		ff = ae.fileHandlerFunc(&files)
 	}
-	return files, ae.Extract(ctx, ae.sourceArchive, ae.pathsInArchive, ff)
+	handleFile, err := ae.aliasResolvedDir(ctx, dir, ae.sniffKept(&files, ff))
+	if err != nil {
+		return nil, err
+	}
+	return files, ae.extract(ctx, ae.pathsInArchive, handleFile)
 }
 
 // ExtractDirs 提取指定目录下的所有文件和目录
@@ -66,28 +215,428 @@ func (ae *ArchiverExtractor) CascadeExtractDirs(ctx context.Context, dir string)
 	if ae.fileHandlerFunc != nil {
 		ff = ae.fileHandlerFunc(&files)
 	}
+	return files, ae.CascadeWalkDirs(ctx, dir, ae.sniffKept(&files, ff))
+}
+
+// CascadeExtractDirsDepth 与 CascadeExtractDirs 相同, 但只返回 dir 下不超过 depth 层的文件和目录, depth 小于 0 时不限制
+func (ae *ArchiverExtractor) CascadeExtractDirsDepth(ctx context.Context, dir string, depth int) ([]archiver.File, error) {
+	if depth < 0 {
+		return ae.CascadeExtractDirs(ctx, dir)
+	}
+	files := make([]archiver.File, 0)
+	ff := NoFilter(&files)
+	if ae.fileHandlerFunc != nil {
+		ff = ae.fileHandlerFunc(&files)
+	}
+	return files, ae.CascadeWalkDirs(ctx, dir, ae.sniffKept(&files, func(ctx context.Context, f archiver.File) error {
+		if DirDepth(dir, f.NameInArchive) > depth {
+			return nil
+		}
+		return ff(ctx, f)
+	}))
+}
+
+// DirDepth 返回 nameInArchive 在 dir 下的层级, dir 本身为 0, 直接子项为 1
+// 只比较层数而不比较名称, 忽略大小写匹配时 dir 的大小写可以与 nameInArchive 不同
+func DirDepth(dir, nameInArchive string) int {
+	return max(pathDepth(nameInArchive)-pathDepth(dir), 0)
+}
+
+// pathDepth 返回路径的层数, 根目录为 0
+func pathDepth(p string) int {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return 0
+	}
+	return strings.Count(p, "/") + 1
+}
 
-	pia := ae.pathsInArchive
+// CascadeWalkDirs 对指定目录下的所有文件和目录依次调用 handleFile, 不保存遍历结果
+func (ae *ArchiverExtractor) CascadeWalkDirs(ctx context.Context, dir string, handleFile archiver.FileHandler) error {
+	// archiver 按原始文件名匹配 pathsInArchive, 解码后的路径需要在 handler 中过滤
 	if dir != "/" {
-		pia = []string{strings.TrimPrefix(dir, "/")}
+		inner := handleFile
+		cut := ae.cutPrefix()
+		handleFile = func(ctx context.Context, f archiver.File) error {
+			if _, ok := cut("/"+f.NameInArchive, dir); !ok {
+				return nil
+			}
+			return inner(ctx, f)
+		}
 	}
-	return files, ae.Extract(ctx, ae.sourceArchive, pia, ff)
+	return ae.extract(ctx, ae.pathsInArchive, handleFile)
 }
 
-// ExtractFile 提取指定文件
+// ExtractGlob 提取指定目录下路径匹配 pattern 的文件和目录
+func (ae *ArchiverExtractor) ExtractGlob(ctx context.Context, dir, pattern string) ([]archiver.File, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	files := make([]archiver.File, 0)
+	ff := globFilter(&files, dir, pattern, ae.cutPrefix())
+	if ae.fileHandlerFunc != nil {
+		ff = ae.fileHandlerFunc(&files)
+	}
+	return files, ae.extract(ctx, ae.pathsInArchive, ae.sniffKept(&files, ff))
+}
+
+// ArchiveStats 重新打包时已写入的文件数和文件内容的字节数
+type ArchiveStats struct {
+	Members int
+	Bytes   int64
+}
+
+// ArchiveDirs 将指定目录下的所有文件和目录重新打包, 流式写入 output
+func (ae *ArchiverExtractor) ArchiveDirs(ctx context.Context, dir string, format archiver.ArchiverAsync, output io.Writer) (ArchiveStats, error) {
+	var stats ArchiveStats
+	jobs := make(chan archiver.ArchiveAsyncJob)
+	done := make(chan struct{})
+	var archiveErr error
+	// 遍历出错后不再写入归档的结束标记, 调用方可以据此判断是否还能返回错误
+	aborted := &abortWriter{Writer: output}
+	go func() {
+		defer close(done)
+		archiveErr = format.ArchiveAsync(ctx, aborted, jobs)
+	}()
+
+	// 打包后的路径以目录自身为根, 例如 /a/b/ 下的文件打包为 b/...
+	base := path.Dir(strings.Trim(dir, "/"))
+	if base == "." {
+		base = ""
+	}
+	result := make(chan error)
+	cut := ae.cutPrefix()
+	err := ae.extract(ctx, ae.pathsInArchive, func(ctx context.Context, f archiver.File) error {
+		if _, ok := cut("/"+f.NameInArchive, dir); !ok {
+			return nil
+		}
+		// 打包时会先写入文件头再打开文件, 提前检查避免输出不完整的文件
+		if _, ok := encryptedZipFile(f); ok && ae.password == "" {
+			return ErrEncrypted
+		}
+		if base != "" {
+			f.NameInArchive, _ = cut(f.NameInArchive, base+"/")
+		}
+		select {
+		case jobs <- archiver.ArchiveAsyncJob{File: f, Result: result}:
+		case <-done:
+			return archiveErr
+		}
+		select {
+		case err := <-result:
+			if err == nil {
+				stats.Members++
+				if !f.IsDir() {
+					stats.Bytes += f.Size()
+				}
+			}
+			return err
+		case <-done:
+			return archiveErr
+		}
+	})
+	if err != nil {
+		aborted.abort()
+	}
+	close(jobs)
+	<-done
+	if err != nil {
+		return stats, err
+	}
+	return stats, archiveErr
+}
+
+// abortWriter 在 abort 后丢弃所有写入
+type abortWriter struct {
+	io.Writer
+	aborted atomic.Bool
+}
+
+func (w *abortWriter) abort() { w.aborted.Store(true) }
+
+func (w *abortWriter) Write(p []byte) (int, error) {
+	if w.aborted.Load() {
+		return len(p), nil
+	}
+	return w.Writer.Write(p)
+}
+
+// ExtractFile 提取指定文件, 开启 SetResolveSymlinks 时返回链接指向的文件
 func (ae *ArchiverExtractor) ExtractFile(ctx context.Context, filePath string) (*archiver.File, error) {
+	if ae.resolveSymlinks {
+		resolved, err := ae.ResolvePath(ctx, filePath)
+		if err != nil {
+			return nil, err
+		}
+		filePath = resolved
+	}
 	files := make([]archiver.File, 0)
-	ff := FileFilter(&files, filePath)
+	ff := fileFilter(&files, filePath, ae.cutPrefix())
 	if ae.fileHandlerFunc != nil {
 		ff = ae.fileHandlerFunc(&files)
 	}
-	err := ae.Extract(ctx, ae.sourceArchive, ae.pathsInArchive, ff)
+	err := ae.extract(ctx, ae.pathsInArchive, ff)
 	if len(files) == 0 {
-		return nil, fmt.Errorf("file not found")
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrFileNotFound
 	}
+	ae.keepOpen(&files[0])
 	return &files[0], err
 }
 
+// FileResult ExtractFiles 中一个路径的结果, File 和 Err 只有一个不为空
+type FileResult struct {
+	File *archiver.File
+	Err  error
+}
+
+// ExtractFiles 遍历一次归档查找多个文件, 结果与 filePaths 顺序一致, 没有找到的文件为 ErrFileNotFound.
+// 只返回文件信息, 不保证可以打开文件内容
+func (ae *ArchiverExtractor) ExtractFiles(ctx context.Context, filePaths []string) ([]FileResult, error) {
+	results := make([]FileResult, len(filePaths))
+	// wanted 归档中的路径 -> 对应的 filePaths 下标
+	wanted := make(map[string][]int, len(filePaths))
+	for i, filePath := range filePaths {
+		if ae.resolveSymlinks {
+			resolved, err := ae.ResolvePath(ctx, filePath)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			filePath = resolved
+		}
+		key := ae.foldKey(filePath)
+		wanted[key] = append(wanted[key], i)
+	}
+	remaining := len(wanted)
+	if remaining > 0 {
+		err := ae.extract(ctx, ae.pathsInArchive, func(ctx context.Context, f archiver.File) error {
+			idxs, ok := wanted[ae.foldKey("/"+f.NameInArchive)]
+			if !ok || f.IsDir() || results[idxs[0]].File != nil {
+				return nil
+			}
+			for _, i := range idxs {
+				file := f
+				results[i].File = &file
+			}
+			if remaining--; remaining == 0 {
+				return errStopWalk
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopWalk) {
+			return nil, err
+		}
+	}
+	for i := range results {
+		if results[i].File == nil && results[i].Err == nil {
+			results[i].Err = ErrFileNotFound
+		}
+	}
+	return results, nil
+}
+
+// StatEntry 查找路径对应的文件或目录, 不打开文件内容. 归档中没有单独记录的目录根据其下文件的路径补全
+func (ae *ArchiverExtractor) StatEntry(ctx context.Context, entryPath string) (*archiver.File, error) {
+	entryPath = strings.TrimSuffix(entryPath, "/")
+	var found *archiver.File
+	cut := ae.cutPrefix()
+	err := ae.extract(ctx, ae.pathsInArchive, func(ctx context.Context, f archiver.File) error {
+		name := "/" + strings.TrimSuffix(f.NameInArchive, "/")
+		if rest, ok := cut(name, entryPath); ok && rest == "" {
+			found = &f
+			return errStopWalk
+		}
+		if rest, ok := cut(name, entryPath+"/"); ok && found == nil {
+			dir := implicitDir(name[1 : len(name)-len(rest)])
+			found = &dir
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrFileNotFound
+	}
+	return found, nil
+}
+
+// NameEncoding 返回解码文件名时实际使用的编码, 同一个 zip 中不同文件可能不同
+func (ae *ArchiverExtractor) NameEncoding(f archiver.File) string {
+	if _, ok := ae.nameDecoder(f); ok {
+		return ae.nameEncoding
+	}
+	return "utf-8"
+}
+
+// nameEncodings 解码 zip 中非 UTF-8 文件名时支持的编码
+var nameEncodings = map[string]encoding.Encoding{
+	"gbk":       simplifiedchinese.GBK,
+	"big5":      traditionalchinese.Big5,
+	"shift-jis": japanese.ShiftJIS,
+	"euc-kr":    korean.EUCKR,
+	"utf-8":     unicode.UTF8,
+}
+
+// NameEncodings 支持的文件名编码
+var NameEncodings = []string{"gbk", "big5", "shift-jis", "euc-kr", "utf-8"}
+
+// ErrUnknownEncoding 不支持的文件名编码
+var ErrUnknownEncoding = errors.New("unknown encoding")
+
+// CheckNameEncoding 检查是否支持指定的文件名编码
+func CheckNameEncoding(name string) error {
+	if _, ok := nameEncodings[name]; !ok {
+		return fmt.Errorf("%w %q, support %s", ErrUnknownEncoding, name, strings.Join(NameEncodings, ", "))
+	}
+	return nil
+}
+
+// SetNameEncoding 设置 zip 中非 UTF-8 文件名的编码, 默认为 gbk
+func (ae *ArchiverExtractor) SetNameEncoding(name string) error {
+	if err := CheckNameEncoding(name); err != nil {
+		return err
+	}
+	ae.nameEncoding = name
+	return nil
+}
+
+// nameDecoder 返回解码文件名使用的编码
+// 只有没有设置 UTF-8 标志, 且不是合法 UTF-8 的文件名才需要解码
+func (ae *ArchiverExtractor) nameDecoder(f archiver.File) (encoding.Encoding, bool) {
+	fh, ok := f.Header.(zip.FileHeader)
+	if !ok || !fh.NonUTF8 || fh.Flags&0x800 != 0 || utf8.ValidString(fh.Name) {
+		return nil, false
+	}
+	enc, ok := nameEncodings[ae.nameEncoding]
+	return enc, ok
+}
+
+// LinkGroup 返回 tar 硬链接分组标识, 硬链接与其指向的文件属于同一组, 标识为被指向文件的路径
+func (ae *ArchiverExtractor) LinkGroup(f archiver.File) string {
+	if target, ok := hardLinkTarget(f); ok {
+		return target
+	}
+	name := path.Clean(f.NameInArchive)
+	if _, ok := ae.linkTargets[name]; ok {
+		return name
+	}
+	return ""
+}
+
+// utf8BOM 部分工具会在第一个文件名前写入 BOM
+const utf8BOM = "\uFEFF"
+
+// normalizeName 解码非 UTF-8 的文件名, 并去掉文件名开头的 BOM 和 /.
+// 分隔符统一为 /, 目录以 / 结尾而文件不以 / 结尾, 过滤时按 / 计算层级
+func (ae *ArchiverExtractor) normalizeName(f *archiver.File) {
+	name := f.NameInArchive
+	if enc, ok := ae.nameDecoder(*f); ok {
+		if decoded, err := enc.NewDecoder().String(name); err == nil {
+			name = decoded
+		}
+	}
+	name = strings.TrimPrefix(name, utf8BOM)
+	// Windows 下创建的 zip 可能使用 \ 作为分隔符, tar 等格式中 \ 可以是文件名的一部分
+	if _, ok := f.Header.(zip.FileHeader); ok {
+		name = strings.ReplaceAll(name, `\`, "/")
+	}
+	for strings.Contains(name, "//") {
+		name = strings.ReplaceAll(name, "//", "/")
+	}
+	// 绝对路径和 ./ 开头的路径统一放在归档根目录下, 与过滤时拼接的 / 保持一致
+	for strings.HasPrefix(name, "/") || strings.HasPrefix(name, "./") {
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "."), "/")
+	}
+	name = strings.TrimSuffix(name, "/")
+	if f.IsDir() && name != "" && name != "." {
+		name += "/"
+	}
+	if name == f.NameInArchive {
+		return
+	}
+	f.NameInArchive = name
+	f.FileInfo = renamedFileInfo{FileInfo: f.FileInfo, name: path.Base(name)}
+}
+
+// renamedFileInfo 替换 FileInfo 中的文件名
+type renamedFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (fi renamedFileInfo) Name() string { return fi.name }
+
+func hardLinkTarget(f archiver.File) (string, bool) {
+	if hdr, ok := f.Header.(*tar.Header); ok && hdr.Typeflag == tar.TypeLink {
+		return path.Clean(hdr.Linkname), true
+	}
+	return "", false
+}
+
+// Seekable 判断文件是否支持高效的随机读取, 目前仅 zip 中未压缩且未加密的文件满足
+func Seekable(f archiver.File) bool {
+	if fh, ok := f.Header.(zip.FileHeader); ok {
+		return fh.Method == zip.Store && fh.Flags&0x1 == 0
+	}
+	return false
+}
+
+const (
+	eocdSignature         = 0x06054b50
+	eocdLen               = 22
+	zip64LocatorSignature = 0x07064b50
+	zip64LocatorLen       = 20
+	maxZipCommentLen      = 0xffff
+)
+
+// IsZip 判断是否为 zip 归档
+func (ae *ArchiverExtractor) IsZip() bool {
+	_, ok := ae.Extractor.(archiver.Zip)
+	return ok
+}
+
+// Zip64 判断 zip 归档是否为 zip64 格式, 即中央目录结束记录前存在 zip64 定位记录
+func (ae *ArchiverExtractor) Zip64() (bool, error) {
+	if !ae.IsZip() {
+		return false, nil
+	}
+	ra, ok := ae.sourceArchive.(sizedReaderAt)
+	if !ok {
+		return false, fmt.Errorf("zip archive is not seekable")
+	}
+
+	size := ra.Size()
+	tailLen := int64(eocdLen + maxZipCommentLen + zip64LocatorLen)
+	if tailLen > size {
+		tailLen = size
+	}
+	tail := make([]byte, tailLen)
+	if _, err := ra.ReadAt(tail, size-tailLen); err != nil && err != io.EOF {
+		return false, err
+	}
+
+	// 注释中可能包含签名, 从后往前找到第一个合法的结束记录
+	sig := binary.LittleEndian.AppendUint32(nil, eocdSignature)
+	for i := bytes.LastIndex(tail, sig); i >= 0; i = bytes.LastIndex(tail[:i], sig) {
+		if len(tail)-i < eocdLen {
+			continue
+		}
+		commentLen := int(binary.LittleEndian.Uint16(tail[i+20:]))
+		if i+eocdLen+commentLen > len(tail) {
+			continue
+		}
+		if i < zip64LocatorLen {
+			return false, nil
+		}
+		return binary.LittleEndian.Uint32(tail[i-zip64LocatorLen:]) == zip64LocatorSignature, nil
+	}
+	return false, zip.ErrFormat
+}
+
 // NoFilter 级联提取所有文件和目录
 func NoFilter(files *[]archiver.File) archiver.FileHandler {
 	return func(ctx context.Context, f archiver.File) error {
@@ -97,20 +646,132 @@ func NoFilter(files *[]archiver.File) archiver.FileHandler {
 }
 
 // DirFilter 仅提取指定目录下的文件和目录
+// 归档中没有单独记录的中间目录根据其下文件的路径补全
 func DirFilter(files *[]archiver.File, dir string) archiver.FileHandler {
+	return dirFilter(files, dir, strings.CutPrefix)
+}
+
+// dirFilter 与 DirFilter 相同, cut 匹配 dir 并返回文件名中 dir 之后的部分
+func dirFilter(files *[]archiver.File, dir string, cut func(name, prefix string) (string, bool)) archiver.FileHandler {
+	// 已提取的子目录名到其在 files 中的下标
+	dirIndex := make(map[string]int)
 	return func(ctx context.Context, f archiver.File) error {
-		dirPath := f.NameInArchive
-		fileDir := strings.TrimPrefix("/"+dirPath, dir)
-		if (strings.Count(fileDir, "/") == 0 && len(fileDir) > 0) ||
-			(strings.Count(fileDir, "/") == 1 && strings.HasSuffix(fileDir, "/")) {
+		name := "/" + f.NameInArchive
+		fileDir, ok := cut(name, dir)
+		if !ok {
+			return nil
+		}
+		if strings.Count(fileDir, "/") == 0 && len(fileDir) > 0 {
 			*files = append(*files, f)
+			return nil
+		}
+		child, _, _ := strings.Cut(fileDir, "/")
+		if child == "" {
+			return nil
+		}
+		i, seen := dirIndex[child]
+		switch {
+		case strings.Count(fileDir, "/") == 1 && strings.HasSuffix(fileDir, "/"):
+			// 记录的目录替换之前补全的目录
+			if seen {
+				(*files)[i] = f
+				return nil
+			}
+		case seen:
+			return nil
+		default:
+			// 使用归档中记录的大小写
+			f = implicitDir(name[1:len(name)-len(fileDir)] + child + "/")
 		}
+		dirIndex[child] = len(*files)
+		*files = append(*files, f)
 		return nil
 	}
 }
 
+// GlobFilter 仅提取指定目录下相对路径匹配 pattern 的文件和目录
+// pattern 按 path.Match 匹配, * 不匹配 /, 因此只匹配 pattern 所在层级的文件; 以 / 结尾时只匹配目录
+func GlobFilter(files *[]archiver.File, dir, pattern string) archiver.FileHandler {
+	return globFilter(files, dir, pattern, strings.CutPrefix)
+}
+
+// globFilter 与 GlobFilter 相同, cut 匹配 dir 并返回文件名中 dir 之后的部分
+func globFilter(files *[]archiver.File, dir, pattern string, cut func(name, prefix string) (string, bool)) archiver.FileHandler {
+	dirsOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	depth := strings.Count(pattern, "/") + 1
+	// 已提取的目录的相对路径到其在 files 中的下标
+	dirIndex := make(map[string]int)
+	return func(ctx context.Context, f archiver.File) error {
+		name := "/" + f.NameInArchive
+		rel, ok := cut(name, dir)
+		if !ok {
+			return nil
+		}
+		base := name[1 : len(name)-len(rel)]
+		rel = strings.TrimSuffix(rel, "/")
+		parts := strings.Split(rel, "/")
+		if rel == "" || len(parts) < depth {
+			return nil
+		}
+		candidate := strings.Join(parts[:depth], "/")
+		if matched, _ := path.Match(pattern, candidate); !matched {
+			return nil
+		}
+
+		i, seen := dirIndex[candidate]
+		switch {
+		case len(parts) == depth && !f.IsDir():
+			if !dirsOnly {
+				*files = append(*files, f)
+			}
+			return nil
+		case len(parts) == depth:
+			// 记录的目录替换之前补全的目录
+			if seen {
+				(*files)[i] = f
+				return nil
+			}
+		case seen:
+			return nil
+		default:
+			f = implicitDir(base + candidate + "/")
+		}
+		dirIndex[candidate] = len(*files)
+		*files = append(*files, f)
+		return nil
+	}
+}
+
+// implicitDir 构造归档中没有记录的目录
+func implicitDir(nameInArchive string) archiver.File {
+	return archiver.File{
+		FileInfo:      implicitDirInfo{name: path.Base(nameInArchive)},
+		NameInArchive: nameInArchive,
+		Open: func() (io.ReadCloser, error) {
+			return nil, fmt.Errorf("%s is a directory", nameInArchive)
+		},
+	}
+}
+
+type implicitDirInfo struct {
+	name string
+}
+
+func (fi implicitDirInfo) Name() string       { return fi.name }
+func (fi implicitDirInfo) Size() int64        { return 0 }
+func (fi implicitDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o755 }
+func (fi implicitDirInfo) ModTime() time.Time { return time.Time{} }
+func (fi implicitDirInfo) IsDir() bool        { return true }
+func (fi implicitDirInfo) Sys() any           { return nil }
+
 // FileFilter 仅提取指定文件
 func FileFilter(files *[]archiver.File, filePath string) archiver.FileHandler {
+	return fileFilter(files, filePath, strings.CutPrefix)
+}
+
+// fileFilter 与 FileFilter 相同, cut 匹配路径前缀并返回之后的部分
+func fileFilter(files *[]archiver.File, filePath string, cut func(name, prefix string) (string, bool)) archiver.FileHandler {
 	return func(ctx context.Context, f archiver.File) error {
 		if f.IsDir() {
 			return nil
@@ -120,10 +781,10 @@ func FileFilter(files *[]archiver.File, filePath string) archiver.FileHandler {
 		if fileDir == "." {
 			fileDir = ""
 		}
-		if !strings.HasPrefix(filePath, "/"+fileDir) {
+		if _, ok := cut(filePath, "/"+fileDir); !ok {
 			return fs.SkipDir
 		}
-		if filePath == "/"+f.NameInArchive {
+		if rest, ok := cut("/"+f.NameInArchive, filePath); ok && rest == "" {
 			*files = append(*files, f)
 		}
 		return nil
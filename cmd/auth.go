@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyHeader 不方便设置 Authorization 的客户端可以用该头部提供 -auth-token
+const apiKeyHeader = "X-API-Key"
+
+// authKeyHashKey gin.Context 中保存已通过认证的 token 的哈希, 用于按 API 密钥限速
+const authKeyHashKey = "auth_key_hash"
+
+// authToken 要求请求在 Authorization: Bearer <token> 或 X-API-Key 中提供 token, 否则返回 401.
+// Authorization 和 X-API-Key 都不再转发给源站, 源站需要的凭据通过请求体的 headers 指定
+func authToken(token string) gin.HandlerFunc {
+	want := sha256.Sum256([]byte(token))
+	return func(c *gin.Context) {
+		if signedDown(c) {
+			c.Next()
+			return
+		}
+		got := c.GetHeader(apiKeyHeader)
+		if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			got = bearer
+		}
+		// 比较哈希, 耗时与 token 的内容和长度都无关
+		sum := sha256.Sum256([]byte(got))
+		if got == "" || subtle.ConstantTimeCompare(sum[:], want[:]) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="archive"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, Resp[interface{}]{Code: http.StatusUnauthorized, Message: "missing or invalid auth token", ErrorType: "unauthorized"})
+			return
+		}
+		// 两个头部都可能带有凭据, 都不转发
+		c.Request.Header.Del("Authorization")
+		c.Request.Header.Del(apiKeyHeader)
+		c.Set(authKeyHashKey, hex.EncodeToString(sum[:]))
+		c.Next()
+	}
+}
+
+// signedDown 判断是否为有效的签名下载链接, 签名链接由已认证的请求签发, 可以直接在浏览器中打开
+func signedDown(c *gin.Context) bool {
+	if c.FullPath() != "/down" {
+		return false
+	}
+	query := c.Request.URL.Query()
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	return err == nil && verifyDown(query.Get("link"), query.Get("path"), query.Get("token"), expires)
+}
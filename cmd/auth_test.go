@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAuthRouter 与 main 相同, 健康检查不需要认证, 其余接口需要 token
+func newAuthRouter(token string, middlewares ...gin.HandlerFunc) *gin.Engine {
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.GET("/healthz", Healthz)
+	api := r.Group("/")
+	api.Use(authToken(token))
+	api.Use(middlewares...)
+	archiveRoutes(api)
+	return r
+}
+
+func TestAuthToken(t *testing.T) {
+	setupConf(t)
+	// 即使配置为转发, 认证用的头部也不能发往源站
+	conf.ForwardHeaders = []string{"Authorization", apiKeyHeader}
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	var mu sync.Mutex
+	var leaked []string
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, name := range []string{"Authorization", apiKeyHeader} {
+			if v := r.Header.Get(name); v != "" {
+				leaked = append(leaked, name+": "+v)
+			}
+		}
+		return false
+	}
+	r := newAuthRouter("s3cret")
+	target := query("/list", "link", origin.link("/a.zip"))
+
+	for name, tc := range map[string]struct {
+		header []string
+		code   int
+	}{
+		"missing":           {nil, 401},
+		"wrong bearer":      {[]string{"Authorization", "Bearer wrong"}, 401},
+		"prefix of token":   {[]string{"Authorization", "Bearer s3cre"}, 401},
+		"not bearer":        {[]string{"Authorization", "Basic s3cret"}, 401},
+		"wrong api key":     {[]string{apiKeyHeader, "wrong"}, 401},
+		"correct bearer":    {[]string{"Authorization", "Bearer s3cret"}, 200},
+		"correct api key":   {[]string{apiKeyHeader, "s3cret"}, 200},
+		"bearer overrides":  {[]string{apiKeyHeader, "s3cret", "Authorization", "Bearer wrong"}, 401},
+		"api key and basic": {[]string{apiKeyHeader, "s3cret", "Authorization", "Basic other"}, 200},
+	} {
+		w := get(t, r, target, tc.header...)
+		if tc.code == 401 {
+			if w.Code != 401 || w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("%s: status %d, WWW-Authenticate %q, want 401", name, w.Code, w.Header().Get("WWW-Authenticate"))
+			}
+			continue
+		}
+		if code := decodeResp[json.RawMessage](t, w).Code; w.Code != 200 || code != 200 {
+			t.Errorf("%s: status %d, code %d, want 200", name, w.Code, code)
+		}
+	}
+	mu.Lock()
+	if len(leaked) != 0 {
+		t.Errorf("credentials forwarded to the origin: %v", leaked)
+	}
+	mu.Unlock()
+
+	if w := get(t, r, "/healthz"); w.Code != 200 {
+		t.Errorf("healthz without token: status %d, want 200", w.Code)
+	}
+}
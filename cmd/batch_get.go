@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchPaths /batch-get 一次请求中 paths 的上限
+const maxBatchPaths = 1000
+
+type BatchGetReq struct {
+	ArchiveReq
+	Paths []string `json:"paths" form:"paths"`
+}
+
+// BatchGetItem paths 中一个路径的结果, 找到时 File 不为空, 否则 Code 和 Message 为该路径的错误
+type BatchGetItem struct {
+	Path      string   `json:"path"`
+	Code      int      `json:"code"`
+	Message   string   `json:"message,omitempty"`
+	ErrorType string   `json:"error_type,omitempty"`
+	File      *ObjResp `json:"file,omitempty"`
+}
+
+type BatchGetResp struct {
+	ArchiveMeta
+	// Files 与请求中的 paths 顺序一致
+	Files []BatchGetItem `json:"files"`
+}
+
+// BatchGet 返回多个文件的信息, 同一个归档 (包括 !/ 进入的内层归档) 中的路径只遍历一次归档
+func BatchGet(c *gin.Context) {
+	var req BatchGetReq
+	if err := c.ShouldBind(&req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	if len(req.Paths) == 0 {
+		ErrorStrResp(c, "paths is required", 400)
+		return
+	}
+	if len(req.Paths) > maxBatchPaths {
+		ErrorStrResp(c, fmt.Sprintf("too many paths, at most %d", maxBatchPaths), 400)
+		return
+	}
+
+	items := make([]BatchGetItem, len(req.Paths))
+	reqPaths := make([]string, len(req.Paths))
+	// groups 内层归档的路径前缀 -> 其中的路径在 items 中的下标, 不在内层归档中时前缀为空
+	groups := make(map[string][]int)
+	var prefixes []string
+	for i, p := range req.Paths {
+		items[i].Path = p
+		reqPath, err := handerReqPath(p, false, req.PathEncoded)
+		if err != nil {
+			items[i].Code, items[i].Message = http.StatusBadRequest, err.Error()
+			continue
+		}
+		prefix := ""
+		if conf.MaxNestDepth > 0 {
+			if idx := strings.LastIndex(reqPath, nestSeparator); idx >= 0 {
+				prefix = reqPath[:idx+len(nestSeparator)]
+			}
+		}
+		if _, ok := groups[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+		groups[prefix] = append(groups[prefix], i)
+		reqPaths[i] = reqPath
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+	for _, prefix := range prefixes {
+		idxs := groups[prefix]
+		inner, _, err := openNested(c, arc, &req.ArchiveReq, prefix)
+		if err != nil {
+			for _, i := range idxs {
+				items[i].setError(err)
+			}
+			continue
+		}
+		paths := make([]string, len(idxs))
+		for j, i := range idxs {
+			paths[j] = "/" + strings.TrimPrefix(reqPaths[i][len(prefix):], "/")
+		}
+		results, err := inner.ExtractFiles(c, paths)
+		if err != nil {
+			ExtractErrorResp(c, err)
+			return
+		}
+		for j, i := range idxs {
+			if results[j].Err != nil {
+				items[i].setError(results[j].Err)
+				continue
+			}
+			obj := buildObj(inner, results[j].File)
+			items[i].Code, items[i].File = http.StatusOK, &obj
+		}
+	}
+	SuccessResp(c, BatchGetResp{ArchiveMeta: arc.Meta(), Files: items})
+}
+
+func (item *BatchGetItem) setError(err error) {
+	item.Code, item.ErrorType = extractErrorCode(err)
+	item.Message = err.Error()
+}
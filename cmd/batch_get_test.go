@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestBatchGet(t *testing.T) {
+	setupConf(t)
+	entries := []testEntry{{Name: "a.txt", Body: "alpha"}, {Name: "dir/b.txt", Body: "beta"}, {Name: "z.txt", Body: "zeta"}}
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, entries...), "/a.tar": tarBytes(t, entries...)})
+	r := newTestRouter()
+	batch := func(link string, paths ...string) BatchGetResp {
+		t.Helper()
+		body, err := json.Marshal(map[string]any{"link": origin.link(link), "paths": paths})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := decodeResp[BatchGetResp](t, post(t, r, "/batch-get", "application/json", body))
+		if resp.Code != 200 || len(resp.Data) != 1 {
+			t.Fatalf("batch-get %v: code %d, message %q", paths, resp.Code, resp.Message)
+		}
+		return resp.Data[0]
+	}
+
+	for _, link := range []string{"/a.zip", "/a.tar"} {
+		paths := []string{"/z.txt", "/missing.txt", "/dir/b.txt", "/a.txt", "/dir/missing.txt"}
+		resp := batch(link, paths...)
+		if len(resp.Files) != len(paths) {
+			t.Fatalf("%s: %d results for %d paths", link, len(resp.Files), len(paths))
+		}
+		// 结果与请求的顺序一致, 不存在的路径单独返回错误
+		for i, item := range resp.Files {
+			if item.Path != paths[i] {
+				t.Errorf("%s: result %d is for %s, want %s", link, i, item.Path, paths[i])
+			}
+			found := item.File != nil && "/"+item.File.NameInArchive == paths[i]
+			if missing := paths[i] == "/missing.txt" || paths[i] == "/dir/missing.txt"; missing {
+				if item.Code != http.StatusNotFound || item.ErrorType != "file_not_found" || item.File != nil {
+					t.Errorf("%s %s: code %d, error_type %q, want 404 file_not_found", link, paths[i], item.Code, item.ErrorType)
+				}
+			} else if item.Code != http.StatusOK || !found {
+				t.Errorf("%s %s: code %d, file %+v", link, paths[i], item.Code, item.File)
+			}
+		}
+	}
+
+	// 多个路径只遍历一次归档, 向源站的请求数与单个文件相同
+	origin.requests.Store(0)
+	getData[GetResp](t, r, query("/get", "link", origin.link("/a.tar"), "path", "/z.txt"))
+	single := origin.requests.Load()
+	origin.requests.Store(0)
+	batch("/a.tar", "/z.txt", "/dir/b.txt", "/a.txt")
+	if got := origin.requests.Load(); got != single {
+		t.Errorf("batch of 3: %d upstream requests, single get: %d", got, single)
+	}
+
+	for name, body := range map[string]string{
+		"no paths":  `{"link": "` + origin.link("/a.zip") + `"}`,
+		"bad paths": `{"link": "` + origin.link("/a.zip") + `", "paths": "/a.txt"}`,
+	} {
+		if code := decodeResp[json.RawMessage](t, post(t, r, "/batch-get", "application/json", []byte(body))).Code; code != 400 {
+			t.Errorf("%s: code = %d, want 400", name, code)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+// BodyCache 将解压后的文件内容缓存在磁盘上, 按总大小进行 LRU 淘汰
+type BodyCache struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	size  int64
+	ll    *list.List // 头部为最近使用
+	items map[string]*list.Element
+}
+
+type bodyCacheItem struct {
+	key     string
+	path    string
+	name    string
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+func NewBodyCache(dir string, maxBytes int64) (*BodyCache, error) {
+	// 索引只保存在内存中, 每次启动使用新的子目录
+	dir, err := privateDir(dir, "body-cache-*")
+	if err != nil {
+		return nil, err
+	}
+	return &BodyCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}, nil
+}
+
+// privateDir 在 dir 下创建本进程使用的子目录. dir 可能是 /tmp 等共享目录, 不能清空 dir 本身
+func privateDir(dir, pattern string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(dir, pattern)
+}
+
+// Close 删除缓存的文件
+func (bc *BodyCache) Close() error {
+	return os.RemoveAll(bc.dir)
+}
+
+// BodyCacheKey 根据链接, 文件路径和归档指纹生成缓存键
+func BodyCacheKey(link, filePath, fingerprint string) string {
+	sum := sha256.Sum256([]byte(link + "\x00" + filePath + "\x00" + fingerprint))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get 返回缓存的文件, Open 每次从头读取本地文件, 支持随机读取.
+// 文件在持有锁时打开, 之后被淘汰删除也能读取; 命中时调用方用完后需要关闭返回的 io.Closer
+func (bc *BodyCache) Get(key string) (stdArchiever.File, io.Closer, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	e, ok := bc.items[key]
+	if !ok {
+		return stdArchiever.File{}, nil, false
+	}
+	item := e.Value.(*bodyCacheItem)
+	file, err := os.Open(item.path)
+	if err != nil {
+		// 文件已被外部删除, 视为未命中
+		bc.ll.Remove(e)
+		delete(bc.items, key)
+		bc.size -= item.size
+		return stdArchiever.File{}, nil, false
+	}
+	bc.ll.MoveToFront(e)
+	return stdArchiever.File{
+		FileInfo:      cachedFileInfo{item},
+		NameInArchive: item.name,
+		Open: func() (io.ReadCloser, error) {
+			return cachedReader{io.NewSectionReader(file, 0, item.size)}, nil
+		},
+	}, file, true
+}
+
+// Tee 包装 f, 在完整读取文件内容的同时写入缓存
+func (bc *BodyCache) Tee(key string, f stdArchiever.File) stdArchiever.File {
+	open := f.Open
+	f.Open = func() (io.ReadCloser, error) {
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		tmp, err := os.CreateTemp(bc.dir, "tmp-*")
+		if err != nil {
+			return rc, nil
+		}
+		return &teeReadCloser{rc: rc, tmp: tmp, bc: bc, key: key, f: f}, nil
+	}
+	return f
+}
+
+func (bc *BodyCache) put(key, tmpPath string, f stdArchiever.File) {
+	item := &bodyCacheItem{
+		key:     key,
+		path:    filepath.Join(bc.dir, key),
+		name:    f.NameInArchive,
+		size:    f.Size(),
+		modTime: f.ModTime(),
+		mode:    f.Mode(),
+	}
+	if item.size > bc.maxBytes || os.Rename(tmpPath, item.path) != nil {
+		os.Remove(tmpPath)
+		return
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if e, ok := bc.items[key]; ok {
+		bc.size -= e.Value.(*bodyCacheItem).size
+		bc.ll.Remove(e)
+	}
+	bc.items[key] = bc.ll.PushFront(item)
+	bc.size += item.size
+	for bc.size > bc.maxBytes {
+		e := bc.ll.Back()
+		old := e.Value.(*bodyCacheItem)
+		bc.ll.Remove(e)
+		delete(bc.items, old.key)
+		bc.size -= old.size
+		// 正在读取的请求持有文件句柄, 删除不影响其读取
+		os.Remove(old.path)
+	}
+}
+
+type teeReadCloser struct {
+	rc      io.ReadCloser
+	tmp     *os.File
+	written int64
+	failed  bool
+
+	bc  *BodyCache
+	key string
+	f   stdArchiever.File
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.rc.Read(p)
+	if n > 0 && !t.failed {
+		if _, werr := t.tmp.Write(p[:n]); werr != nil {
+			t.failed = true
+		}
+		t.written += int64(n)
+	}
+	return n, err
+}
+
+func (t *teeReadCloser) Close() error {
+	err := t.rc.Close()
+	t.tmp.Close()
+	// 只缓存被完整读取的文件
+	if !t.failed && t.written == t.f.Size() {
+		t.bc.put(t.key, t.tmp.Name(), t.f)
+	} else {
+		os.Remove(t.tmp.Name())
+	}
+	return err
+}
+
+// cachedReader 读取 Get 打开的文件, Close 不关闭文件, 文件由 Get 的调用方关闭
+type cachedReader struct {
+	*io.SectionReader
+}
+
+func (cachedReader) Close() error { return nil }
+
+type cachedFileInfo struct {
+	item *bodyCacheItem
+}
+
+func (fi cachedFileInfo) Name() string       { return filepath.Base(fi.item.name) }
+func (fi cachedFileInfo) Size() int64        { return fi.item.size }
+func (fi cachedFileInfo) Mode() fs.FileMode  { return fi.item.mode }
+func (fi cachedFileInfo) ModTime() time.Time { return fi.item.modTime }
+func (fi cachedFileInfo) IsDir() bool        { return false }
+func (fi cachedFileInfo) Sys() any           { return nil }
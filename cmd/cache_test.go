@@ -0,0 +1,218 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+// memFile 构造内容在内存中的归档文件
+func memFile(t testing.TB, name, body string) stdArchiever.File {
+	t.Helper()
+	fsys := fstest.MapFS{name: {Data: []byte(body), ModTime: testModTime}}
+	fi, err := fsys.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdArchiever.File{
+		FileInfo:      fi,
+		NameInArchive: name,
+		Open:          func() (io.ReadCloser, error) { return fsys.Open(name) },
+	}
+}
+
+// readAll 打开并完整读取文件
+func readAll(t testing.TB, f stdArchiever.File) string {
+	t.Helper()
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// cached 判断 key 是否命中缓存, 命中时关闭打开的文件
+func cached(bc *BodyCache, key string) bool {
+	_, file, ok := bc.Get(key)
+	if ok {
+		file.Close()
+	}
+	return ok
+}
+
+func TestBodyCacheMissThenHit(t *testing.T) {
+	bc, err := NewBodyCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	key := BodyCacheKey("http://example.com/a.zip", "/a.txt", "fp")
+	if cached(bc, key) {
+		t.Fatal("empty cache should miss")
+	}
+	if got := readAll(t, bc.Tee(key, memFile(t, "a.txt", "alpha"))); got != "alpha" {
+		t.Fatalf("tee read %q", got)
+	}
+	f, file, ok := bc.Get(key)
+	if !ok {
+		t.Fatal("fully read file should be cached")
+	}
+	defer file.Close()
+	if got := readAll(t, f); got != "alpha" || f.Size() != 5 || !f.ModTime().Equal(testModTime) {
+		t.Errorf("cached file = %q, size %d, mod time %v", got, f.Size(), f.ModTime())
+	}
+
+	// 没有读完的文件不缓存
+	partial := BodyCacheKey("http://example.com/a.zip", "/b.txt", "fp")
+	rc, err := bc.Tee(partial, memFile(t, "b.txt", "beta")).Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = rc.Read(make([]byte, 2))
+	rc.Close()
+	if cached(bc, partial) {
+		t.Error("partially read file should not be cached")
+	}
+}
+
+func TestBodyCacheEviction(t *testing.T) {
+	bc, err := NewBodyCache(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	keyA, keyB, keyC := BodyCacheKey("l", "/a", "fp"), BodyCacheKey("l", "/b", "fp"), BodyCacheKey("l", "/c", "fp")
+	readAll(t, bc.Tee(keyA, memFile(t, "a", "aaaa")))
+	readAll(t, bc.Tee(keyB, memFile(t, "b", "bbbb")))
+	// 访问 a 后 b 成为最久未使用的文件
+	if !cached(bc, keyA) {
+		t.Fatal("a should be cached")
+	}
+	readAll(t, bc.Tee(keyC, memFile(t, "c", "cccc")))
+
+	if cached(bc, keyB) {
+		t.Error("least recently used b should be evicted")
+	}
+	if _, err := os.Stat(filepath.Join(bc.dir, keyB)); !os.IsNotExist(err) {
+		t.Errorf("evicted file should be removed, stat: %v", err)
+	}
+	for _, key := range []string{keyA, keyC} {
+		if !cached(bc, key) {
+			t.Errorf("%s should still be cached", key)
+		}
+	}
+
+	// 超过缓存总大小的文件不缓存
+	big := BodyCacheKey("l", "/big", "fp")
+	readAll(t, bc.Tee(big, memFile(t, "big", strings.Repeat("x", 11))))
+	if cached(bc, big) {
+		t.Error("file larger than the cache should not be cached")
+	}
+}
+
+func TestBodyCacheReadAfterEviction(t *testing.T) {
+	bc, err := NewBodyCache(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	keyA, keyB := BodyCacheKey("l", "/a", "fp"), BodyCacheKey("l", "/b", "fp")
+	readAll(t, bc.Tee(keyA, memFile(t, "a", "aaaaaa")))
+	f, file, ok := bc.Get(keyA)
+	if !ok {
+		t.Fatal("a should be cached")
+	}
+	defer file.Close()
+	// Get 之后 a 被淘汰删除, 已经取得的文件仍然可以读取
+	readAll(t, bc.Tee(keyB, memFile(t, "b", "bbbbbb")))
+	if _, err := os.Stat(filepath.Join(bc.dir, keyA)); !os.IsNotExist(err) {
+		t.Fatalf("a should be evicted, stat: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := readAll(t, f); got != "aaaaaa" {
+			t.Errorf("read %d after eviction = %q", i, got)
+		}
+	}
+
+	// 文件被外部删除时视为未命中
+	if err := os.Remove(filepath.Join(bc.dir, keyB)); err != nil {
+		t.Fatal(err)
+	}
+	if cached(bc, keyB) {
+		t.Error("removed file should miss")
+	}
+	if bc.size != 0 || bc.ll.Len() != 0 {
+		t.Errorf("after dropping the removed file: size %d, %d items", bc.size, bc.ll.Len())
+	}
+}
+
+func TestBodyCacheCloseKeepsParent(t *testing.T) {
+	parent := t.TempDir()
+	sentinel := filepath.Join(parent, "keep.txt")
+	if err := os.WriteFile(sentinel, []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBodyCache(parent, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, bc.Tee(BodyCacheKey("l", "/a", "fp"), memFile(t, "a", "alpha")))
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(bc.dir); !os.IsNotExist(err) {
+		t.Errorf("cache dir should be removed, stat: %v", err)
+	}
+	if _, err := os.Stat(sentinel); err != nil {
+		t.Errorf("files next to the cache dir should be kept: %v", err)
+	}
+}
+
+func TestDownBodyCache(t *testing.T) {
+	setupConf(t)
+	var err error
+	if bodyCache, err = NewBodyCache(t.TempDir(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	defer bodyCache.Close()
+	body := strings.Repeat("0123456789", 100)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: body}),
+	})
+	r := newTestRouter()
+	target := query("/down", "link", origin.link("/a.zip"), "path", "/a.txt")
+
+	// 第一次解压压缩的文件, 不支持 Range
+	w := get(t, r, target)
+	if w.Code != 200 || w.Body.String() != body {
+		t.Fatalf("miss: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if got := w.Header().Get("Accept-Ranges"); got != "none" {
+		t.Errorf("miss: Accept-Ranges = %q, want none", got)
+	}
+
+	// 之后从缓存的本地文件返回, 支持 Range
+	w = get(t, r, target, "Range", "bytes=10-19")
+	if w.Code != 206 || w.Body.String() != body[10:20] {
+		t.Fatalf("hit: status %d, body %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 10-19/1000" {
+		t.Errorf("hit: Content-Range = %q", got)
+	}
+	if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("hit: Accept-Ranges = %q, want bytes", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"net/http"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+)
+
+type ChecksumReq struct {
+	ArchiveReq
+	Path string `json:"path" form:"path"`
+	Algo string `json:"algo" form:"algo"`
+}
+
+type ChecksumResp struct {
+	Path     string `json:"path"`
+	Algo     string `json:"algo"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+	// Stored 为 true 时校验和取自归档头部, 没有解压文件
+	Stored bool `json:"stored"`
+}
+
+// checksumHash 返回 algo 对应的哈希, 除 /hash 支持的算法外还支持 crc32
+func checksumHash(algo string) (hash.Hash, bool) {
+	if algo == "crc32" {
+		return crc32.NewIEEE(), true
+	}
+	newHash, ok := hashAlgorithms[algo]
+	if !ok {
+		return nil, false
+	}
+	return newHash(), true
+}
+
+// Checksum 计算单个文件内容的校验和, 边解压边计算不缓存文件. zip 中的文件计算 crc32 时直接返回头部记录的值
+func Checksum(c *gin.Context) {
+	var req ChecksumReq
+	if err := c.ShouldBind(&req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	if req.Algo == "" {
+		req.Algo = "sha256"
+	}
+	h, ok := checksumHash(req.Algo)
+	if !ok {
+		ErrorStrResp(c, fmt.Sprintf("unknown algo %q, support crc32, md5, sha1, sha256", req.Algo), 400)
+		return
+	}
+
+	reqPath, err := handerReqPath(req.Path, false, req.PathEncoded)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	f, err := arc.ExtractFile(c, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+	if f.IsDir() {
+		ErrorStrResp(c, fmt.Sprintf("%s is a directory", reqPath), 400)
+		return
+	}
+
+	resp := ChecksumResp{Path: reqPath, Algo: req.Algo}
+	if crc, ok := archiver.StoredCRC32(*f); ok && req.Algo == "crc32" {
+		resp.Checksum, resp.Size, resp.Stored = fmt.Sprintf("%08x", crc), f.Size(), true
+		SuccessResp(c, resp)
+		return
+	}
+
+	budget := int64(-1)
+	if conf.HashMaxBytes > 0 {
+		budget = conf.HashMaxBytes
+	}
+	resp.Checksum, resp.Size, err = hashFile(c, *f, h, budget)
+	if errors.Is(err, ErrHashLimit) {
+		ErrorStrResp(c, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+	SuccessResp(c, resp)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	setupConf(t)
+	entries := []testEntry{{Name: "abc.txt", Body: "abc"}}
+	body := strings.Repeat("abc", 1000)
+	corrupt := zipBytes(t, testEntry{Name: "big.txt", Body: body})
+	// 破坏本地文件头之后的压缩数据, 中央目录中的 crc32 不变
+	for i := 30 + len("big.txt") + 2; i < 30+len("big.txt")+12; i++ {
+		corrupt[i] ^= 0xff
+	}
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip":       zipBytes(t, entries...),
+		"/a.tar":       tarBytes(t, entries...),
+		"/corrupt.zip": corrupt,
+	})
+	r := newTestRouter()
+	want := map[string]string{
+		"crc32":  "352441c2",
+		"md5":    "900150983cd24fb0d6963f7d28e17f72",
+		"sha1":   "a9993e364706816aba3e25717850c26c9cd0d89d",
+		"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	}
+
+	for _, link := range []string{"/a.zip", "/a.tar"} {
+		for algo, sum := range want {
+			resp := getData[ChecksumResp](t, r, query("/checksum", "link", origin.link(link), "path", "/abc.txt", "algo", algo))
+			if resp.Checksum != sum || resp.Size != 3 {
+				t.Errorf("%s %s: checksum %s, size %d, want %s, 3", link, algo, resp.Checksum, resp.Size, sum)
+			}
+			// 只有 zip 的 crc32 直接取头部记录的值
+			if wantStored := link == "/a.zip" && algo == "crc32"; resp.Stored != wantStored {
+				t.Errorf("%s %s: stored = %v, want %v", link, algo, resp.Stored, wantStored)
+			}
+		}
+	}
+
+	// 损坏压缩数据后 crc32 仍然从头部返回, 说明没有解压
+	resp := getData[ChecksumResp](t, r, query("/checksum", "link", origin.link("/corrupt.zip"), "path", "/big.txt", "algo", "crc32"))
+	if want := crc32.ChecksumIEEE([]byte(body)); !resp.Stored || resp.Checksum != fmt.Sprintf("%08x", want) {
+		t.Errorf("corrupt zip crc32: %+v, want stored %08x", resp, want)
+	}
+	if code := getCode(t, r, query("/checksum", "link", origin.link("/corrupt.zip"), "path", "/big.txt", "algo", "sha256")); code == 200 {
+		t.Error("corrupt zip sha256 should fail")
+	}
+
+	if code := getCode(t, r, query("/checksum", "link", origin.link("/a.zip"), "path", "/abc.txt", "algo", "md4")); code != 400 {
+		t.Errorf("unknown algo: code = %d, want 400", code)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// extractionSlots 限制同时从源站读取和解压的请求数, 为 nil 时不限制
+var extractionSlots chan struct{}
+
+// extractionPolicies -extraction-policy 支持的值: queue 排队等待空闲, reject 直接返回 503
+var extractionPolicies = map[string]bool{"queue": true, "reject": true}
+
+// limitExtractions 请求处理期间 (包括返回文件内容) 占用一个 extractionSlots.
+// 没有空闲时按 policy 排队或返回 503, 排队期间请求超时或客户端断开同样返回 503
+func limitExtractions(policy string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		select {
+		case extractionSlots <- struct{}{}:
+		default:
+			if policy == "reject" {
+				ErrorStrResp(c, "too many concurrent extractions, try again later", http.StatusServiceUnavailable)
+				return
+			}
+			select {
+			case extractionSlots <- struct{}{}:
+			case <-c.Request.Context().Done():
+				ErrorStrResp(c, fmt.Sprintf("waiting for an extraction slot: %v", c.Request.Context().Err()), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		defer func() { <-extractionSlots }()
+		c.Next()
+	}
+}
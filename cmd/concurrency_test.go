@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLimitExtractions(t *testing.T) {
+	setupConf(t)
+	const limit, clients = 2, 6
+	extractionSlots = make(chan struct{}, limit)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	// 源站响应变慢, 使请求互相重叠
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		time.Sleep(20 * time.Millisecond)
+		return false
+	}
+	var active, peak atomic.Int64
+	newRouter := func(policy string) *gin.Engine {
+		r := gin.New()
+		r.ContextWithFallback = true
+		g := r.Group("/")
+		g.Use(limitExtractions(policy), func(c *gin.Context) {
+			n := active.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			defer active.Add(-1)
+			c.Next()
+		})
+		archiveRoutes(g)
+		return r
+	}
+	target := query("/down", "link", origin.link("/a.zip"), "path", "/a.txt")
+
+	r := newRouter("queue")
+	var wg sync.WaitGroup
+	codes := make([]int, clients)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = get(t, r, target).Code
+		}(i)
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != 200 {
+			t.Errorf("queued request %d: status %d, want 200", i, code)
+		}
+	}
+	if p := peak.Load(); p != limit {
+		t.Errorf("peak concurrent extractions = %d, want %d", p, limit)
+	}
+
+	// 没有空闲时 reject 直接返回 503
+	for i := 0; i < limit; i++ {
+		extractionSlots <- struct{}{}
+	}
+	resp := decodeResp[json.RawMessage](t, get(t, newRouter("reject"), target))
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("reject when saturated: code %d, want 503", resp.Code)
+	}
+	for i := 0; i < limit; i++ {
+		<-extractionSlots
+	}
+}
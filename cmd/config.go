@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix 环境变量名的前缀, 例如 -max-per-page 对应 RADS_MAX_PER_PAGE
+const envPrefix = "RADS_"
+
+// configFlag 指定配置文件的参数, 本身只能通过命令行或 RADS_CONFIG 指定
+const configFlag = "config"
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfig 用环境变量和配置文件补充命令行中没有指定的参数, 优先级为 命令行 > 环境变量 > 配置文件 > 默认值.
+// path 为空时使用 RADS_CONFIG, 都为空时不读取配置文件
+func loadConfig(flags *flag.FlagSet, path string) error {
+	if path == "" {
+		path = os.Getenv(envName(configFlag))
+	}
+	file := make(map[string][]string)
+	if path != "" {
+		var err error
+		if file, err = readConfigFile(path); err != nil {
+			return err
+		}
+		for name := range file {
+			if name == configFlag || flags.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown option %q", path, name)
+			}
+		}
+	}
+
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || f.Name == configFlag {
+			return
+		}
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			values := []string{v}
+			if isRepeatable(f) {
+				values = splitList(v)
+			}
+			if e := setFlag(f, values); e != nil {
+				err = fmt.Errorf("%s=%q: %w", envName(f.Name), v, e)
+			}
+		} else if values, ok := file[f.Name]; ok {
+			if e := setFlag(f, values); e != nil {
+				err = fmt.Errorf("%s: %s: %w", path, f.Name, e)
+			}
+		}
+	})
+	return err
+}
+
+// readConfigFile 读取 YAML 或 JSON 配置文件, 键为参数名 (不带 -, 也可以用 _ 代替 -), 值为标量或列表
+func readConfigFile(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var nodes map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	file := make(map[string][]string, len(nodes))
+	for key, node := range nodes {
+		name := strings.ReplaceAll(key, "_", "-")
+		switch node.Kind {
+		case yaml.ScalarNode:
+			file[name] = []string{node.Value}
+		case yaml.SequenceNode:
+			values := make([]string, 0, len(node.Content))
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%s: %s: list items must be scalars", path, key)
+				}
+				values = append(values, item.Value)
+			}
+			file[name] = values
+		default:
+			return nil, fmt.Errorf("%s: %s: value must be a scalar or a list", path, key)
+		}
+	}
+	return file, nil
+}
+
+// isRepeatable 判断参数是否可以重复指定, 例如 -allow-cidr
+func isRepeatable(f *flag.Flag) bool {
+	_, ok := f.Value.(*cidrList)
+	return ok
+}
+
+// setFlag 设置参数的值, 可重复的参数逐个设置, 其余参数的列表用逗号连接
+func setFlag(f *flag.Flag, values []string) error {
+	if !isRepeatable(f) {
+		return f.Value.Set(strings.Join(values, ","))
+	}
+	for _, v := range values {
+		if err := f.Value.Set(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testFlags 构造与 main 相同方式定义的参数
+type testFlags struct {
+	set                    *flag.FlagSet
+	port, perPage, timeout *int
+	referer                *string
+	cidrs                  cidrList
+}
+
+func newTestFlags() *testFlags {
+	f := &testFlags{set: flag.NewFlagSet("test", flag.ContinueOnError)}
+	f.set.SetOutput(io.Discard)
+	f.set.String(configFlag, "", "config file")
+	f.port = f.set.Int("port", 8080, "port")
+	f.perPage = f.set.Int("max-per-page", 1000, "max per page")
+	f.timeout = f.set.Int("timeout", 30, "timeout")
+	f.referer = f.set.String("referer", "none", "referer")
+	f.set.Var(&f.cidrs, "allow-cidr", "allowed cidr")
+	return f
+}
+
+// writeConfig 在临时目录中写入配置文件
+func writeConfig(t testing.TB, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "port: 1\nmax_per_page: 2\nreferer: file\nallow-cidr:\n  - 10.0.0.0/8\n  - 192.168.0.0/16\n")
+	t.Setenv("RADS_MAX_PER_PAGE", "20")
+	t.Setenv("RADS_REFERER", "env")
+
+	f := newTestFlags()
+	if err := f.set.Parse([]string{"-referer", "flag"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(f.set, path); err != nil {
+		t.Fatal(err)
+	}
+	// 命令行 > 环境变量 > 配置文件 > 默认值
+	if *f.referer != "flag" || *f.perPage != 20 || *f.port != 1 || *f.timeout != 30 {
+		t.Errorf("referer %q, max-per-page %d, port %d, timeout %d, want flag, 20, 1, 30", *f.referer, *f.perPage, *f.port, *f.timeout)
+	}
+	if got := f.cidrs.String(); got != "10.0.0.0/8,192.168.0.0/16" {
+		t.Errorf("allow-cidr from the config list = %q", got)
+	}
+
+	// 环境变量中的可重复参数用逗号分隔, 并覆盖配置文件
+	t.Setenv("RADS_ALLOW_CIDR", "172.16.0.0/12, 127.0.0.0/8")
+	f = newTestFlags()
+	if err := loadConfig(f.set, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.cidrs.String(); got != "172.16.0.0/12,127.0.0.0/8" || *f.referer != "env" {
+		t.Errorf("allow-cidr %q, referer %q, want the environment values", got, *f.referer)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	// JSON 也是有效的 YAML; 没有指定路径时使用 RADS_CONFIG
+	t.Setenv("RADS_CONFIG", writeConfig(t, "config.json", `{"port": 9000, "referer": "origin"}`))
+	f := newTestFlags()
+	if err := loadConfig(f.set, ""); err != nil {
+		t.Fatal(err)
+	}
+	if *f.port != 9000 || *f.referer != "origin" {
+		t.Errorf("port %d, referer %q from RADS_CONFIG", *f.port, *f.referer)
+	}
+
+	for name, content := range map[string]string{
+		"unknown option": "prot: 1\n",
+		"nested value":   "port:\n  value: 1\n",
+		"invalid value":  "port: eighty\n",
+		"config in file": "config: other.yaml\n",
+	} {
+		if err := loadConfig(newTestFlags().set, writeConfig(t, "config.yaml", content)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+	t.Setenv("RADS_PORT", "eighty")
+	if err := loadConfig(newTestFlags().set, writeConfig(t, "empty.yaml", "{}")); err == nil {
+		t.Error("invalid environment value: want an error")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsExposeHeaders 浏览器中的脚本可以读取的响应头, 范围请求需要 Content-Range 和 Accept-Ranges
+var corsExposeHeaders = []string{
+	"Content-Range", "Accept-Ranges", "Content-Length", "Content-Disposition", "ETag", "Last-Modified",
+	"Retry-After", "X-Entry-Type", "X-Entry-Size", "X-Members-Written", "X-Bytes-Written",
+}
+
+// splitList 按逗号拆分参数, 去掉空白和空项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// cors 允许 origins 中的来源跨域访问, origins 包含 * 时允许任意来源. 预检请求直接返回 204
+func cors(origins []string, methods, headers string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[strings.TrimSuffix(o, "/")] = true
+	}
+	expose := strings.Join(corsExposeHeaders, ", ")
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !allowed["*"] && !allowed[origin] {
+			// 不设置 CORS 头部, 由浏览器拒绝
+			if preflight {
+				c.AbortWithStatus(http.StatusNoContent)
+			}
+			return
+		}
+		if allowed["*"] {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", methods)
+			header.Set("Access-Control-Allow-Headers", headers)
+			header.Set("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		header.Set("Access-Control-Expose-Headers", expose)
+		c.Next()
+	}
+}
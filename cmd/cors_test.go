@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORS(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	newRouter := func(origins ...string) *gin.Engine {
+		r := gin.New()
+		r.ContextWithFallback = true
+		r.Use(cors(origins, "GET, POST, HEAD", "Content-Type, Range"))
+		archiveRoutes(r)
+		return r
+	}
+	preflight := func(r http.Handler, from string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/down", nil)
+		req.Header.Set("Origin", from)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		req.Header.Set("Access-Control-Request-Headers", "Range")
+		return doRequest(t, r, req)
+	}
+	target := query("/down", "link", origin.link("/a.zip"), "path", "/a.txt")
+
+	r := newRouter("https://app.example", "https://other.example/")
+	w := preflight(r, "https://app.example")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example" ||
+		w.Header().Get("Access-Control-Allow-Methods") != "GET, POST, HEAD" || w.Header().Get("Access-Control-Allow-Headers") != "Content-Type, Range" {
+		t.Errorf("preflight: status %d, headers %v", w.Code, w.Header())
+	}
+	// 配置中末尾的 / 不影响匹配
+	if got := preflight(r, "https://other.example").Header().Get("Access-Control-Allow-Origin"); got != "https://other.example" {
+		t.Errorf("preflight from other.example: Access-Control-Allow-Origin = %q", got)
+	}
+	if w := preflight(r, "https://evil.example"); w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("preflight from a disallowed origin: status %d, Access-Control-Allow-Origin %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	w = get(t, r, target, "Origin", "https://app.example", "Range", "bytes=0-1")
+	if w.Code != http.StatusPartialContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example" {
+		t.Errorf("range request: status %d, Access-Control-Allow-Origin %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	expose := w.Header().Get("Access-Control-Expose-Headers")
+	for _, name := range []string{"Content-Range", "Accept-Ranges", "Content-Disposition"} {
+		if !strings.Contains(expose, name) {
+			t.Errorf("Access-Control-Expose-Headers %q should contain %s", expose, name)
+		}
+	}
+	if !strings.Contains(strings.Join(w.Header().Values("Vary"), ","), "Origin") {
+		t.Errorf("Vary = %v, want Origin", w.Header().Values("Vary"))
+	}
+
+	r = newRouter("*")
+	if got := get(t, r, target, "Origin", "https://any.example").Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("wildcard: Access-Control-Allow-Origin = %q, want *", got)
+	}
+	// 非浏览器请求不加 CORS 头部
+	if got := get(t, r, target).Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("without Origin: Access-Control-Allow-Origin = %q", got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+// ErrInvalidCursor cursor 不是之前的 /list 返回的 next_cursor, 或者属于参数不同的请求
+var ErrInvalidCursor = errors.New("invalid cursor, pass next_cursor of the previous page with the same parameters")
+
+// listCursor /list 游标中保存的内容, Query 为请求参数的摘要, 参数改变后游标失效
+type listCursor struct {
+	Entry int    `json:"e"`
+	Name  string `json:"n"`
+	Query string `json:"q"`
+}
+
+// cursorQuery 返回除分页参数外请求参数的摘要
+func cursorQuery(req ListReq) string {
+	req.PageReq, req.Cursor = PageReq{}, nil
+	params, _ := json.Marshal(req)
+	sum := sha256.Sum256(params)
+	return hex.EncodeToString(sum[:8])
+}
+
+func encodeCursor(req *ListReq, pc *archiver.PageCursor) string {
+	data, _ := json.Marshal(listCursor{Entry: pc.Entry, Name: pc.Name, Query: cursorQuery(*req)})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor 解析请求中的 cursor, 为空时从头开始
+func decodeCursor(req *ListReq) (archiver.PageCursor, error) {
+	if *req.Cursor == "" {
+		return archiver.PageCursor{}, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(*req.Cursor)
+	if err != nil {
+		return archiver.PageCursor{}, ErrInvalidCursor
+	}
+	var lc listCursor
+	if err := json.Unmarshal(data, &lc); err != nil || lc.Entry <= 0 || lc.Query != cursorQuery(*req) {
+		return archiver.PageCursor{}, ErrInvalidCursor
+	}
+	return archiver.PageCursor{Entry: lc.Entry, Name: lc.Name}, nil
+}
+
+// listByCursor 处理带 cursor 的 /list 请求, 按遍历顺序返回 per_page 个条目和下一页的 next_cursor.
+// 每页只遍历到取够条目为止, 不需要列出之前的所有条目
+func listByCursor(c *gin.Context, req *ListReq, reqPath string) {
+	// 游标是遍历顺序中的位置, 不能与改变顺序或需要完整结果的参数一起使用
+	if req.Page != 0 || req.Sort != "" || req.Shuffle != nil || req.Group != "" || req.Glob != "" || req.Sniff ||
+		req.DirsFirst || req.WithSize || req.Spill || req.Prefetch {
+		ErrorStrResp(c, "cursor can not be used with page, sort, shuffle, group, glob, sniff, dirs_first, with_size, spill or prefetch", 400)
+		return
+	}
+	after, err := decodeCursor(req)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	limit := req.PerPage
+	if limit <= 0 {
+		limit = conf.DefaultPerPage
+	}
+	limit = min(limit, conf.MaxPerPage)
+	depth := 1
+	if req.Cascade {
+		depth = -1
+		if req.Depth != nil {
+			depth = *req.Depth
+		}
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+	exts := parseExts(req.Ext)
+	dFiles, next, err := arc.PageAfter(c, reqPath, depth, after, limit, func(f stdArchiever.File) bool {
+		obj := listObj(arc, &f, reqPath)
+		return req.SizeFilter.match(obj) && matchType(obj, req.Type) && matchExts(obj, exts)
+	})
+	if errors.Is(err, archiver.ErrCursorNotFound) {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	} else if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	objs := make([]ObjResp, 0, len(dFiles))
+	for _, f := range dFiles {
+		objs = append(objs, listObj(arc, &f, reqPath))
+	}
+	resp := ListResp{
+		ArchiveMeta: arc.Meta(),
+		Content:     objs,
+		Total:       int64(len(objs)),
+		TotalSize:   sumSize(objs),
+	}
+	if next != nil {
+		resp.NextCursor = encodeCursor(req, next)
+	}
+	SuccessResp(c, resp)
+}
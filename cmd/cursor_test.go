@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestListCursor(t *testing.T) {
+	setupConf(t)
+	var entries []testEntry
+	for i := 0; i < 23; i++ {
+		entries = append(entries, testEntry{Name: fmt.Sprintf("d%d/f%02d.txt", i%3, i), Body: "x"})
+	}
+	entries = append(entries, testEntry{Name: "top.txt", Body: "t"}, testEntry{Name: "empty/"})
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, entries...), "/a.tar": tarBytes(t, entries...)})
+	r := newTestRouter()
+	// pages 按游标依次请求, 直到没有 next_cursor
+	pages := func(kv ...string) ([]string, int) {
+		t.Helper()
+		var all []string
+		cursor, n := "", 0
+		for ; n == 0 || cursor != ""; n++ {
+			if n > 100 {
+				t.Fatalf("%v: cursor does not end", kv)
+			}
+			resp := getData[ListResp](t, r, query("/list", append(kv, "cursor", cursor, "per_page", "4")...))
+			if len(resp.Content) > 4 {
+				t.Fatalf("%v: %d entries on one page", kv, len(resp.Content))
+			}
+			all = append(all, names(resp.Content)...)
+			cursor = resp.NextCursor
+		}
+		return all, n
+	}
+
+	for _, link := range []string{"/a.zip", "/a.tar"} {
+		for _, kv := range [][]string{
+			{"link", origin.link(link), "cascade", "true"},
+			{"link", origin.link(link)},
+			{"link", origin.link(link), "path", "/d1"},
+		} {
+			want := names(getData[ListResp](t, r, query("/list", append(kv, "per_page", "100")...)).Content)
+			got, n := pages(kv...)
+			// 没有遗漏和重复
+			seen := map[string]bool{}
+			for _, name := range got {
+				if seen[name] {
+					t.Errorf("%s %v: %s listed twice", link, kv[2:], name)
+				}
+				seen[name] = true
+			}
+			if len(got) != len(want) || len(seen) != len(want) {
+				t.Errorf("%s %v: %d entries in %d pages, want %d", link, kv[2:], len(got), n, len(want))
+			}
+			for _, name := range want {
+				if !seen[name] {
+					t.Errorf("%s %v: %s missing", link, kv[2:], name)
+				}
+			}
+		}
+	}
+
+	// 游标与请求参数绑定
+	first := getData[ListResp](t, r, query("/list", "link", origin.link("/a.zip"), "cascade", "true", "cursor", "", "per_page", "4"))
+	if first.NextCursor == "" {
+		t.Fatal("first page: no next_cursor")
+	}
+	for name, kv := range map[string][]string{
+		"garbage":      {"link", origin.link("/a.zip"), "cascade", "true", "cursor", "not-a-cursor"},
+		"other params": {"link", origin.link("/a.zip"), "cursor", first.NextCursor},
+		"with sort":    {"link", origin.link("/a.zip"), "cascade", "true", "cursor", first.NextCursor, "sort", "name"},
+	} {
+		if code := decodeResp[json.RawMessage](t, get(t, r, query("/list", kv...))).Code; code != 400 {
+			t.Errorf("%s: code = %d, want 400", name, code)
+		}
+	}
+	// 不带 cursor 时仍按页码分页
+	offset := getData[ListResp](t, r, query("/list", "link", origin.link("/a.zip"), "cascade", "true", "page", "1", "per_page", "4"))
+	if offset.NextCursor != "" || offset.Total <= 4 || !reflect.DeepEqual(names(offset.Content), names(first.Content)) {
+		t.Errorf("offset mode: total %d, next_cursor %q, page %v, cursor page %v", offset.Total, offset.NextCursor, names(offset.Content), names(first.Content))
+	}
+}
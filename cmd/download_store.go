@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/snabb/httpreaderat"
+)
+
+// ErrIntegrity 下载的归档与源站 Content-MD5 或 Digest 头部中的摘要不一致
+var ErrIntegrity = errors.New("archive from the origin failed the integrity check")
+
+// IntegrityError 下载的归档与源站提供的摘要不一致
+type IntegrityError struct {
+	// Header 摘要所在的头部, Algorithm 为摘要算法
+	Header    string
+	Algorithm string
+	Want, Got string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("%v: %s %s is %s, got %s", ErrIntegrity, e.Header, e.Algorithm, e.Want, e.Got)
+}
+
+func (e *IntegrityError) Unwrap() error { return ErrIntegrity }
+
+// downloadStore 源站不支持 Range 时保存完整归档的临时文件, 超过 limit 时返回 httpreaderat.ErrStoreLimit
+type downloadStore struct {
+	*httpreaderat.LimitedStore
+	// used 是否已下载
+	used bool
+	// header 返回源站响应的头部, 用于校验下载的内容
+	header func() http.Header
+	// err 校验失败的错误, httpreaderat 包装后无法用 errors.As 取出
+	err error
+}
+
+func newDownloadStore(limit int64, header func() http.Header) *downloadStore {
+	return &downloadStore{LimitedStore: httpreaderat.NewLimitedStore(httpreaderat.NewStoreFile(), limit+1, nil), header: header}
+}
+
+func (s *downloadStore) ReadFrom(r io.Reader) (int64, error) {
+	s.used = true
+	digest := newBodyDigest(s.header())
+	if digest != nil {
+		r = io.TeeReader(r, digest.h)
+	}
+	n, err := s.LimitedStore.ReadFrom(r)
+	if err == nil && digest != nil {
+		err = digest.verify()
+		s.err = err
+	}
+	return n, err
+}
+
+// bodyDigest 按源站头部中的摘要计算下载内容的摘要
+type bodyDigest struct {
+	header, alg string
+	want        []byte
+	h           hash.Hash
+}
+
+// digestAlgs Digest 头部中支持的算法
+var digestAlgs = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// newBodyDigest 从 Content-MD5 或 Digest (RFC 3230) 头部中取出摘要, 都没有时返回 nil.
+// Digest 中有多个摘要时使用第一个支持的算法
+func newBodyDigest(header http.Header) *bodyDigest {
+	if v := header.Get("Content-MD5"); v != "" {
+		if want, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v)); err == nil {
+			return &bodyDigest{header: "Content-MD5", alg: "md5", want: want, h: md5.New()}
+		}
+	}
+	for _, v := range strings.Split(header.Get("Digest"), ",") {
+		alg, value, ok := strings.Cut(strings.TrimSpace(v), "=")
+		alg = strings.ToLower(alg)
+		newHash, supported := digestAlgs[alg]
+		if !ok || !supported {
+			continue
+		}
+		if want, err := base64.StdEncoding.DecodeString(value); err == nil {
+			return &bodyDigest{header: "Digest", alg: alg, want: want, h: newHash()}
+		}
+	}
+	return nil
+}
+
+func (d *bodyDigest) verify() error {
+	if got := d.h.Sum(nil); !bytes.Equal(got, d.want) {
+		return &IntegrityError{
+			Header:    d.header,
+			Algorithm: d.alg,
+			Want:      base64.StdEncoding.EncodeToString(d.want),
+			Got:       base64.StdEncoding.EncodeToString(got),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDownloadIntegrity(t *testing.T) {
+	setupConf(t)
+	conf.MaxCacheFileBytes = 1 << 20
+	data := zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})
+	md5Sum := md5.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	wrong := md5.Sum([]byte("something else"))
+	b64 := base64.StdEncoding.EncodeToString
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		code   int
+	}{
+		{"no digest", nil, 200},
+		{"matching Content-MD5", http.Header{"Content-Md5": {b64(md5Sum[:])}}, 200},
+		{"mismatching Content-MD5", http.Header{"Content-Md5": {b64(wrong[:])}}, 502},
+		{"matching Digest", http.Header{"Digest": {"unknown=abc, SHA-256=" + b64(sha256Sum[:])}}, 200},
+		{"mismatching Digest", http.Header{"Digest": {"md5=" + b64(wrong[:])}}, 502},
+	} {
+		origin := newTestOrigin(t, map[string][]byte{"/a.zip": data})
+		origin.noRange = true
+		origin.header["/a.zip"] = tc.header
+		resp := decodeResp[json.RawMessage](t, get(t, newTestRouter(), query("/list", "link", origin.link("/a.zip"))))
+		if resp.Code != tc.code {
+			t.Errorf("%s: code = %d, want %d (%s)", tc.name, resp.Code, tc.code, resp.Message)
+		}
+		if tc.code == 502 && resp.ErrorType != "integrity_mismatch" {
+			t.Errorf("%s: error_type = %q, want integrity_mismatch", tc.name, resp.ErrorType)
+		}
+	}
+}
+
+func TestRangeReadSkipsIntegrity(t *testing.T) {
+	setupConf(t)
+	data := zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})
+	wrong := md5.Sum([]byte("something else"))
+	// 按 Range 读取时不会取得完整内容, 不校验
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": data})
+	origin.header["/a.zip"] = http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(wrong[:])}}
+	if code := getCode(t, newTestRouter(), query("/list", "link", origin.link("/a.zip"))); code != 200 {
+		t.Errorf("code = %d, want 200", code)
+	}
+}
+
+func TestOriginWithoutRange(t *testing.T) {
+	setupConf(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	data := zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"}, testEntry{Name: "dir/b.txt", Body: "beta"})
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": data})
+	origin.noRange = true
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	if code := getCode(t, r, query("/list", "link", link)); code != 502 {
+		t.Errorf("without -max-cache-file-bytes: code = %d, want 502", code)
+	}
+
+	conf.MaxCacheFileBytes = 1 << 20
+	origin.requests.Store(0)
+	list := getData[ListResp](t, r, query("/list", "link", link))
+	if got, want := names(list.Content), []string{"a.txt", "dir/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+	// 归档只下载一次, 之后从临时文件读取
+	if n := origin.requests.Load(); n != 1 {
+		t.Errorf("origin received %d requests, want 1", n)
+	}
+	// 请求结束 (context 取消) 后删除临时文件
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, query("/down", "link", link, "path", "/dir/b.txt"), nil)
+	if w := doRequest(t, r, req.WithContext(ctx)); w.Code != 200 || w.Body.String() != "beta" {
+		t.Errorf("down: status %d, body %q", w.Code, w.Body.String())
+	}
+	before, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	var left []os.DirEntry
+	for i := 0; i < 100; i++ {
+		if left, err = os.ReadDir(tmp); err != nil || len(left) < len(before) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(left) != len(before)-1 {
+		t.Errorf("temporary files before the request ended: %d, after: %d", len(before), len(left))
+	}
+
+	conf.MaxCacheFileBytes = int64(len(data) - 1)
+	if code := getCode(t, r, query("/list", "link", link)); code != 413 {
+		t.Errorf("archive over the limit: code = %d, want 413", code)
+	}
+	// 打开失败时没有需要关闭的源站
+	if code := getCode(t, r, query("/list", "link", origin.link("/missing.zip"))); code != 502 {
+		t.Errorf("missing archive: code = %d, want 502", code)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	yzip "github.com/yeka/zip"
+)
+
+// encryptedZipBytes 构造所有文件都用 password 加密的 zip
+func encryptedZipBytes(t testing.TB, password string, method yzip.EncryptionMethod, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := yzip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Encrypt(e.Name, password, method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, e.Body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownEncryptedZip(t *testing.T) {
+	setupConf(t)
+	var err error
+	if bodyCache, err = NewBodyCache(t.TempDir(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	defer bodyCache.Close()
+	secret := strings.Repeat("top secret\n", 50)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/zipcrypto.zip": encryptedZipBytes(t, "secret", yzip.StandardEncryption, testEntry{Name: "a.txt", Body: secret}),
+		"/aes.zip":       encryptedZipBytes(t, "secret", yzip.AES256Encryption, testEntry{Name: "a.txt", Body: secret}),
+	})
+	r := newTestRouter()
+
+	for _, link := range []string{"/zipcrypto.zip", "/aes.zip"} {
+		// 不需要密码即可列出文件
+		list := getData[ListResp](t, r, query("/list", "link", origin.link(link)))
+		if got := names(list.Content); !reflect.DeepEqual(got, []string{"a.txt"}) {
+			t.Errorf("%s: entries = %v", link, got)
+		}
+
+		target := func(password string) string {
+			return query("/down", "link", origin.link(link), "path", "/a.txt", "password", password)
+		}
+		w := get(t, r, target("secret"))
+		if w.Code != 200 || w.Body.String() != secret {
+			t.Errorf("%s: correct password: status %d, body %q", link, w.Code, w.Body.String())
+		}
+		// 解密后的内容不能通过缓存返回给没有密码的请求
+		for password, want := range map[string]string{"": "archive is encrypted", "wrong": "archive is encrypted: wrong password"} {
+			resp := decodeResp[json.RawMessage](t, get(t, r, target(password)))
+			if resp.Code != 403 || resp.Message != want {
+				t.Errorf("%s: password %q: code %d, message %q, want 403 %q", link, password, resp.Code, resp.Message, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorTypes(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip":     zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"}),
+		"/notes.txt": []byte("just some text, not an archive"),
+	})
+	closed := httptest.NewServer(nil)
+	closed.Close()
+	r := newTestRouter()
+
+	for name, tc := range map[string]struct {
+		target  string
+		code    int
+		errType string
+	}{
+		"unsupported format": {query("/list", "link", origin.link("/notes.txt")), 415, "unsupported_format"},
+		"origin not found":   {query("/list", "link", origin.link("/missing.zip")), 502, "upstream_unreachable"},
+		"origin down":        {query("/down", "link", closed.URL+"/a.zip", "path", "/a.txt"), 502, "upstream_unreachable"},
+		"file not found":     {query("/down", "link", origin.link("/a.zip"), "path", "/missing.txt"), 404, "file_not_found"},
+		// 不能归类的错误不返回 error_type
+		"bad request": {query("/down", "link", origin.link("/a.zip"), "path", "/../a.txt"), 400, ""},
+	} {
+		resp := decodeResp[json.RawMessage](t, get(t, r, tc.target))
+		if resp.Code != tc.code || resp.ErrorType != tc.errType {
+			t.Errorf("%s: code %d, error_type %q (%s), want %d %q", name, resp.Code, resp.ErrorType, resp.Message, tc.code, tc.errType)
+		}
+	}
+}
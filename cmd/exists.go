@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+)
+
+type ExistsReq struct {
+	ArchiveReq
+	Path string `json:"path" form:"path"`
+}
+
+// Exists 检查归档内的路径是否存在, 存在时返回 200 并在头部返回类型, 大小等信息, 不存在时返回 404, 都不返回内容
+func Exists(c *gin.Context) {
+	var req ExistsReq
+	if err := c.ShouldBind(&req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	reqPath, err := handerReqPath(req.Path, false, req.PathEncoded)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	f, err := arc.StatEntry(c, reqPath)
+	if errors.Is(err, archiver.ErrFileNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	header := c.Writer.Header()
+	if f.IsDir() {
+		header.Set("X-Entry-Type", "dir")
+	} else {
+		header.Set("X-Entry-Type", "file")
+		header.Set("X-Entry-Size", strconv.FormatInt(f.Size(), 10))
+		header.Set("Content-Type", mimeByName(f.Name()))
+	}
+	if !f.ModTime().IsZero() {
+		header.Set("Last-Modified", f.ModTime().UTC().Format(http.TimeFormat))
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"time"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+type HashReq struct {
+	ArchiveReq
+	Path      string `json:"path"      form:"path"`
+	Algorithm string `json:"algorithm" form:"algorithm"`
+}
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// hashSlots 限制同时进行的 /hash 任务数
+var hashSlots chan struct{}
+
+// ErrHashLimit 需要计算的内容超过 -hash-max-bytes
+var ErrHashLimit = errors.New("archive exceeds the hash size limit")
+
+// hashProgressInterval 两次 progress 事件的最小间隔
+const hashProgressInterval = 200 * time.Millisecond
+
+type hashProgress struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+	// 可以随机读取的归档预先统计总数, 其余归档省略
+	TotalFiles *int   `json:"total_files,omitempty"`
+	TotalBytes *int64 `json:"total_bytes,omitempty"`
+}
+
+type HashEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Hash  string `json:"hash,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type HashManifest struct {
+	Algorithm string      `json:"algorithm"`
+	Bytes     int64       `json:"bytes"`
+	Files     []HashEntry `json:"files"`
+}
+
+// ctxReader 在 ctx 取消后停止读取
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Hash 计算目录下每个文件内容的哈希, 以 SSE 返回进度 (progress), 完成后返回清单 (manifest), 出错时返回 error.
+// 客户端断开连接时停止计算
+func Hash(c *gin.Context) {
+	var req HashReq
+	if err := c.ShouldBind(&req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	if req.Algorithm == "" {
+		req.Algorithm = "sha256"
+	}
+	newHash, ok := hashAlgorithms[req.Algorithm]
+	if !ok {
+		ErrorStrResp(c, fmt.Sprintf("unknown algorithm %q, support md5, sha1, sha256", req.Algorithm), 400)
+		return
+	}
+
+	reqPath, err := handerReqPath(req.Path, true, req.PathEncoded)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	select {
+	case hashSlots <- struct{}{}:
+		defer func() { <-hashSlots }()
+	default:
+		ErrorStrResp(c, "too many hash jobs, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	var progress hashProgress
+	if ok, _ := arc.RandomAccess(); ok {
+		files, err := arc.CascadeExtractDirs(c, reqPath)
+		if err != nil {
+			ExtractErrorResp(c, err)
+			return
+		}
+		var totalFiles int
+		var totalBytes int64
+		for _, f := range files {
+			if !f.IsDir() {
+				totalFiles++
+				totalBytes += max(f.Size(), 0)
+			}
+		}
+		progress.TotalFiles, progress.TotalBytes = &totalFiles, &totalBytes
+	}
+
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	send := func(event string, data any) {
+		c.SSEvent(event, data)
+		c.Writer.Flush()
+	}
+
+	ctx := c.Request.Context()
+	manifest := HashManifest{Algorithm: req.Algorithm, Files: make([]HashEntry, 0)}
+	var lastProgress time.Time
+	err = arc.CascadeWalkDirs(ctx, reqPath, func(ctx context.Context, f stdArchiever.File) error {
+		if f.IsDir() {
+			return nil
+		}
+		entry := HashEntry{Path: f.NameInArchive, Size: f.Size()}
+		budget := int64(-1)
+		if conf.HashMaxBytes > 0 {
+			budget = conf.HashMaxBytes - manifest.Bytes
+		}
+		sum, n, err := hashFile(ctx, f, newHash(), budget)
+		manifest.Bytes += n
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.Is(err, ErrHashLimit), errors.Is(err, archiver.ErrDecompressedLimit):
+			return err
+		case err != nil:
+			// 无法读取的文件 (例如加密) 记录错误后继续
+			entry.Error = err.Error()
+		default:
+			entry.Hash = sum
+		}
+		manifest.Files = append(manifest.Files, entry)
+
+		progress.Path, progress.Files, progress.Bytes = entry.Path, len(manifest.Files), manifest.Bytes
+		if time.Since(lastProgress) >= hashProgressInterval {
+			lastProgress = time.Now()
+			send("progress", progress)
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		// 客户端已断开
+		return
+	}
+	if err != nil {
+		code := 500
+		if errors.Is(err, ErrHashLimit) || errors.Is(err, archiver.ErrDecompressedLimit) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		send("error", Resp[interface{}]{Code: code, Message: err.Error()})
+		return
+	}
+	send("progress", progress)
+	send("manifest", manifest)
+}
+
+// hashFile 计算文件内容的哈希, budget 不小于 0 时读取超过 budget 字节返回 ErrHashLimit
+func hashFile(ctx context.Context, f stdArchiever.File, h hash.Hash, budget int64) (string, int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", 0, err
+	}
+	defer rc.Close()
+	var r io.Reader = ctxReader{ctx: ctx, r: rc}
+	if budget >= 0 {
+		r = io.LimitReader(r, budget+1)
+	}
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	if budget >= 0 && n > budget {
+		return "", n, ErrHashLimit
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sseEvent 一个 SSE 事件
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// parseSSE 解析 gin 写入的 SSE 事件
+func parseSSE(body string) []sseEvent {
+	var events []sseEvent
+	for _, block := range strings.Split(body, "\n\n") {
+		var ev sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			if v, ok := strings.CutPrefix(line, "event:"); ok {
+				ev.Event = v
+			} else if v, ok := strings.CutPrefix(line, "data:"); ok {
+				ev.Data = v
+			}
+		}
+		if ev.Event != "" {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+func TestHashManifest(t *testing.T) {
+	setupConf(t)
+	bodies := map[string]string{"a.txt": "alpha", "dir/b.txt": strings.Repeat("beta", 1000)}
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: bodies["a.txt"]}, testEntry{Name: "dir/b.txt", Body: bodies["dir/b.txt"]}),
+	})
+	r := newTestRouter()
+
+	w := get(t, r, query("/hash", "link", origin.link("/a.zip")))
+	events := parseSSE(w.Body.String())
+	if len(events) == 0 || events[len(events)-1].Event != "manifest" {
+		t.Fatalf("events = %+v", events)
+	}
+	var manifest HashManifest
+	if err := json.Unmarshal([]byte(events[len(events)-1].Data), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Algorithm != "sha256" || len(manifest.Files) != len(bodies) {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+	for _, f := range manifest.Files {
+		sum := sha256.Sum256([]byte(bodies[f.Path]))
+		if f.Hash != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: hash %s", f.Path, f.Hash)
+		}
+	}
+
+	// 没有空闲名额时拒绝
+	for i := 0; i < cap(hashSlots); i++ {
+		hashSlots <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < cap(hashSlots); i++ {
+			<-hashSlots
+		}
+	}()
+	if code := getCode(t, r, query("/hash", "link", origin.link("/a.zip"))); code != http.StatusServiceUnavailable {
+		t.Errorf("busy: code = %d, want 503", code)
+	}
+}
+
+func TestHashCancel(t *testing.T) {
+	setupConf(t)
+	const files, size = 50, 64 << 10
+	var entries []testEntry
+	for i := 0; i < files; i++ {
+		entries = append(entries, testEntry{Name: fmt.Sprintf("f%02d.bin", i), Body: strings.Repeat(fmt.Sprint(i%10), size), Stored: true})
+	}
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, entries...)})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 读取了一部分文件后客户端断开
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if origin.requests.Load() == 10 {
+			cancel()
+		}
+		return false
+	}
+	r := newTestRouter()
+
+	req := httptest.NewRequest(http.MethodGet, query("/hash", "link", origin.link("/a.zip"), "buf_size", fmt.Sprint(size)), nil)
+	w := doRequest(t, r, req.WithContext(ctx))
+	for _, ev := range parseSSE(w.Body.String()) {
+		if ev.Event == "manifest" {
+			t.Error("cancelled hash should not send a manifest")
+		}
+	}
+	if n := origin.requests.Load(); n > 12 {
+		t.Errorf("origin received %d requests after cancelling at 10, want the walk to stop", n)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hopByHopHeaders 只对单跳连接有效或由 httpreaderat 自行设置的头部, 不能转发给源站
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Host":                true,
+	"Content-Length":      true,
+	"Range":               true,
+}
+
+// HeaderNotAllowedError 请求中指定了不能转发给源站的头部
+type HeaderNotAllowedError struct {
+	Name string
+}
+
+func (e *HeaderNotAllowedError) Error() string {
+	return fmt.Sprintf("header %q cannot be forwarded to the origin", e.Name)
+}
+
+// parseForwardHeaders 解析 -forward-headers 中逗号分隔的头部名称
+func parseForwardHeaders(names string) ([]string, error) {
+	var headers []string
+	for _, name := range strings.Split(names, ",") {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if hopByHopHeaders[name] {
+			return nil, &HeaderNotAllowedError{Name: name}
+		}
+		headers = append(headers, name)
+	}
+	return headers, nil
+}
+
+// checkHeaders 检查请求体 headers 中的头部是否都可以转发
+func checkHeaders(headers map[string]string) error {
+	for name := range headers {
+		if hopByHopHeaders[http.CanonicalHeaderKey(name)] {
+			return &HeaderNotAllowedError{Name: name}
+		}
+	}
+	return nil
+}
+
+// forwardHeaders 把客户端的 Authorization 和 -forward-headers 中的头部, 以及请求体 headers 中的头部设置到发往源站的请求上
+// 客户端 Connection 头中列出的头部同样是单跳的, 不转发
+func forwardHeaders(c *gin.Context, upstream *http.Request, headers map[string]string) {
+	connection := map[string]bool{}
+	for _, v := range c.Request.Header.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			connection[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	for _, name := range append([]string{"Authorization"}, conf.ForwardHeaders...) {
+		if connection[name] || len(upstream.Header.Values(name)) > 0 {
+			continue
+		}
+		for _, v := range c.Request.Header.Values(name) {
+			upstream.Header.Add(name, v)
+		}
+	}
+	for name, v := range headers {
+		upstream.Header.Set(name, v)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestForwardHeaders(t *testing.T) {
+	setupConf(t)
+	conf.ForwardHeaders = []string{"X-Api-Token"}
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	// 源站要求提供凭据
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") != "Bearer secret" && r.Header.Get("X-Api-Token") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return true
+		}
+		return false
+	}
+	r := newTestRouter()
+	link := origin.link("/a.zip")
+	target := query("/list", "link", link)
+
+	for _, tc := range []struct {
+		name   string
+		header []string
+		ok     bool
+	}{
+		{"no credentials", nil, false},
+		{"authorization", []string{"Authorization", "Bearer secret"}, true},
+		{"forwarded header", []string{"X-Api-Token", "secret"}, true},
+		{"hop-by-hop", []string{"Authorization", "Bearer secret", "Connection", "Authorization"}, false},
+	} {
+		if code := getCode(t, r, target, tc.header...); (code == 200) != tc.ok {
+			t.Errorf("%s: code %d, want success %v", tc.name, code, tc.ok)
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		headers map[string]string
+		code    int
+	}{
+		"headers map":       {map[string]string{"Authorization": "Bearer secret"}, 200},
+		"hop-by-hop in map": {map[string]string{"Authorization": "Bearer secret", "Connection": "close"}, 400},
+	} {
+		body, err := json.Marshal(map[string]any{"link": link, "headers": tc.headers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := decodeResp[json.RawMessage](t, post(t, r, "/list", "application/json", body))
+		if resp.Code != tc.code {
+			t.Errorf("%s: code %d, message %q, want %d", name, resp.Code, resp.Message, tc.code)
+		}
+	}
+}
+
+func TestParseForwardHeaders(t *testing.T) {
+	got, err := parseForwardHeaders(" cookie, x-api-token ,")
+	if err != nil || len(got) != 2 || got[0] != "Cookie" || got[1] != "X-Api-Token" {
+		t.Errorf("parseForwardHeaders = %v, %v", got, err)
+	}
+	if _, err := parseForwardHeaders("Cookie,Connection"); err == nil {
+		t.Error("hop-by-hop header should be rejected")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readyFixture /readyz 自检用的归档, 只包含一个文件
+var readyFixture = func() []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("ready.txt")
+	_, _ = w.Write([]byte("ok"))
+	zw.Close()
+	return buf.Bytes()
+}()
+
+type healthResp struct {
+	Status string `json:"status"`
+}
+
+// Healthz 存活检查, 进程能处理请求即返回 200
+func Healthz(c *gin.Context) {
+	SuccessResp(c, healthResp{Status: "ok"})
+}
+
+// Readyz 就绪检查, 识别并列出内置的归档. 负载均衡只看 HTTP 状态码, 不可用时直接返回 503
+func Readyz(c *gin.Context) {
+	arc, err := getBytesArchive("ready.zip", readyFixture)
+	if err == nil {
+		_, err = arc.ExtractDirs(c, "/")
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, Resp[interface{}]{Code: http.StatusServiceUnavailable, Message: err.Error()})
+		return
+	}
+	SuccessResp(c, healthResp{Status: "ready"})
+}
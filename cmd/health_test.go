@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	setupConf(t)
+	r := gin.New()
+	r.GET("/healthz", Healthz)
+	r.HEAD("/healthz", Healthz)
+	r.GET("/readyz", Readyz)
+	r.HEAD("/readyz", Readyz)
+
+	// 不需要 link 参数
+	for target, status := range map[string]string{"/healthz": "ok", "/readyz": "ready"} {
+		if got := getData[healthResp](t, r, target); got.Status != status {
+			t.Errorf("%s: status = %q, want %q", target, got.Status, status)
+		}
+		if w := doRequest(t, r, httptest.NewRequest(http.MethodHead, target, nil)); w.Code != 200 {
+			t.Errorf("HEAD %s: status %d, want 200", target, w.Code)
+		}
+	}
+
+	// 自检失败时返回 503
+	fixture := readyFixture
+	defer func() { readyFixture = fixture }()
+	readyFixture = []byte("not an archive")
+	if w := get(t, r, "/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("broken self-check: status %d, want 503", w.Code)
+	}
+	if w := get(t, r, "/healthz"); w.Code != 200 {
+		t.Errorf("healthz with broken self-check: status %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// indexTailBytes 只缓存归档开头的块和末尾这么多字节内的块
+// zip 和 7z 的目录都在末尾, 避免下载大文件时挤掉其它归档的目录
+const indexTailBytes = 16 << 20
+
+// IndexCache 在内存中缓存远程归档的大小, 源站头部和目录所在区域的数据, 按条目数和总大小进行 LRU 淘汰
+// 每个请求都重新打开源站, 确认 ETag, Last-Modified 和大小没有变化后才使用缓存, 超过 ttl 的条目直接失效
+type IndexCache struct {
+	maxEntries int
+	maxBytes   int64
+	ttl        time.Duration
+
+	mu    sync.Mutex
+	size  int64
+	ll    *list.List // 头部为最近使用
+	items map[string]*list.Element
+}
+
+// indexEntry 缓存的远程归档, 不保存发往源站的请求, 以免其它请求沿用首次请求的头部 (如请求 ID)
+type indexEntry struct {
+	key       string
+	expires   time.Time
+	blockSize int
+	length    int64       // 归档大小
+	header    http.Header // 源站首次响应的头部
+
+	ic     *IndexCache
+	blocks map[int64][]byte // 由 ic.mu 保护
+	size   int64
+}
+
+func NewIndexCache(maxEntries int, maxBytes int64, ttl time.Duration) *IndexCache {
+	return &IndexCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// indexCacheKey 根据规范化的链接, 发往源站的头部 (请求 ID 除外) 和块大小生成缓存键, 不同凭据的请求不共享缓存
+func indexCacheKey(req *http.Request, blockSize int) string {
+	u := *req.URL
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = u.Hostname()
+	}
+	u.Fragment = ""
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		if name != http.CanonicalHeaderKey(requestIDHeader) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	io.WriteString(h, u.String()+"\x00"+strconv.Itoa(blockSize))
+	for _, name := range names {
+		io.WriteString(h, "\x00"+name+":"+strings.Join(req.Header.Values(name), "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Open 用本次请求打开源站上的归档, 缓存的归档没有变化时从缓存读取目录所在的块
+func (ic *IndexCache) Open(req *http.Request, blockSize int) (originSource, error) {
+	key := indexCacheKey(req, blockSize)
+	origin, err := newOriginReader(req)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := ic.get(key)
+	if ok && !origin.downloaded() && e.unchanged(origin) {
+		metrics.cacheLookup("index", true)
+		return &indexReader{originReader: origin, e: e}, nil
+	}
+	if ok {
+		ic.remove(e)
+	}
+	metrics.cacheLookup("index", false)
+	// 完整下载的归档不缓存, 由请求结束时删除
+	if origin.downloaded() {
+		return origin, nil
+	}
+	return &indexReader{originReader: origin, e: ic.put(key, origin, blockSize)}, nil
+}
+
+func (ic *IndexCache) get(key string) (*indexEntry, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	el, ok := ic.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*indexEntry)
+	if time.Now().After(e.expires) {
+		ic.removeLocked(el)
+		return nil, false
+	}
+	ic.ll.MoveToFront(el)
+	return e, true
+}
+
+func (ic *IndexCache) put(key string, origin *originReader, blockSize int) *indexEntry {
+	e := &indexEntry{
+		key:       key,
+		expires:   time.Now().Add(ic.ttl),
+		blockSize: blockSize,
+		length:    origin.Size(),
+		header:    origin.Header(),
+		ic:        ic,
+		blocks:    make(map[int64][]byte),
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if el, ok := ic.items[key]; ok {
+		ic.removeLocked(el)
+	}
+	ic.items[key] = ic.ll.PushFront(e)
+	for ic.ll.Len() > ic.maxEntries {
+		ic.removeLocked(ic.ll.Back())
+	}
+	return e
+}
+
+func (ic *IndexCache) remove(e *indexEntry) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if el, ok := ic.items[e.key]; ok && el.Value == e {
+		ic.removeLocked(el)
+	}
+}
+
+func (ic *IndexCache) removeLocked(el *list.Element) {
+	e := el.Value.(*indexEntry)
+	ic.ll.Remove(el)
+	delete(ic.items, e.key)
+	ic.size -= e.size
+	e.blocks = make(map[int64][]byte)
+	e.size = 0
+}
+
+// storeBlock 缓存一个块, 超出总大小时淘汰其它最久未使用的条目, 仍然放不下时不缓存
+func (ic *IndexCache) storeBlock(e *indexEntry, off int64, data []byte) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	el, ok := ic.items[e.key]
+	if !ok || el.Value != e {
+		return
+	}
+	if _, ok := e.blocks[off]; ok {
+		return
+	}
+	for ic.size+int64(len(data)) > ic.maxBytes {
+		back := ic.ll.Back()
+		if back == el {
+			return
+		}
+		ic.removeLocked(back)
+	}
+	e.blocks[off] = data
+	e.size += int64(len(data))
+	ic.size += int64(len(data))
+}
+
+func (ic *IndexCache) loadBlock(e *indexEntry, off int64) ([]byte, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	data, ok := e.blocks[off]
+	return data, ok
+}
+
+// indexReader 一个请求读取的缓存归档, 未缓存的块通过本次请求打开的源站读取
+type indexReader struct {
+	*originReader
+	e *indexEntry
+}
+
+// ReadAt 只缓存按块对齐的完整块读取, 即 bufra 的读取
+func (r *indexReader) ReadAt(p []byte, off int64) (int, error) {
+	e := r.e
+	if len(p) != e.blockSize || off%int64(e.blockSize) != 0 || !e.inIndexRegion(off) {
+		return r.originReader.ReadAt(p, off)
+	}
+	if data, ok := e.ic.loadBlock(e, off); ok {
+		n := copy(p, data)
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+	n, err := r.originReader.ReadAt(p, off)
+	if err == nil || (err == io.EOF && off+int64(n) == e.length) {
+		e.ic.storeBlock(e, off, append([]byte(nil), p[:n]...))
+	}
+	return n, err
+}
+
+func (e *indexEntry) inIndexRegion(off int64) bool {
+	return off == 0 || off+int64(e.blockSize) > e.length-indexTailBytes
+}
+
+// unchanged 确认本次请求打开的源站归档与缓存的一致
+func (e *indexEntry) unchanged(origin *originReader) bool {
+	if origin.Size() != e.length {
+		return false
+	}
+	for _, name := range []string{"ETag", "Last-Modified"} {
+		if origin.Header().Get(name) != e.header.Get(name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// manyEntriesZip 构造目录跨越多个块的 zip
+func manyEntriesZip(t testing.TB, n int) []byte {
+	t.Helper()
+	entries := make([]testEntry, n)
+	for i := range entries {
+		entries[i] = testEntry{Name: fmt.Sprintf("dir/file-with-a-long-name-%05d.txt", i), Body: "x"}
+	}
+	return zipBytes(t, entries...)
+}
+
+func TestIndexCacheRoundTrips(t *testing.T) {
+	setupConf(t)
+	indexCache = NewIndexCache(16, 64<<20, time.Minute)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": manyEntriesZip(t, 2000)})
+	r := newTestRouter()
+	target := query("/list", "link", origin.link("/a.zip"), "buf_size", "65536")
+
+	getData[ListResp](t, r, target)
+	cold := origin.requests.Swap(0)
+	getData[ListResp](t, r, target)
+	// 命中时目录所在的块从缓存读取, 只有 HEAD 请求和跨块的读取访问源站
+	if warm := origin.requests.Swap(0); warm >= cold {
+		t.Errorf("upstream requests: cold %d, warm %d", cold, warm)
+	}
+
+	origin.mu.Lock()
+	origin.header["/a.zip"] = http.Header{"Etag": {`"v2"`}}
+	origin.mu.Unlock()
+	getData[ListResp](t, r, target)
+	if changed := origin.requests.Swap(0); changed < cold {
+		t.Errorf("changed ETag should reopen the archive, got %d upstream requests", changed)
+	}
+}
+
+func TestIndexCacheRequestID(t *testing.T) {
+	setupConf(t)
+	indexCache = NewIndexCache(16, 64<<20, time.Minute)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": manyEntriesZip(t, 2000)})
+	var mu sync.Mutex
+	var upstreamIDs []string
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		upstreamIDs = append(upstreamIDs, r.Header.Get(requestIDHeader))
+		mu.Unlock()
+		return false
+	}
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(requestLog(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	archiveRoutes(r)
+	target := query("/list", "link", origin.link("/a.zip"), "buf_size", "65536")
+
+	getData[ListResp](t, r, target, requestIDHeader, "first")
+	mu.Lock()
+	upstreamIDs = nil
+	mu.Unlock()
+	// 命中缓存的请求发往源站时带上自己的请求 ID, 而不是首次请求的
+	getData[ListResp](t, r, target, requestIDHeader, "second")
+	mu.Lock()
+	defer mu.Unlock()
+	if len(upstreamIDs) == 0 {
+		t.Fatal("cache hit should revalidate with the origin")
+	}
+	for _, id := range upstreamIDs {
+		if id != "second" {
+			t.Errorf("upstream request ID = %q, want %q", id, "second")
+		}
+	}
+}
+
+func BenchmarkListUpstreamRoundTrips(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cached=%v", cached), func(b *testing.B) {
+			setupConf(b)
+			if cached {
+				indexCache = NewIndexCache(16, 64<<20, time.Minute)
+			}
+			origin := newTestOrigin(b, map[string][]byte{"/a.zip": manyEntriesZip(b, 2000)})
+			r := newTestRouter()
+			target := query("/list", "link", origin.link("/a.zip"), "buf_size", "65536")
+			getData[ListResp](b, r, target)
+			origin.requests.Store(0)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				getData[ListResp](b, r, target)
+			}
+			b.ReportMetric(float64(origin.requests.Load())/float64(b.N), "upstream-requests/op")
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+)
+
+type InfoReq struct {
+	ArchiveReq
+	// Scan 为 true 时不支持随机访问的归档也扫描全部文件以统计数量和大小
+	Scan bool `json:"scan" form:"scan"`
+}
+
+type InfoResp struct {
+	ArchiveMeta
+	// RandomAccess 无需从头扫描即可列出和定位文件, 为 false 时 /list 需要扫描整个归档
+	RandomAccess bool `json:"random_access"`
+	// UpstreamRanges 源站支持范围请求, 上传的归档总是支持
+	UpstreamRanges bool `json:"upstream_ranges"`
+	Scanned        bool `json:"scanned"`
+	// 以下字段只有扫描了文件时返回, UncompressedSize 在存在大小未知的文件时省略
+	Entries          *int   `json:"entries,omitempty"`
+	Files            *int   `json:"files,omitempty"`
+	UncompressedSize *int64 `json:"uncompressed_size,omitempty"`
+	Encrypted        *bool  `json:"encrypted,omitempty"`
+}
+
+// Info 返回归档格式和是否需要完整扫描等信息, 支持随机访问的归档 (或 scan=true 时) 同时统计文件数量和大小
+func Info(c *gin.Context) {
+	var req InfoReq
+	if err := c.ShouldBind(&req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+
+	resp := InfoResp{
+		ArchiveMeta:    arc.Meta(),
+		UpstreamRanges: len(arc.OriginHeader) == 0 || arc.OriginHeader.Get("Content-Range") != "" || arc.OriginHeader.Get("Accept-Ranges") == "bytes",
+	}
+	if resp.RandomAccess, err = arc.RandomAccess(); err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+	if resp.RandomAccess || req.Scan {
+		files, err := arc.CascadeExtractDirs(c, "/")
+		if err != nil {
+			ExtractErrorResp(c, err)
+			return
+		}
+		var entries, fileCount int
+		var size int64
+		sizeKnown, encrypted := true, false
+		for _, f := range files {
+			entries++
+			if f.IsDir() {
+				continue
+			}
+			fileCount++
+			if f.Size() < 0 {
+				sizeKnown = false
+			} else {
+				size += f.Size()
+			}
+			encrypted = encrypted || archiver.IsEncrypted(f)
+		}
+		resp.Scanned = true
+		resp.Entries, resp.Files, resp.Encrypted = &entries, &fileCount, &encrypted
+		if sizeKnown {
+			resp.UncompressedSize = &size
+		}
+	}
+	SuccessResp(c, resp)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestInfoFormat(t *testing.T) {
+	setupConf(t)
+	entries := []testEntry{{Name: "a.txt", Body: "alpha"}, {Name: "dir/b.txt", Body: "beta"}}
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip":       zipBytes(t, entries...),
+		"/a.tar":       tarBytes(t, entries...),
+		"/nonsolid.7z": testdata(t, "t0.7z"),
+		"/solid.7z":    testdata(t, "lzma2.7z"),
+	})
+	r := newTestRouter()
+
+	for link, want := range map[string]struct {
+		format       string
+		randomAccess bool
+	}{
+		"/a.zip":       {"zip", true},
+		"/a.tar":       {"tar", false},
+		"/nonsolid.7z": {"7z", true},
+		"/solid.7z":    {"7z", false},
+	} {
+		info := getData[InfoResp](t, r, query("/info", "link", origin.link(link)))
+		if info.Format != want.format || info.RandomAccess != want.randomAccess || !info.UpstreamRanges {
+			t.Errorf("%s: format %q, random_access %v, upstream_ranges %v, want %q, %v, true",
+				link, info.Format, info.RandomAccess, info.UpstreamRanges, want.format, want.randomAccess)
+		}
+		// 不支持随机访问时默认不扫描
+		if info.Scanned != want.randomAccess || (info.Entries == nil) == want.randomAccess {
+			t.Errorf("%s: scanned %v, entries %v", link, info.Scanned, info.Entries)
+		}
+	}
+
+	for _, link := range []string{"/a.zip", "/a.tar"} {
+		info := getData[InfoResp](t, r, query("/info", "link", origin.link(link), "scan", "true"))
+		if !info.Scanned || info.Files == nil || *info.Files != 2 || info.UncompressedSize == nil || *info.UncompressedSize != 9 ||
+			info.Encrypted == nil || *info.Encrypted {
+			t.Errorf("%s: scan: files %v, uncompressed_size %v, encrypted %v", link, info.Files, info.UncompressedSize, info.Encrypted)
+		}
+	}
+}
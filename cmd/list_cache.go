@@ -0,0 +1,84 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListCache 在内存中缓存 prefetch=true 时在后台预取的 /list 页, 客户端翻到该页时直接从缓存返回,
+// 不再访问源站. 按页数进行 LRU 淘汰, 超过 ttl 的页直接失效
+type ListCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List // 头部为最近使用
+	items map[string]*list.Element
+}
+
+type listCacheItem struct {
+	key     string
+	expires time.Time
+	resp    ListResp
+}
+
+func NewListCache(maxEntries int, ttl time.Duration) *ListCache {
+	return &ListCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// ListCacheKey 根据除分页外的请求参数和转发给源站的客户端头部生成缓存键, 不同凭据的请求不共享缓存
+func ListCacheKey(c *gin.Context, req ListReq) string {
+	req.PageReq = PageReq{}
+	params, _ := json.Marshal(req)
+	h := sha256.New()
+	h.Write(params)
+	for _, name := range append([]string{"Authorization"}, conf.ForwardHeaders...) {
+		for _, v := range c.Request.Header.Values(name) {
+			h.Write([]byte("\x00" + name + ":" + v))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get 返回缓存的页
+func (lc *ListCache) Get(key string) (ListResp, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	e, ok := lc.items[key]
+	if !ok {
+		return ListResp{}, false
+	}
+	item := e.Value.(*listCacheItem)
+	if time.Now().After(item.expires) {
+		lc.ll.Remove(e)
+		delete(lc.items, key)
+		return ListResp{}, false
+	}
+	lc.ll.MoveToFront(e)
+	return item.resp, true
+}
+
+func (lc *ListCache) Put(key string, resp ListResp) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if e, ok := lc.items[key]; ok {
+		lc.ll.Remove(e)
+	}
+	lc.items[key] = lc.ll.PushFront(&listCacheItem{key: key, expires: time.Now().Add(lc.ttl), resp: resp})
+	for lc.ll.Len() > lc.maxEntries {
+		e := lc.ll.Back()
+		lc.ll.Remove(e)
+		delete(lc.items, e.Value.(*listCacheItem).key)
+	}
+}
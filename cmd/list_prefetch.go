@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListPrefetcher 在后台预取 prefetch=true 的 /list 请求的下一页并写入 listCache.
+// 同时进行的预取数受 concurrency 限制, 并且需要一个空闲的 extractionSlots, 不与客户端的请求竞争
+type ListPrefetcher struct {
+	slots   chan struct{}
+	timeout time.Duration
+
+	// ctx 关闭服务时取消所有预取
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// running 每个列表 (ListCacheKey) 正在进行的预取
+	running map[string]*prefetchRun
+}
+
+type prefetchRun struct {
+	cancel context.CancelFunc
+}
+
+func NewListPrefetcher(concurrency int, timeout time.Duration) *ListPrefetcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ListPrefetcher{
+		slots:   make(chan struct{}, concurrency),
+		timeout: timeout,
+		ctx:     ctx,
+		cancel:  cancel,
+		running: make(map[string]*prefetchRun),
+	}
+}
+
+// Start 在后台调用 warm, 同一个列表已有预取时取消之前的预取. 没有空闲的名额时放弃预取并返回 false
+func (lp *ListPrefetcher) Start(key string, warm func(ctx context.Context)) bool {
+	select {
+	case lp.slots <- struct{}{}:
+	default:
+		return false
+	}
+	if extractionSlots != nil {
+		select {
+		case extractionSlots <- struct{}{}:
+		default:
+			<-lp.slots
+			return false
+		}
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if lp.timeout > 0 {
+		ctx, cancel = context.WithTimeout(lp.ctx, lp.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(lp.ctx)
+	}
+	run := &prefetchRun{cancel: cancel}
+	lp.mu.Lock()
+	if old, ok := lp.running[key]; ok {
+		old.cancel()
+	}
+	lp.running[key] = run
+	lp.mu.Unlock()
+
+	lp.wg.Add(1)
+	go func() {
+		defer lp.wg.Done()
+		defer func() {
+			lp.mu.Lock()
+			if lp.running[key] == run {
+				delete(lp.running, key)
+			}
+			lp.mu.Unlock()
+			cancel()
+			if extractionSlots != nil {
+				<-extractionSlots
+			}
+			<-lp.slots
+		}()
+		warm(ctx)
+	}()
+	return true
+}
+
+// Cancel 取消列表正在进行的预取, 用于客户端已经自己请求了该页
+func (lp *ListPrefetcher) Cancel(key string) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if run, ok := lp.running[key]; ok {
+		run.cancel()
+		delete(lp.running, key)
+	}
+}
+
+// Close 取消所有预取并等待其结束
+func (lp *ListPrefetcher) Close() {
+	lp.cancel()
+	lp.wg.Wait()
+}
+
+// listPageKey 返回 /list 请求中一页的缓存键, 与 pageBounds 相同处理缺省和超出上限的分页参数
+func listPageKey(c *gin.Context, req *ListReq) string {
+	perPage := req.PerPage
+	if perPage <= 0 {
+		perPage = conf.DefaultPerPage
+	}
+	return fmt.Sprintf("%s:%d:%d", ListCacheKey(c, *req), max(req.Page, 1), min(perPage, conf.MaxPerPage))
+}
+
+// prefetchNextPage 返回 resp 之后在后台预取下一页, 已经是最后一页或下一页已缓存时不预取
+func prefetchNextPage(c *gin.Context, req *ListReq, reqPath string, resp ListResp) {
+	if listPrefetch == nil {
+		return
+	}
+	if _, end := pageBounds(int(resp.Total), &req.PageReq); end >= int(resp.Total) {
+		return
+	}
+	next := *req
+	next.Page = max(req.Page, 1) + 1
+	key := listPageKey(c, &next)
+	if _, ok := listCache.Get(key); ok {
+		return
+	}
+	// 请求结束后 c 会被复用, 只能在后台使用副本
+	cp := c.Copy()
+	listPrefetch.Start(ListCacheKey(c, *req), func(ctx context.Context) {
+		cp.Request = cp.Request.WithContext(ctx)
+		if resp, _, err := listPage(cp, &next, reqPath); err == nil {
+			listCache.Put(key, resp)
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+// ListSpill 将 spill=true 的 cascade /list 结果逐条写入磁盘, 翻页时只读取该页的条目,
+// 内存占用与归档中的文件数无关. 结果超过 ttl 后删除
+type ListSpill struct {
+	dir string
+	ttl time.Duration
+
+	mu    sync.Mutex
+	items map[string]*listSpillItem
+}
+
+type listSpillItem struct {
+	data    string // 每行一个 ObjResp 的 JSON
+	index   string // 每个条目在 data 中的偏移, 8 字节小端
+	total   int
+	meta    ArchiveMeta
+	expires time.Time
+}
+
+func NewListSpill(dir string, ttl time.Duration) (*ListSpill, error) {
+	// 索引只保存在内存中, 每次启动使用新的子目录
+	dir, err := privateDir(dir, "list-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	ls := &ListSpill{
+		dir:   dir,
+		ttl:   ttl,
+		items: make(map[string]*listSpillItem),
+	}
+	go ls.janitor()
+	return ls, nil
+}
+
+// janitor 定期删除过期的结果
+func (ls *ListSpill) janitor() {
+	ticker := time.NewTicker(max(ls.ttl/2, time.Second))
+	defer ticker.Stop()
+	for now := range ticker.C {
+		ls.mu.Lock()
+		for key, item := range ls.items {
+			if now.After(item.expires) {
+				delete(ls.items, key)
+				item.remove()
+			}
+		}
+		ls.mu.Unlock()
+	}
+}
+
+// Close 删除写入磁盘的结果
+func (ls *ListSpill) Close() error {
+	return os.RemoveAll(ls.dir)
+}
+
+func (item *listSpillItem) remove() {
+	os.Remove(item.data)
+	os.Remove(item.index)
+}
+
+func (ls *ListSpill) Get(key string) (*listSpillItem, bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	item, ok := ls.items[key]
+	if !ok || time.Now().After(item.expires) {
+		return nil, false
+	}
+	return item, true
+}
+
+// Put 调用 walk 逐条写入结果, walk 出错时丢弃已写入的内容. 相同的 key 已有结果时保留先写完的结果
+func (ls *ListSpill) Put(key string, meta ArchiveMeta, walk func(emit func(ObjResp) error) error) (*listSpillItem, error) {
+	data, err := os.CreateTemp(ls.dir, "list-*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	index, err := os.CreateTemp(ls.dir, "list-*.idx")
+	if err != nil {
+		os.Remove(data.Name())
+		return nil, err
+	}
+	defer index.Close()
+	item := &listSpillItem{data: data.Name(), index: index.Name(), meta: meta}
+
+	dw, iw := bufio.NewWriter(data), bufio.NewWriter(index)
+	var offset int64
+	err = walk(func(obj ObjResp) error {
+		line, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if err := binary.Write(iw, binary.LittleEndian, offset); err != nil {
+			return err
+		}
+		n, err := dw.Write(append(line, '\n'))
+		offset += int64(n)
+		item.total++
+		return err
+	})
+	if err == nil {
+		err = dw.Flush()
+	}
+	if err == nil {
+		err = iw.Flush()
+	}
+	if err != nil {
+		item.remove()
+		return nil, err
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if old, ok := ls.items[key]; ok && time.Now().Before(old.expires) {
+		item.remove()
+		return old, nil
+	}
+	item.expires = time.Now().Add(ls.ttl)
+	ls.items[key] = item
+	return item, nil
+}
+
+// Page 读取请求的页, 只读取该页的条目
+func (item *listSpillItem) Page(req *PageReq) ([]ObjResp, error) {
+	start, end := pageBounds(item.total, req)
+	objs := make([]ObjResp, 0, end-start)
+	if start == end {
+		return objs, nil
+	}
+
+	index, err := os.Open(item.index)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+	var buf [8]byte
+	if _, err := index.ReadAt(buf[:], int64(start)*8); err != nil {
+		return nil, err
+	}
+
+	data, err := os.Open(item.data)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	if _, err := data.Seek(int64(binary.LittleEndian.Uint64(buf[:])), io.SeekStart); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bufio.NewReader(data))
+	for i := start; i < end; i++ {
+		var obj ObjResp
+		if err := dec.Decode(&obj); err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// listSpilled 处理 spill=true 的 /list 请求, 第一次请求时遍历归档并写入磁盘, 之后的翻页请求直接读取
+func listSpilled(c *gin.Context, req *ListReq, reqPath string) {
+	if listSpill == nil {
+		ErrorStrResp(c, "spill is disabled, start the server with -list-spill-dir", 400)
+		return
+	}
+	if !req.Cascade {
+		ErrorStrResp(c, "spill requires cascade=true", 400)
+		return
+	}
+	// 写入磁盘的结果按遍历顺序保存, 不能再在内存中整体处理
+	if req.Sort != "" || req.Shuffle != nil || req.Group != "" || req.Glob != "" || req.Sniff || req.DirsFirst || req.WithSize {
+		ErrorStrResp(c, "spill can not be used with sort, shuffle, group, glob, sniff, dirs_first or with_size", 400)
+		return
+	}
+	// 上传的归档每次都不同, 无法翻页
+	if req.RawLink == "" {
+		ErrorStrResp(c, "spill requires link", 400)
+		return
+	}
+	if err := checkSource(c, &req.ArchiveReq); err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+
+	key := ListCacheKey(c, *req)
+	item, ok := listSpill.Get(key)
+	if !ok {
+		arc, err := getArchive(c, &req.ArchiveReq)
+		if err != nil {
+			ArchiveErrorResp(c, err)
+			return
+		}
+		arc, reqPath, err := openNested(c, arc, &req.ArchiveReq, reqPath)
+		if err != nil {
+			ExtractErrorResp(c, err)
+			return
+		}
+		exts := parseExts(req.Ext)
+		var walkErr error
+		item, err = listSpill.Put(key, arc.Meta(), func(emit func(ObjResp) error) error {
+			walkErr = arc.CascadeWalkDirs(c, reqPath, func(ctx context.Context, f stdArchiever.File) error {
+				if req.Depth != nil && archiver.DirDepth(reqPath, f.NameInArchive) > *req.Depth {
+					return nil
+				}
+				if obj := listObj(arc, &f, reqPath); req.SizeFilter.match(obj) && matchType(obj, req.Type) && matchExts(obj, exts) {
+					return emit(obj)
+				}
+				return nil
+			})
+			return walkErr
+		})
+		if walkErr != nil {
+			ExtractErrorResp(c, walkErr)
+			return
+		}
+		if err != nil {
+			ErrorStrResp(c, err.Error(), 500)
+			return
+		}
+	}
+
+	objs, err := item.Page(&req.PageReq)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 500)
+		return
+	}
+	SuccessResp(c, ListResp{
+		ArchiveMeta: item.meta,
+		Content:     objs,
+		Total:       int64(item.total),
+		TotalSize:   sumSize(objs),
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestListSpillLargeListing(t *testing.T) {
+	setupConf(t)
+	conf.MaxPerPage = 1000
+	ls, err := NewListSpill(t.TempDir(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	const n = 200000
+	name := func(i int) string { return fmt.Sprintf("dir%03d/file-%07d.txt", i%1000, i) }
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	item, err := ls.Put("key", ArchiveMeta{Format: "zip"}, func(emit func(ObjResp) error) error {
+		for i := 0; i < n; i++ {
+			if err := emit(ObjResp{Name: name(i), NameInArchive: name(i), Size: int64(i), Mode: "0644"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	// 全部保存在内存中需要几十 MiB
+	if retained := int64(after.HeapAlloc) - int64(before.HeapAlloc); retained > 4<<20 {
+		t.Errorf("spilled listing retained %d bytes of heap", retained)
+	}
+	if item.total != n {
+		t.Fatalf("total = %d, want %d", item.total, n)
+	}
+
+	for _, tc := range []struct {
+		page, perPage, first, count int
+	}{
+		{1, 100, 0, 100},
+		{1000, 100, 99900, 100},
+		{2000, 100, 199900, 100},
+		{1334, 150, 199950, 50},
+		{2001, 100, 0, 0},
+	} {
+		objs, err := item.Page(&PageReq{Page: tc.page, PerPage: tc.perPage})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objs) != tc.count {
+			t.Errorf("page %d: %d entries, want %d", tc.page, len(objs), tc.count)
+			continue
+		}
+		for i, obj := range objs {
+			if obj.NameInArchive != name(tc.first+i) || obj.Size != int64(tc.first+i) {
+				t.Errorf("page %d entry %d = %s (%d), want %s", tc.page, i, obj.NameInArchive, obj.Size, name(tc.first+i))
+				break
+			}
+		}
+	}
+}
+
+func TestListSpillPagination(t *testing.T) {
+	setupConf(t)
+	conf.MaxPerPage = 1000
+	var err error
+	if listSpill, err = NewListSpill(t.TempDir(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	defer listSpill.Close()
+	var entries []testEntry
+	for i := 0; i < 250; i++ {
+		entries = append(entries, testEntry{Name: fmt.Sprintf("d%d/%03d.txt", i%5, i), Body: "x"})
+	}
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, entries...)})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	all := getData[ListResp](t, r, query("/list", "link", link, "cascade", "true", "per_page", "1000"))
+	var paged []string
+	for page := 1; ; page++ {
+		list := getData[ListResp](t, r, query("/list", "link", link, "cascade", "true", "spill", "true", "per_page", "40", "page", fmt.Sprint(page)))
+		if list.Total != all.Total {
+			t.Fatalf("page %d: total %d, want %d", page, list.Total, all.Total)
+		}
+		if len(list.Content) == 0 {
+			break
+		}
+		paged = append(paged, names(list.Content)...)
+		if page == 1 {
+			origin.requests.Store(0)
+		}
+	}
+	if !reflect.DeepEqual(paged, names(all.Content)) {
+		t.Errorf("spilled pages differ from the full listing: %d vs %d entries", len(paged), len(all.Content))
+	}
+	// 之后的翻页直接读取磁盘上的结果
+	if n := origin.requests.Load(); n != 0 {
+		t.Errorf("later pages made %d upstream requests", n)
+	}
+
+	if code := getCode(t, r, query("/list", "link", link, "spill", "true")); code != 400 {
+		t.Errorf("spill without cascade: code = %d, want 400", code)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+	// listStreamFlushInterval 流式返回时至少每隔多久发送一次已经写入的条目
+	listStreamFlushInterval = 200 * time.Millisecond
+	// listStreamFlushEntries 流式返回时至少每写入多少个条目发送一次
+	listStreamFlushEntries = 1000
+)
+
+// wantsStream 判断 /list 是否流式返回, stream=true 或 Accept 中包含 application/x-ndjson
+func wantsStream(c *gin.Context, req *ListReq) bool {
+	return req.Stream || strings.Contains(c.GetHeader("Accept"), ndjsonContentType)
+}
+
+// listStream 以 NDJSON 流式返回 /list 的结果, 每行一个条目, 遍历到一个条目就写入一个, 不在内存中保存完整结果.
+// 开始写入后出错时最后一行为与其他接口相同的错误响应, 例如 {"code":500,"message":"..."}
+func listStream(c *gin.Context, req *ListReq, reqPath string) {
+	// 条目按遍历顺序写出, 不能与改变顺序或需要完整结果的参数一起使用
+	if req.Page != 0 || req.Sort != "" || req.Shuffle != nil || req.Group != "" || req.Glob != "" || req.Sniff ||
+		req.DirsFirst || req.WithSize || req.Spill || req.Prefetch || req.Cursor != nil {
+		ErrorStrResp(c, "stream can not be used with page, sort, shuffle, group, glob, sniff, dirs_first, with_size, spill, prefetch or cursor", 400)
+		return
+	}
+	depth := 1
+	if req.Cascade {
+		depth = -1
+		if req.Depth != nil {
+			depth = *req.Depth
+		}
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	exts := parseExts(req.Ext)
+	enc := json.NewEncoder(c.Writer)
+	written, unflushed := 0, 0
+	lastFlush := time.Now()
+	flush := func() {
+		c.Writer.Flush()
+		unflushed, lastFlush = 0, time.Now()
+	}
+	err = arc.WalkListing(c, reqPath, depth, func(f stdArchiever.File) error {
+		obj := listObj(arc, &f, reqPath)
+		if !req.SizeFilter.match(obj) || !matchType(obj, req.Type) || !matchExts(obj, exts) {
+			return nil
+		}
+		if written == 0 {
+			c.Writer.Header().Set("Content-Type", ndjsonContentType)
+			c.Writer.Header().Set("Cache-Control", "no-cache")
+			c.Status(http.StatusOK)
+		}
+		if err := enc.Encode(obj); err != nil {
+			return err
+		}
+		written, unflushed = written+1, unflushed+1
+		if unflushed >= listStreamFlushEntries || time.Since(lastFlush) >= listStreamFlushInterval {
+			flush()
+		}
+		return nil
+	})
+	if c.Request.Context().Err() != nil {
+		// 客户端已断开
+		return
+	}
+	if err != nil && written == 0 {
+		ExtractErrorResp(c, err)
+		return
+	}
+	if written == 0 {
+		// 没有条目时同样返回 NDJSON, 内容为空
+		c.Writer.Header().Set("Content-Type", ndjsonContentType)
+		c.Status(http.StatusOK)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	if err != nil {
+		code, errType := extractErrorCode(err)
+		_ = enc.Encode(Resp[interface{}]{Code: code, Message: err.Error(), ErrorType: errType})
+	}
+	flush()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ndjsonLines 将 NDJSON 响应按行解析, 每行一个 JSON 对象
+func ndjsonLines(t testing.TB, w *httptest.ResponseRecorder) []map[string]json.RawMessage {
+	t.Helper()
+	if got := w.Header().Get("Content-Type"); got != ndjsonContentType {
+		t.Fatalf("Content-Type = %q, want %s", got, ndjsonContentType)
+	}
+	var lines []map[string]json.RawMessage
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		var line map[string]json.RawMessage
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// ndjsonObjs 将 NDJSON 响应解析为条目
+func ndjsonObjs(t testing.TB, w *httptest.ResponseRecorder) []ObjResp {
+	t.Helper()
+	var objs []ObjResp
+	for _, line := range ndjsonLines(t, w) {
+		data, err := json.Marshal(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var obj ObjResp
+		if err := json.Unmarshal(data, &obj); err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+func TestListStream(t *testing.T) {
+	setupConf(t)
+	var entries []testEntry
+	for i := 0; i < 50; i++ {
+		entries = append(entries, testEntry{Name: fmt.Sprintf("d%d/f%02d.txt", i%5, i), Body: strings.Repeat("x", i)})
+	}
+	entries = append(entries, testEntry{Name: "photo.png", Body: "\x89PNG"})
+	tarball := tarBytes(t, entries...)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip":     zipBytes(t, entries...),
+		"/a.tar":     tarball,
+		"/trunc.tar": tarball[:len(tarball)/2],
+	})
+	r := newTestRouter()
+
+	for _, link := range []string{"/a.zip", "/a.tar"} {
+		for _, kv := range [][]string{{"cascade", "true"}, {"path", "/d2"}, {"type", "dir"}, {"cascade", "true", "ext", "png"}} {
+			args := append([]string{"link", origin.link(link)}, kv...)
+			want := getData[ListResp](t, r, query("/list", append(args, "per_page", "1000")...)).Content
+			// stream=true 和 Accept 两种方式结果相同
+			for name, w := range map[string]*httptest.ResponseRecorder{
+				"stream": get(t, r, query("/list", append(args, "stream", "true")...)),
+				"accept": get(t, r, query("/list", args...), "Accept", ndjsonContentType),
+			} {
+				got := ndjsonObjs(t, w)
+				if len(got) == 0 || !reflect.DeepEqual(got, want) {
+					t.Errorf("%s %v %s: streamed %v, want %v", link, kv, name, names(got), names(want))
+				}
+			}
+		}
+	}
+
+	// 没有条目时返回空的 NDJSON
+	if w := get(t, r, query("/list", "link", origin.link("/a.zip"), "path", "/missing", "stream", "true")); w.Code != 200 || len(ndjsonLines(t, w)) != 0 {
+		t.Errorf("empty listing: status %d, body %q", w.Code, w.Body.String())
+	}
+	// 写入条目后出错时最后一行为错误
+	lines := ndjsonLines(t, get(t, r, query("/list", "link", origin.link("/trunc.tar"), "cascade", "true", "stream", "true")))
+	if len(lines) < 2 {
+		t.Fatalf("truncated archive: %d lines", len(lines))
+	}
+	last := lines[len(lines)-1]
+	if string(last["code"]) != "422" || last["message"] == nil {
+		t.Errorf("truncated archive: last line %v, want an error", last)
+	}
+
+	if code := getCode(t, r, query("/list", "link", origin.link("/a.zip"), "stream", "true", "sort", "name")); code != 400 {
+		t.Errorf("stream with sort: code = %d, want 400", code)
+	}
+}
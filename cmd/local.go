@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+)
+
+// localPath link 为 file:// 链接或绝对路径时返回本地文件路径
+func localPath(link string) (string, bool) {
+	if strings.HasPrefix(link, "/") {
+		return link, true
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// getLocalArchive 直接读取本地文件, 需要 -allow-local-files. 文件在请求结束后关闭
+func getLocalArchive(c *gin.Context, name string) (*Archive, error) {
+	if !conf.AllowLocalFiles {
+		return nil, fmt.Errorf("%w: local files are disabled, start the server with -allow-local-files", ErrLinkBlocked)
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", archiver.ErrFileNotFound, name)
+	} else if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		err = fmt.Errorf("%w: %s is a directory", archiver.ErrUnsupportedFormat, name)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	context.AfterFunc(c.Request.Context(), func() { f.Close() })
+
+	arc, err := archiver.DetectArchive(name, io.NewSectionReader(f, 0, fi.Size()))
+	if err != nil {
+		return nil, err
+	}
+	// 与源站的响应头一致, 用于修改时间和指纹
+	header := http.Header{}
+	header.Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	header.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	header.Set("Accept-Ranges", "bytes")
+	return &Archive{ArchiverExtractor: arc, OriginHeader: header, Size: fi.Size(), source: f}, nil
+}
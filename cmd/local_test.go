@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLocalFiles(t *testing.T) {
+	setupConf(t)
+	dir := t.TempDir()
+	name := filepath.Join(dir, "local archive.zip")
+	if err := os.WriteFile(name, zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"}, testEntry{Name: "dir/b.txt", Body: "beta"}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fileURL := (&url.URL{Scheme: "file", Path: name}).String()
+	r := newTestRouter()
+
+	if code := getCode(t, r, query("/list", "link", name)); code != 403 {
+		t.Errorf("disabled: code = %d, want 403", code)
+	}
+
+	conf.AllowLocalFiles = true
+	for _, link := range []string{name, fileURL} {
+		list := getData[ListResp](t, r, query("/list", "link", link))
+		if got, want := names(list.Content), []string{"a.txt", "dir/"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: entries = %v, want %v", link, got, want)
+		}
+		w := get(t, r, query("/down", "link", link, "path", "/dir/b.txt"))
+		if w.Code != 200 || w.Body.String() != "beta" {
+			t.Errorf("%s: down: status %d, body %q", link, w.Code, w.Body.String())
+		}
+	}
+
+	for link, code := range map[string]int{
+		filepath.Join(dir, "missing.zip"): 404,
+		dir:                               415,
+	} {
+		if resp := decodeResp[json.RawMessage](t, get(t, r, query("/list", "link", link))); resp.Code != code {
+			t.Errorf("%s: code %d (%s), want %d", link, resp.Code, resp.Message, code)
+		}
+	}
+}
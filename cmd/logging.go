@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDHeader 请求 ID 的头部, 客户端提供时沿用, 否则生成. 响应和发往源站的请求都会带上
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID 沿用客户端提供的请求 ID 前检查, 避免日志注入
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// gin.Context 中保存请求信息的键
+const (
+	requestIDKey     = "request_id"
+	upstreamBytesKey = "upstream_bytes"
+	archiveLinkKey   = "archive_link"
+)
+
+// newLogger 按 -log-format 创建日志, 支持 text 和 json
+func newLogger(format string) (*slog.Logger, error) {
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stdout, nil)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, nil)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, support text, json", format)
+}
+
+// requestLog 为每个请求分配请求 ID, 结束后记录方法, 路由, 状态码, 耗时, 返回的字节数和从源站读取的字节数
+func requestLog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		var upstream atomic.Int64
+		c.Set(upstreamBytesKey, &upstream)
+
+		c.Next()
+
+		attrs := []any{
+			slog.String("request_id", id),
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", c.Writer.Status()),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.Int64("upstream_bytes", upstream.Load()),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if link := c.GetString(archiveLinkKey); link != "" {
+			attrs = append(attrs, slog.String("link", link))
+		}
+		logger.Info("request", attrs...)
+	}
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// upstreamBytes 返回统计本次请求从源站读取字节数的计数器, 没有经过 requestLog 时返回 nil
+func upstreamBytes(c *gin.Context) *atomic.Int64 {
+	v, _ := c.Get(upstreamBytesKey)
+	n, _ := v.(*atomic.Int64)
+	return n
+}
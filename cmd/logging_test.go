@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestLog(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	var mu sync.Mutex
+	var upstreamIDs []string
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		upstreamIDs = append(upstreamIDs, r.Header.Get(requestIDHeader))
+		mu.Unlock()
+		return false
+	}
+	var buf bytes.Buffer
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(requestLog(slog.New(slog.NewJSONHandler(&buf, nil))))
+	archiveRoutes(r)
+	link := origin.link("/a.zip")
+
+	w := get(t, r, query("/down", "link", link, "path", "/a.txt"), requestIDHeader, "trace-42")
+	if got := w.Header().Get(requestIDHeader); got != "trace-42" {
+		t.Errorf("echoed request id = %q, want trace-42", got)
+	}
+	mu.Lock()
+	for _, id := range upstreamIDs {
+		if id != "trace-42" {
+			t.Errorf("origin received request id %q, want trace-42", id)
+		}
+	}
+	if len(upstreamIDs) == 0 {
+		t.Error("origin received no requests")
+	}
+	mu.Unlock()
+
+	var entry struct {
+		Msg           string `json:"msg"`
+		RequestID     string `json:"request_id"`
+		Route         string `json:"route"`
+		Status        int    `json:"status"`
+		Bytes         int    `json:"bytes"`
+		UpstreamBytes int64  `json:"upstream_bytes"`
+		Link          string `json:"link"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log %q: %v", buf.String(), err)
+	}
+	if entry.Msg != "request" || entry.RequestID != "trace-42" || entry.Route != "/down" || entry.Status != 200 ||
+		entry.Bytes != len("alpha") || entry.UpstreamBytes <= 0 || entry.Link != link {
+		t.Errorf("log entry = %+v", entry)
+	}
+
+	// 不合法的请求 ID 替换为生成的 ID, 避免日志注入
+	buf.Reset()
+	w = get(t, r, query("/down", "link", link, "path", "/a.txt"), requestIDHeader, "forged\nlevel=ERROR")
+	if got := w.Header().Get(requestIDHeader); !validRequestID.MatchString(got) || len(got) != 32 {
+		t.Errorf("generated request id = %q", got)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("forged")) {
+		t.Errorf("log contains the forged request id: %s", buf.String())
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics 以 Prometheus 文本格式在 /metrics 输出的指标, 由 -metrics 开启.
+// 没有开启时 metrics 为 nil, 所有记录方法都不做任何事
+type Metrics struct {
+	requests        *counterVec
+	requestDuration *histogramVec
+	servedBytes     *counterVec
+	upstreamBytes   *counterVec
+	openDuration    *histogramVec
+	extractDuration *histogramVec
+	cacheRequests   *counterVec
+}
+
+var metrics *Metrics
+
+// durationBuckets 耗时直方图的上界, 单位为秒
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:        newCounterVec("rads_requests_total", "HTTP requests by route and status.", "route", "status"),
+		requestDuration: newHistogramVec("rads_request_duration_seconds", "HTTP request duration by route.", durationBuckets, "route"),
+		servedBytes:     newCounterVec("rads_served_bytes_total", "Response body bytes served by route.", "route"),
+		upstreamBytes:   newCounterVec("rads_upstream_bytes_total", "Bytes read from remote archive origins."),
+		openDuration:    newHistogramVec("rads_archive_open_duration_seconds", "Time to open and identify an archive.", durationBuckets, "result"),
+		extractDuration: newHistogramVec("rads_extract_duration_seconds", "Time of one walk over the entries of an archive.", durationBuckets, "result"),
+		cacheRequests:   newCounterVec("rads_cache_requests_total", "Cache lookups by cache and result (hit or miss).", "cache", "result"),
+	}
+}
+
+// middleware 记录每个请求的路由, 状态码, 耗时, 返回的字节数和从源站读取的字节数, 需要在 requestLog 之后
+func (m *Metrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requests.add(1, route, strconv.Itoa(c.Writer.Status()))
+		m.requestDuration.observe(time.Since(start).Seconds(), route)
+		m.servedBytes.add(float64(max(c.Writer.Size(), 0)), route)
+		if n := upstreamBytes(c); n != nil {
+			m.upstreamBytes.add(float64(n.Load()))
+		}
+	}
+}
+
+// handler 输出所有指标
+func (m *Metrics) handler(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(200)
+	m.requests.write(c.Writer)
+	m.requestDuration.write(c.Writer)
+	m.servedBytes.write(c.Writer)
+	m.upstreamBytes.write(c.Writer)
+	m.openDuration.write(c.Writer)
+	m.extractDuration.write(c.Writer)
+	m.cacheRequests.write(c.Writer)
+}
+
+// observeOpen 记录打开归档的耗时
+func (m *Metrics) observeOpen(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.openDuration.observe(d.Seconds(), metricResult(err))
+}
+
+// observeExtract 记录遍历归档的耗时
+func (m *Metrics) observeExtract(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.extractDuration.observe(d.Seconds(), metricResult(err))
+}
+
+// cacheLookup 记录缓存是否命中, cache 为 index, list 或 body
+func (m *Metrics) cacheLookup(cache string, hit bool) {
+	if m == nil {
+		return
+	}
+	if hit {
+		m.cacheRequests.add(1, cache, "hit")
+	} else {
+		m.cacheRequests.add(1, cache, "miss")
+	}
+}
+
+func metricResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// counterVec 按标签值区分的计数器
+type counterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (cv *counterVec) add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	cv.mu.Lock()
+	cv.values[key] += v
+	cv.mu.Unlock()
+}
+
+func (cv *counterVec) write(w io.Writer) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", cv.name, cv.help, cv.name)
+	if len(cv.labels) == 0 && len(cv.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", cv.name)
+	}
+	for _, key := range sortedKeys(cv.values) {
+		fmt.Fprintf(w, "%s%s %s\n", cv.name, formatLabels(cv.labels, key, ""), formatFloat(cv.values[key]))
+	}
+}
+
+// histogramVec 按标签值区分的直方图
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	mu         sync.Mutex
+	values     map[string]*histogram
+}
+
+type histogram struct {
+	// counts 每个上界 (不累计) 的观测数, 最后一个为 +Inf
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+}
+
+func (hv *histogramVec) observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	h, ok := hv.values[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(hv.buckets)+1)}
+		hv.values[key] = h
+	}
+	h.counts[sort.SearchFloat64s(hv.buckets, v)]++
+	h.sum += v
+	h.count++
+}
+
+func (hv *histogramVec) write(w io.Writer) {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", hv.name, hv.help, hv.name)
+	for _, key := range sortedKeys(hv.values) {
+		h := hv.values[key]
+		var cumulative uint64
+		for i, count := range h.counts {
+			cumulative += count
+			le := math.Inf(1)
+			if i < len(hv.buckets) {
+				le = hv.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, formatLabels(hv.labels, key, formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.name, formatLabels(hv.labels, key, ""), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.name, formatLabels(hv.labels, key, ""), h.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels 输出 {name="value",...}, le 不为空时追加直方图的 le 标签
+func formatLabels(names []string, key, le string) string {
+	pairs := make([]string, 0, len(names)+1)
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, names[i]+`="`+labelEscaper.Replace(value)+`"`)
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
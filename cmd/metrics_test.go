@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetrics(t *testing.T) {
+	setupConf(t)
+	metrics = NewMetrics()
+	var err error
+	if bodyCache, err = NewBodyCache(t.TempDir(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	defer bodyCache.Close()
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(requestLog(slog.New(slog.NewTextHandler(io.Discard, nil))), metrics.middleware())
+	archiveRoutes(r)
+	r.GET("/metrics", metrics.handler)
+
+	get(t, r, query("/list", "link", origin.link("/a.zip")))
+	// 第二次下载命中缓存
+	get(t, r, query("/down", "link", origin.link("/a.zip"), "path", "/a.txt"))
+	get(t, r, query("/down", "link", origin.link("/a.zip"), "path", "/a.txt"))
+	w := get(t, r, "/metrics")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := w.Body.String()
+	for _, name := range []string{
+		"rads_requests_total", "rads_request_duration_seconds", "rads_served_bytes_total", "rads_upstream_bytes_total",
+		"rads_archive_open_duration_seconds", "rads_extract_duration_seconds", "rads_cache_requests_total",
+	} {
+		if !strings.Contains(body, "\n# TYPE "+name+" ") {
+			t.Errorf("metric %s missing", name)
+		}
+	}
+	for _, series := range []string{
+		`rads_requests_total{route="/list",status="200"} 1`,
+		`rads_requests_total{route="/down",status="200"} 2`,
+		`rads_served_bytes_total{route="/down"} 10`,
+		`rads_request_duration_seconds_count{route="/down"} 2`,
+		`rads_cache_requests_total{cache="body",result="miss"} 1`,
+		`rads_cache_requests_total{cache="body",result="hit"} 1`,
+	} {
+		if !strings.Contains(body, "\n"+series+"\n") {
+			t.Errorf("series %s missing", series)
+		}
+	}
+	if strings.Contains(body, "rads_upstream_bytes_total 0\n") {
+		t.Error("upstream bytes should be counted")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var mimeTypes = map[string]string{
+	".zip":  "application/zip",
+	".7z":   "application/x-7z-compressed",
+	".rar":  "application/x-rar-compressed",
+	".tar":  "application/x-tar",
+	".gz":   "application/gzip",
+	".tgz":  "application/gzip",
+	".bz2":  "application/x-bzip2",
+	".xz":   "application/x-xz",
+	".lz4":  "application/x-lz4",
+	".zst":  "application/zstd",
+	".mkv":  "video/x-matroska",
+	".mp4":  "video/mp4",
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".pdf":  "application/pdf",
+	".txt":  "text/plain; charset=utf-8",
+	".epub": "application/epub+zip",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+// mimeByName 根据文件扩展名获取 MIME 类型
+func mimeByName(name string) string {
+	if mime, ok := mimeTypes[strings.ToLower(path.Ext(name))]; ok {
+		return mime
+	}
+	return "application/octet-stream"
+}
+
+// contentDisposition 构造下载文件的 Content-Disposition, disposition 为 attachment 或 inline, 去掉文件名中的控制字符.
+// filename 为 ASCII 回退名, 非 ASCII 字符, 引号和反斜杠替换为 _; filename* 按 RFC 5987 编码完整的文件名
+func contentDisposition(disposition, name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	fallback := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	return disposition + `; filename="` + fallback + `"; filename*=UTF-8''` + encodeRFC5987(name)
+}
+
+// encodeRFC5987 对 attr-char 以外的字节进行百分号编码
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// sniffLen http.DetectContentType 最多使用的字节数
+const sniffLen = 512
+
+// preferSniffed 根据内容识别的类型更具体时使用识别的类型, 否则使用扩展名对应的类型
+func preferSniffed(byName, sniffed string) string {
+	switch {
+	case sniffed == "application/octet-stream":
+		return byName
+	case strings.HasPrefix(sniffed, "text/plain") && byName != "application/octet-stream":
+		return byName
+	}
+	return sniffed
+}
+
+var categoryOrder = []string{"video", "audio", "image", "document", "archive", "other"}
+
+// fileCategory 根据 MIME 类型将文件归类, 目录归为 other
+func fileCategory(obj ObjResp) string {
+	if obj.IsDir {
+		return "other"
+	}
+	mime := mimeByName(obj.Name)
+	switch {
+	case strings.HasPrefix(mime, "video/"):
+		return "video"
+	case strings.HasPrefix(mime, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mime, "image/"):
+		return "image"
+	case strings.HasPrefix(mime, "text/"), mime == "application/pdf",
+		mime == "application/epub+zip", strings.HasPrefix(mime, "application/vnd.openxmlformats"):
+		return "document"
+	case mime != "application/octet-stream":
+		return "archive"
+	}
+	return "other"
+}
+
+// groupByCategory 按类别对文件分组排序, 返回每个类别的数量
+func groupByCategory(objs []ObjResp) map[string]int {
+	groups := make(map[string]int, len(categoryOrder))
+	for _, category := range categoryOrder {
+		groups[category] = 0
+	}
+	rank := make(map[string]int, len(categoryOrder))
+	for i, category := range categoryOrder {
+		rank[category] = i
+	}
+	for i := range objs {
+		objs[i].Category = fileCategory(objs[i])
+		groups[objs[i].Category]++
+	}
+	sort.SliceStable(objs, func(i, j int) bool {
+		return rank[objs[i].Category] < rank[objs[j].Category]
+	})
+	return groups
+}
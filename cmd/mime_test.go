@@ -0,0 +1,189 @@
+package main
+
+import (
+	"mime"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListGroupByType(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/media.zip": zipBytes(t,
+			testEntry{Name: "notes.txt", Body: "n"},
+			testEntry{Name: "movies/"},
+			testEntry{Name: "movies/clip.MP4", Body: "v"},
+			testEntry{Name: "music/song.mp3", Body: "a"},
+			testEntry{Name: "music/song.flac", Body: "a"},
+			testEntry{Name: "photo.png", Body: "i"},
+			testEntry{Name: "book.pdf", Body: "d"},
+			testEntry{Name: "inner.7z", Body: "z"},
+			testEntry{Name: "blob.bin", Body: "o"},
+		),
+	})
+	r := newTestRouter()
+
+	resp := getData[ListResp](t, r, query("/list", "link", origin.link("/media.zip"), "cascade", "true", "group", "type", "per_page", "100"))
+	wantCategory := map[string]string{
+		"notes.txt":       "document",
+		"movies/":         "other",
+		"movies/clip.MP4": "video",
+		"music/song.mp3":  "audio",
+		"music/song.flac": "audio",
+		"photo.png":       "image",
+		"book.pdf":        "document",
+		"inner.7z":        "archive",
+		"blob.bin":        "other",
+	}
+	gotCategory := map[string]string{}
+	for _, obj := range resp.Content {
+		gotCategory[obj.NameInArchive] = obj.Category
+	}
+	if !reflect.DeepEqual(gotCategory, wantCategory) {
+		t.Errorf("categories = %v, want %v", gotCategory, wantCategory)
+	}
+	wantGroups := map[string]int{"video": 1, "audio": 2, "image": 1, "document": 2, "archive": 1, "other": 2}
+	if !reflect.DeepEqual(resp.Groups, wantGroups) {
+		t.Errorf("groups = %v, want %v", resp.Groups, wantGroups)
+	}
+
+	// 条目按类别的顺序排列
+	rank := map[string]int{}
+	for i, category := range categoryOrder {
+		rank[category] = i
+	}
+	for i := 1; i < len(resp.Content); i++ {
+		if rank[resp.Content[i-1].Category] > rank[resp.Content[i].Category] {
+			t.Errorf("%s (%s) listed before %s (%s)", resp.Content[i-1].NameInArchive, resp.Content[i-1].Category,
+				resp.Content[i].NameInArchive, resp.Content[i].Category)
+		}
+	}
+
+	if code := getCode(t, r, query("/list", "link", origin.link("/media.zip"), "group", "size")); code != 400 {
+		t.Errorf("unknown group: code = %d, want 400", code)
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	setupConf(t)
+	bodies := map[string]string{
+		"image":    "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"document": "%PDF-1.7\n%\xe2\xe3\xcf\xd3\n",
+	}
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "image", Body: bodies["image"]}, testEntry{Name: "document", Body: bodies["document"]}),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+	want := map[string]string{"image": "image/png", "document": "application/pdf"}
+
+	list := getData[ListResp](t, r, query("/list", "link", link, "sniff", "true"))
+	got := map[string]string{}
+	for _, obj := range list.Content {
+		got[obj.NameInArchive] = obj.ContentType
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("list content types = %v, want %v", got, want)
+	}
+	// 不识别时不读取文件内容
+	list = getData[ListResp](t, r, query("/list", "link", link))
+	for _, obj := range list.Content {
+		if obj.ContentType != "" {
+			t.Errorf("list without sniff: %s content type %q", obj.NameInArchive, obj.ContentType)
+		}
+	}
+
+	for name, contentType := range want {
+		w := get(t, r, query("/down", "link", link, "path", "/"+name, "sniff", "true"))
+		if got := w.Header().Get("Content-Type"); got != contentType {
+			t.Errorf("down %s: Content-Type = %q, want %q", name, got, contentType)
+		}
+		// 识别时读取的开头部分仍然返回给客户端
+		if w.Body.String() != bodies[name] {
+			t.Errorf("down %s: body %q", name, w.Body.String())
+		}
+	}
+}
+
+func TestDownSniffUnknownExtension(t *testing.T) {
+	setupConf(t)
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR" + strings.Repeat("\x00", 1000)
+	text := strings.Repeat("plain text without an extension\n", 40)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "image", Body: png},
+			testEntry{Name: "README", Body: text},
+			testEntry{Name: "song.mp3", Body: text},
+		),
+	})
+	r := newTestRouter()
+
+	for name, want := range map[string]struct{ contentType, body string }{
+		"/image":  {"image/png", png},
+		"/README": {"text/plain; charset=utf-8", text},
+		// 已知扩展名不识别内容
+		"/song.mp3": {"audio/mpeg", text},
+	} {
+		w := get(t, r, query("/down", "link", origin.link("/a.zip"), "path", name))
+		if got := w.Header().Get("Content-Type"); got != want.contentType {
+			t.Errorf("%s: Content-Type = %q, want %q", name, got, want.contentType)
+		}
+		// 识别时读取的开头部分仍然返回, 内容完整
+		if w.Body.String() != want.body {
+			t.Errorf("%s: %d body bytes, want %d", name, w.Body.Len(), len(want.body))
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	for name, want := range map[string]string{
+		"报告 2024.pdf":   `attachment; filename="__ 2024.pdf"; filename*=UTF-8''%E6%8A%A5%E5%91%8A%202024.pdf`,
+		`say "hi".txt`:  `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`,
+		"a\r\nb\\c.txt": `attachment; filename="ab_c.txt"; filename*=UTF-8''ab%5Cc.txt`,
+	} {
+		if got := contentDisposition("attachment", name); got != want {
+			t.Errorf("contentDisposition(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestDownContentDisposition(t *testing.T) {
+	setupConf(t)
+	files := []string{"文件.txt", `quote"d.txt`}
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: files[0], Body: "cjk"}, testEntry{Name: files[1], Body: "quote"}),
+	})
+	r := newTestRouter()
+
+	for _, name := range files {
+		w := get(t, r, query("/down", "link", origin.link("/a.zip"), "path", "/"+name))
+		// mime 解析 filename* 得到原始文件名
+		_, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition"))
+		if err != nil || params["filename"] != name {
+			t.Errorf("%s: Content-Disposition %q parses to %v, %v", name, w.Header().Get("Content-Disposition"), params, err)
+		}
+	}
+}
+
+func TestDownDisposition(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "图片.png", Body: "\x89PNG\r\n\x1a\n"})})
+	r := newTestRouter()
+
+	for param, want := range map[string]string{"": "attachment", "attachment": "attachment", "inline": "inline"} {
+		kv := []string{"link", origin.link("/a.zip"), "path", "/图片.png"}
+		if param != "" {
+			kv = append(kv, "disposition", param)
+		}
+		w := get(t, r, query("/down", kv...))
+		// 两种方式都保留 RFC 5987 编码的文件名
+		disposition, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition"))
+		if err != nil || disposition != want || params["filename"] != "图片.png" {
+			t.Errorf("disposition %q: Content-Disposition %q, want %s with the original filename", param, w.Header().Get("Content-Disposition"), want)
+		}
+	}
+	if code := getCode(t, r, query("/down", "link", origin.link("/a.zip"), "path", "/图片.png", "disposition", "preview")); code != 400 {
+		t.Errorf("unknown disposition: code = %d, want 400", code)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestWindowsSeparators(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/win.zip": zipBytes(t,
+			testEntry{Name: `docs\a.txt`, Body: "alpha"},
+			testEntry{Name: `docs\sub\b.txt`, Body: "beta"},
+			testEntry{Name: `empty\`, Mode: os.ModeDir | 0o755},
+			// 没有以 / 结尾的目录和重复的 /
+			testEntry{Name: "plain", Mode: os.ModeDir | 0o755},
+			testEntry{Name: "slashes//c.txt", Body: "gamma"},
+		),
+		// tar 中的 \ 是文件名的一部分
+		"/a.tar": tarBytes(t, testEntry{Name: `back\slash.txt`, Body: "tar"}),
+	})
+	r := newTestRouter()
+	link := origin.link("/win.zip")
+
+	for _, tc := range []struct {
+		kv   []string
+		want []string
+	}{
+		{nil, []string{"docs/", "empty/", "plain/", "slashes/"}},
+		{[]string{"path", "/docs"}, []string{"docs/a.txt", "docs/sub/"}},
+		{[]string{"path", "/docs/sub/"}, []string{"docs/sub/b.txt"}},
+		{[]string{"path", "/slashes"}, []string{"slashes/c.txt"}},
+	} {
+		got := names(getData[ListResp](t, r, query("/list", append([]string{"link", link}, tc.kv...)...)).Content)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("list %v: %v, want %v", tc.kv, got, tc.want)
+		}
+	}
+
+	for name, body := range map[string]string{"/docs/sub/b.txt": "beta", "/slashes/c.txt": "gamma"} {
+		if w := get(t, r, query("/down", "link", link, "path", name)); w.Code != 200 || w.Body.String() != body {
+			t.Errorf("down %s: status %d, body %q", name, w.Code, w.Body.String())
+		}
+	}
+	for _, obj := range getData[ListResp](t, r, query("/list", "link", link)).Content {
+		if obj.NameInArchive == "plain/" && !obj.IsDir {
+			t.Errorf("directory without trailing slash: %+v", obj)
+		}
+	}
+
+	got := names(getData[ListResp](t, r, query("/list", "link", origin.link("/a.tar"))).Content)
+	if want := []string{`back\slash.txt`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tar: %v, want %v", got, want)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "docs/file.txt", Body: "file"}, testEntry{Name: "docs/sub/other.txt", Body: "other"}),
+	})
+	r := newTestRouter()
+	link := origin.link("/a.zip")
+
+	// 默认区分大小写
+	if code := getCode(t, r, query("/get", "link", link, "path", "/Docs/File.TXT")); code != 404 {
+		t.Errorf("case-sensitive get: code = %d, want 404", code)
+	}
+	got := getData[GetResp](t, r, query("/get", "link", link, "path", "/Docs/File.TXT", "case_insensitive", "true"))
+	if got.NameInArchive != "docs/file.txt" || got.Name != "file.txt" {
+		t.Errorf("case-insensitive get: name_in_archive %q, name %q", got.NameInArchive, got.Name)
+	}
+	if w := get(t, r, query("/down", "link", link, "path", "/DOCS/file.Txt", "case_insensitive", "true")); w.Code != 200 || w.Body.String() != "file" {
+		t.Errorf("case-insensitive down: status %d, body %q", w.Code, w.Body.String())
+	}
+
+	// 返回归档中记录的名称
+	list := getData[ListResp](t, r, query("/list", "link", link, "path", "/DOCS", "case_insensitive", "true"))
+	if want := []string{"docs/file.txt", "docs/sub/"}; !reflect.DeepEqual(names(list.Content), want) {
+		t.Errorf("case-insensitive list: %v, want %v", names(list.Content), want)
+	}
+	if list := getData[ListResp](t, r, query("/list", "link", link, "path", "/DOCS")); len(list.Content) != 0 {
+		t.Errorf("case-sensitive list: %v, want nothing", names(list.Content))
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+// nestSeparator 路径中进入内层归档的分隔符, 例如 /data.tar.gz!/top.txt 为 data.tar.gz 中的 top.txt
+const nestSeparator = "!/"
+
+// ErrNestTooDeep 路径中内层归档的层数超过 -max-nest-depth
+var ErrNestTooDeep = errors.New("nested archives are too deep")
+
+// NotArchiveError 路径中 !/ 前的文件不是支持的归档
+type NotArchiveError struct {
+	Path string
+}
+
+func (e *NotArchiveError) Error() string {
+	return fmt.Sprintf("%s is not a supported archive", e.Path)
+}
+
+// openNested 按路径中的 !/ 依次进入内层归档, 返回最内层的归档和其中的路径. -max-nest-depth 为 0 时 !/ 没有特殊含义.
+// 内层归档需要随机读取, 整个读入内存, 大小受 -max-buffer-bytes 限制
+func openNested(c *gin.Context, arc *Archive, req *ArchiveReq, reqPath string) (*Archive, string, error) {
+	if conf.MaxNestDepth <= 0 || !strings.Contains(reqPath, nestSeparator) {
+		return arc, reqPath, nil
+	}
+	parts := strings.Split(reqPath, nestSeparator)
+	if len(parts)-1 > conf.MaxNestDepth {
+		return nil, "", fmt.Errorf("%w, at most %d levels", ErrNestTooDeep, conf.MaxNestDepth)
+	}
+	for _, part := range parts[:len(parts)-1] {
+		f, err := arc.ExtractFile(c, "/"+strings.TrimPrefix(part, "/"))
+		if err != nil {
+			return nil, "", err
+		}
+		data, err := readNested(*f)
+		if err != nil {
+			return nil, "", err
+		}
+		inner, err := getBytesArchive(f.Name(), data)
+		if errors.Is(err, stdArchiever.ErrNoMatch) {
+			return nil, "", &NotArchiveError{Path: f.NameInArchive}
+		} else if err != nil {
+			return nil, "", err
+		}
+		configureArchive(inner, req)
+		arc = inner
+	}
+	return arc, "/" + parts[len(parts)-1], nil
+}
+
+// readNested 读取内层归档的全部内容
+func readNested(f stdArchiever.File) ([]byte, error) {
+	limit := conf.MaxBufferBytes
+	if limit > 0 && f.Size() > limit {
+		return nil, fmt.Errorf("%w: nested archive %s", archiver.ErrBufferLimit, f.NameInArchive)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if limit > 0 {
+		r = io.LimitReader(rc, limit+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: nested archive %s", archiver.ErrBufferLimit, f.NameInArchive)
+	}
+	return data, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNestedTwoLevels(t *testing.T) {
+	setupConf(t)
+	inner := zipBytes(t, testEntry{Name: "dir/file.txt", Body: "deep"}, testEntry{Name: "dir/other.txt", Body: "other"})
+	middle := gzipBytes(t, tarBytes(t, testEntry{Name: "inner.zip", Body: string(inner)}, testEntry{Name: "top.txt", Body: "top"}))
+	origin := newTestOrigin(t, map[string][]byte{
+		"/outer.zip": zipBytes(t, testEntry{Name: "data.tar.gz", Body: string(middle)}, testEntry{Name: "a.txt", Body: "alpha"}),
+	})
+	link := origin.link("/outer.zip")
+	r := newTestRouter()
+
+	list := getData[ListResp](t, r, query("/list", "link", link, "path", "/data.tar.gz!/inner.zip!/dir"))
+	if got, want := names(list.Content), []string{"dir/file.txt", "dir/other.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("list two levels deep = %v, want %v", got, want)
+	}
+	for path, body := range map[string]string{
+		"/data.tar.gz!/top.txt":                 "top",
+		"/data.tar.gz!/inner.zip!/dir/file.txt": "deep",
+	} {
+		w := get(t, r, query("/down", "link", link, "path", path))
+		if w.Code != 200 || w.Body.String() != body {
+			t.Errorf("down %s: status %d, body %q", path, w.Code, w.Body.String())
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		depth int
+		path  string
+		code  int
+		msg   string
+	}{
+		"too deep":    {1, "/data.tar.gz!/inner.zip!/dir/file.txt", 400, ErrNestTooDeep.Error()},
+		"not archive": {3, "/a.txt!/b.txt", 400, "a.txt is not a supported archive"},
+		// -max-nest-depth 为 0 时 !/ 是普通的文件名
+		"disabled": {0, "/data.tar.gz!/top.txt", 404, ""},
+	} {
+		conf.MaxNestDepth = tc.depth
+		resp := decodeResp[json.RawMessage](t, get(t, r, query("/down", "link", link, "path", tc.path)))
+		if resp.Code != tc.code || !strings.HasPrefix(resp.Message, tc.msg) {
+			t.Errorf("%s: code %d, message %q, want %d %q", name, resp.Code, resp.Message, tc.code, tc.msg)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+// ErrBinaryPreview format=text 时文件内容不是文本
+var ErrBinaryPreview = errors.New("file content is binary, preview it without format=text to get base64")
+
+type PreviewReq struct {
+	GetReq
+	// Offset 从文件的第几个字节开始预览
+	Offset int64 `json:"offset" form:"offset"`
+	// Length 预览的字节数, 为 0 或超过 -max-preview-bytes 时为 -max-preview-bytes
+	Length int `json:"length" form:"length"`
+	// Format 为 text 时直接返回文本内容, 二进制文件返回 415; 默认返回 JSON
+	Format string `json:"format" form:"format"`
+}
+
+type PreviewResp struct {
+	Name string `json:"name"`
+	// Size 文件大小, 未知时为 -1
+	Size int64 `json:"size"`
+	// Offset, Length 返回内容的实际范围. 文本按 UTF-8 字符截断, 可能比请求的范围少几个字节, 下一段从 Offset+Length 开始
+	Offset int64 `json:"offset"`
+	Length int   `json:"length"`
+	// EOF 已经读到文件末尾
+	EOF         bool   `json:"eof"`
+	ContentType string `json:"content_type"`
+	Binary      bool   `json:"binary"`
+	// Encoding 文本为 utf-8, 二进制内容为 base64
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+}
+
+// Preview 返回文件内容中的一段, 用于预览大的日志或 CSV 的开头而不需要下载整个文件.
+// 只能顺序解压的文件需要从头解压到 offset
+func Preview(c *gin.Context) {
+	var req PreviewReq
+	if err := c.ShouldBind(&req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	if req.Offset < 0 || req.Length < 0 {
+		ErrorStrResp(c, "offset and length must be non-negative integers", 400)
+		return
+	}
+	if req.Format != "" && req.Format != "json" && req.Format != "text" {
+		ErrorStrResp(c, fmt.Sprintf("unknown format %q, support json, text", req.Format), 400)
+		return
+	}
+	length := req.Length
+	if length == 0 || length > conf.MaxPreviewBytes {
+		length = conf.MaxPreviewBytes
+	}
+	reqPath, err := handerReqPath(req.Path, false, req.PathEncoded)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+	// 缓存的文件支持随机读取, 不需要从头解压到 offset
+	_, _, cached, ok := cachedEntry(c, &req.ArchiveReq, arc, reqPath)
+	f := &cached
+	if !ok {
+		if f, err = extractFile(c, arc, &req.GetReq, reqPath); err != nil {
+			ExtractErrorResp(c, err)
+			return
+		}
+	}
+	data, eof, err := readPreview(*f, req.Offset, length)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	resp := PreviewResp{Name: f.Name(), Size: f.Size(), Offset: req.Offset, EOF: eof}
+	if text, lead, ok := textChunk(data, req.Offset == 0, eof); ok {
+		resp.Offset += int64(lead)
+		resp.Length, resp.Encoding, resp.Content = len(text), "utf-8", string(text)
+		data = text
+	} else {
+		resp.Length, resp.Binary, resp.Encoding = len(data), true, "base64"
+		resp.Content = base64.StdEncoding.EncodeToString(data)
+	}
+	resp.ContentType = mimeByName(f.Name())
+	if resp.ContentType == "application/octet-stream" && req.Offset == 0 {
+		// 内容不是 UTF-8 文本时不采用识别出的 text/plain
+		if sniffed := http.DetectContentType(data); !resp.Binary || !strings.HasPrefix(sniffed, "text/") {
+			resp.ContentType = preferSniffed(resp.ContentType, sniffed)
+		}
+	}
+
+	if req.Format != "text" {
+		SuccessResp(c, resp)
+		return
+	}
+	if resp.Binary {
+		ErrorStrResp(c, ErrBinaryPreview.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	c.Header("X-Preview-Offset", strconv.FormatInt(resp.Offset, 10))
+	c.Header("X-Preview-EOF", strconv.FormatBool(resp.EOF))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", data)
+}
+
+// readPreview 读取文件从 offset 开始的最多 length 个字节, 并返回是否已经读到文件末尾
+func readPreview(f stdArchiever.File, offset int64, length int) ([]byte, bool, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, false, err
+	}
+	defer rc.Close()
+	// 多读一个字节判断是否到达文件末尾
+	buf := make([]byte, length+1)
+	var n int
+	if ra, ok := rc.(io.ReaderAt); ok {
+		n, err = ra.ReadAt(buf, offset)
+	} else {
+		if _, err = io.CopyN(io.Discard, rc, offset); err == nil {
+			n, err = io.ReadFull(rc, buf)
+		}
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	if n > length {
+		return buf[:length], false, nil
+	}
+	return buf[:n], true, nil
+}
+
+// textChunk 判断内容是否为文本, 是时去掉开头和结尾被截断的 UTF-8 字符, 返回剩余的内容和开头去掉的字节数.
+// atStart 为 true 时内容从文件开头开始, atEnd 为 true 时到文件末尾结束, 对应的一端没有被截断的字符
+func textChunk(data []byte, atStart, atEnd bool) ([]byte, int, bool) {
+	lead := 0
+	if !atStart {
+		for lead < len(data) && lead < utf8.UTFMax-1 && !utf8.RuneStart(data[lead]) {
+			lead++
+		}
+	}
+	end := len(data)
+	if !atEnd {
+		for i := 1; i < utf8.UTFMax && end-i >= lead; i++ {
+			if utf8.RuneStart(data[end-i]) {
+				if !utf8.FullRune(data[end-i : end]) {
+					end -= i
+				}
+				break
+			}
+		}
+	}
+	text := data[lead:end]
+	if !utf8.Valid(text) {
+		return nil, 0, false
+	}
+	for _, b := range text {
+		// 文本中常见的控制字符: \b \t \n \v \f \r 和 ESC (终端颜色)
+		if (b < 0x20 && (b < '\b' || b > '\r') && b != 0x1b) || b == 0x7f {
+			return nil, 0, false
+		}
+	}
+	return text, lead, true
+}
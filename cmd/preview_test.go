@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreview(t *testing.T) {
+	setupConf(t)
+	var sb strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, "2024-01-01 12:00:%02d line %d\n", i%60, i)
+	}
+	logText := sb.String()
+	binary := "\x00\x01\x02\x03\xff\xfe" + strings.Repeat("\x00", 100)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "app.log", Body: logText},
+			testEntry{Name: "stored.log", Body: logText, Stored: true},
+			testEntry{Name: "blob.bin", Body: binary},
+			testEntry{Name: "utf8.txt", Body: "héllo wörld"},
+		),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+	preview := func(path string, kv ...string) PreviewResp {
+		t.Helper()
+		return getData[PreviewResp](t, r, query("/preview", append([]string{"link", link, "path", path}, kv...)...))
+	}
+
+	// 顺序解压和可以随机读取的文件结果相同
+	for _, name := range []string{"/app.log", "/stored.log"} {
+		head := preview(name, "length", "100")
+		if head.Binary || head.Encoding != "utf-8" || head.Content != logText[:100] || head.Offset != 0 || head.Length != 100 || head.EOF {
+			t.Errorf("%s head: %+v", name, head)
+		}
+		if head.Size != int64(len(logText)) || head.ContentType != "text/plain; charset=utf-8" {
+			t.Errorf("%s head: size %d, content type %q", name, head.Size, head.ContentType)
+		}
+		next := preview(name, "offset", "100", "length", "50")
+		if next.Content != logText[100:150] || next.Offset != 100 {
+			t.Errorf("%s chunk: offset %d, content %q", name, next.Offset, next.Content)
+		}
+		tail := preview(name, "offset", fmt.Sprint(len(logText)-10))
+		if tail.Content != logText[len(logText)-10:] || !tail.EOF {
+			t.Errorf("%s tail: eof %v, content %q", name, tail.EOF, tail.Content)
+		}
+	}
+
+	// 不超过 -max-preview-bytes
+	conf.MaxPreviewBytes = 64
+	if got := preview("/app.log", "length", "1000"); got.Length != 64 || got.Content != logText[:64] {
+		t.Errorf("max preview bytes: length %d", got.Length)
+	}
+	conf.MaxPreviewBytes = 64 << 10
+
+	// 不截断 UTF-8 字符: "héllo" 中 é 占第 1, 2 个字节
+	if got := preview("/utf8.txt", "length", "2"); got.Content != "h" || got.Length != 1 {
+		t.Errorf("utf-8 head: %q, length %d", got.Content, got.Length)
+	}
+	if got := preview("/utf8.txt", "offset", "2", "length", "3"); got.Content != "ll" || got.Offset != 3 || got.Length != 2 {
+		t.Errorf("utf-8 chunk: %q at offset %d", got.Content, got.Offset)
+	}
+
+	bin := preview("/blob.bin")
+	decoded, err := base64.StdEncoding.DecodeString(bin.Content)
+	if !bin.Binary || bin.Encoding != "base64" || err != nil || string(decoded) != binary || !bin.EOF {
+		t.Errorf("binary: binary %v, encoding %q, %d bytes decoded, err %v", bin.Binary, bin.Encoding, len(decoded), err)
+	}
+
+	w := get(t, r, query("/preview", "link", link, "path", "/app.log", "length", "30", "format", "text"))
+	if w.Body.String() != logText[:30] || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" || w.Header().Get("X-Preview-EOF") != "false" {
+		t.Errorf("format=text: body %q, headers %v", w.Body.String(), w.Header())
+	}
+	resp := decodeResp[json.RawMessage](t, get(t, r, query("/preview", "link", link, "path", "/blob.bin", "format", "text")))
+	if resp.Code != 415 || resp.Message != ErrBinaryPreview.Error() {
+		t.Errorf("binary as text: code %d, message %q, want 415", resp.Code, resp.Message)
+	}
+	for _, kv := range [][]string{{"offset", "-1"}, {"length", "-1"}, {"format", "html"}} {
+		if code := getCode(t, r, query("/preview", append([]string{"link", link, "path", "/app.log"}, kv...)...)); code != 400 {
+			t.Errorf("%v: code = %d, want 400", kv, code)
+		}
+	}
+}
+
+func TestPreviewBodyCache(t *testing.T) {
+	setupConf(t)
+	var err error
+	if bodyCache, err = NewBodyCache(t.TempDir(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	defer bodyCache.Close()
+	body := strings.Repeat("abcdefghij", 100)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: body})})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	if w := get(t, r, query("/down", "link", link, "path", "/a.txt")); w.Code != 200 || w.Body.String() != body {
+		t.Fatalf("down: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	// 改写缓存的文件, 预览返回改写后的内容说明读取的是缓存而不是重新解压
+	cached, err := os.ReadDir(bodyCache.dir)
+	if err != nil || len(cached) != 1 {
+		t.Fatalf("cached files = %v, %v", cached, err)
+	}
+	upper := strings.ToUpper(body)
+	if err := os.WriteFile(filepath.Join(bodyCache.dir, cached[0].Name()), []byte(upper), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got := getData[PreviewResp](t, r, query("/preview", "link", link, "path", "/a.txt", "offset", "500", "length", "10"))
+	if got.Content != upper[500:510] || got.Offset != 500 || got.EOF {
+		t.Errorf("cached preview: offset %d, eof %v, content %q, want %q", got.Offset, got.EOF, got.Content, upper[500:510])
+	}
+}
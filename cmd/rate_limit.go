@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimiter 按客户端限制请求速率的令牌桶, 每秒补充 rate 个令牌, 最多积累 burst 个
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	// byKey 为 true 时按请求中的 API 密钥区分客户端, 没有密钥时按 IP
+	byKey bool
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitKeys -rate-limit-key 支持的值
+var rateLimitKeys = []string{"ip", "api-key"}
+
+func NewRateLimiter(rate float64, burst int, key string) (*RateLimiter, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive")
+	}
+	if key != "ip" && key != "api-key" {
+		return nil, fmt.Errorf("unknown rate limit key %q, support %s", key, strings.Join(rateLimitKeys, ", "))
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	rl := &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		byKey:   key == "api-key",
+		buckets: make(map[string]*tokenBucket),
+	}
+	go rl.janitor()
+	return rl, nil
+}
+
+// refillTime 空的令牌桶补满需要的时间, 超过该时间没有请求的桶与新建的桶相同
+func (rl *RateLimiter) refillTime() time.Duration {
+	return time.Duration(rl.burst / rl.rate * float64(time.Second))
+}
+
+// janitor 定期删除已经补满的令牌桶, 避免大量不同的客户端占用内存
+func (rl *RateLimiter) janitor() {
+	ticker := time.NewTicker(max(rl.refillTime(), time.Minute))
+	defer ticker.Stop()
+	for now := range ticker.C {
+		rl.mu.Lock()
+		for key, b := range rl.buckets {
+			if now.Sub(b.last) >= rl.refillTime() {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// allow 从客户端的令牌桶中取一个令牌, 没有令牌时返回需要等待的时间
+func (rl *RateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+}
+
+// clientKey 返回区分客户端的键. 按 API 密钥区分时只使用已通过 authToken 认证的密钥的哈希,
+// 否则客户端每次发送不同的密钥就能得到新的令牌桶
+func (rl *RateLimiter) clientKey(c *gin.Context) string {
+	if rl.byKey {
+		if hash := c.GetString(authKeyHashKey); hash != "" {
+			return "key:" + hash
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// middleware 超过速率的请求返回 429, Retry-After 为可以重试前需要等待的秒数.
+// 按 IP 限速时在 authToken 之前, 按 API 密钥限速时在 authToken 之后
+func (rl *RateLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, wait := rl.allow(rl.clientKey(c), time.Now())
+		if ok {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, Resp[interface{}]{Code: http.StatusTooManyRequests, Message: "rate limit exceeded", ErrorType: "rate_limited"})
+	}
+}
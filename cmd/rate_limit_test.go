@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	rl := &RateLimiter{rate: 1, burst: 2, buckets: make(map[string]*tokenBucket)}
+	now := time.Now()
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := rl.allow("a", now); ok != want {
+			t.Errorf("request %d: allowed %v, want %v", i+1, ok, want)
+		}
+	}
+	if _, wait := rl.allow("a", now); wait != time.Second {
+		t.Errorf("wait = %v, want 1s", wait)
+	}
+	// 其他客户端有自己的令牌桶, 时间过去后补充令牌
+	if ok, _ := rl.allow("b", now); !ok {
+		t.Error("another client should not be limited")
+	}
+	if ok, _ := rl.allow("a", now.Add(time.Second)); !ok {
+		t.Error("a token should be refilled after 1s")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	target := query("/list", "link", origin.link("/a.zip"))
+	send := func(r http.Handler, remoteAddr string, header ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = remoteAddr
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		return doRequest(t, r, req)
+	}
+
+	rl, err := NewRateLimiter(1, 2, "ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(rl.middleware())
+	archiveRoutes(r)
+	for i, want := range []int{200, 200, 429} {
+		w := send(r, "192.0.2.1:1234")
+		if w.Code != want {
+			t.Errorf("request %d: status %d, want %d", i+1, w.Code, want)
+		}
+		if want == 429 && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+		}
+	}
+	if w := send(r, "192.0.2.2:1234"); w.Code != 200 {
+		t.Errorf("another IP: status %d, want 200", w.Code)
+	}
+
+	// 按 API 密钥限速时在认证之后, 同一密钥从不同 IP 共用令牌桶, 伪造的密钥被认证拒绝, 不能得到新的令牌桶
+	if rl, err = NewRateLimiter(1, 2, "api-key"); err != nil {
+		t.Fatal(err)
+	}
+	r = newAuthRouter("s3cret", rl.middleware())
+	for i, tc := range []struct {
+		addr, key string
+		want      int
+	}{
+		{"192.0.2.1:1", "s3cret", 200},
+		{"192.0.2.2:1", "s3cret", 200},
+		{"192.0.2.3:1", "made-up-1", 401},
+		{"192.0.2.3:1", "made-up-2", 401},
+		{"192.0.2.3:1", "s3cret", 429},
+	} {
+		if w := send(r, tc.addr, apiKeyHeader, tc.key); w.Code != tc.want {
+			t.Errorf("request %d: status %d, want %d", i+1, w.Code, tc.want)
+		}
+	}
+
+	if _, err := NewRateLimiter(1, 2, "cookie"); err == nil {
+		t.Error("unknown rate limit key: want an error")
+	}
+}
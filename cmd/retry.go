@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// retryStatus 可以重试的源站状态码, 429 不重试, 由 Retry-After 告知客户端
+var retryStatus = map[int]bool{
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// retryTransport 源站返回可重试的状态码或网络出错时重试 GET 和 HEAD 请求,
+// 第 n 次重试前等待 backoff * 2^(n-1) 加上不超过 jitter 的随机时间
+type retryTransport struct {
+	http.RoundTripper
+	attempts int
+	backoff  time.Duration
+	jitter   time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead
+	for attempt := 1; ; attempt++ {
+		resp, err := t.RoundTripper.RoundTrip(req)
+		if !idempotent || attempt >= t.attempts || !t.retryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		wait := t.backoff << (attempt - 1)
+		if t.jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(t.jitter)))
+		}
+		log.Printf("origin %s %s failed (attempt %d/%d), retrying in %s", req.Method, redactURL(req.URL), attempt, t.attempts, wait)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryable 网络错误 (被拒绝访问的地址除外) 和 retryStatus 中的状态码可以重试
+func (t *retryTransport) retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrLinkBlocked)
+	}
+	return retryStatus[resp.StatusCode]
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roundTripFunc 把函数用作 http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestRetryOrigin(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	var failures atomic.Int64
+	// 源站前两次返回 503, 之后正常
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if failures.Add(-1) >= 0 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	}
+	r := newTestRouter()
+	target := query("/down", "link", origin.link("/a.zip"), "path", "/a.txt")
+
+	for _, tc := range []struct {
+		attempts int
+		ok       bool
+	}{{3, true}, {2, false}} {
+		originTransport = &retryTransport{RoundTripper: newOriginTransport(), attempts: tc.attempts, backoff: time.Millisecond}
+		failures.Store(2)
+		w := get(t, r, target)
+		if ok := w.Code == 200 && w.Body.String() == "alpha"; ok != tc.ok {
+			t.Errorf("%d attempts: status %d, body %q, want success %v", tc.attempts, w.Code, w.Body.String(), tc.ok)
+		}
+		if !tc.ok {
+			if code := decodeResp[json.RawMessage](t, w).Code; code != 502 {
+				t.Errorf("%d attempts: code = %d, want 502", tc.attempts, code)
+			}
+		}
+	}
+}
+
+func TestRetryTransportPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		status int
+		calls  int
+	}{
+		{http.MethodGet, http.StatusBadGateway, 3},
+		{http.MethodHead, http.StatusServiceUnavailable, 3},
+		{http.MethodGet, http.StatusTooManyRequests, 1},
+		{http.MethodGet, http.StatusNotFound, 1},
+		{http.MethodPost, http.StatusServiceUnavailable, 1},
+	} {
+		calls := 0
+		rt := &retryTransport{
+			RoundTripper: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				return &http.Response{StatusCode: tc.status, Body: http.NoBody}, nil
+			}),
+			attempts: 3,
+			backoff:  time.Millisecond,
+		}
+		req, err := http.NewRequest(tc.method, "http://origin.example/a.zip", strings.NewReader(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := rt.RoundTrip(req)
+		if err != nil || resp.StatusCode != tc.status || calls != tc.calls {
+			t.Errorf("%s %d: %d calls, err %v, want %d calls", tc.method, tc.status, calls, err, tc.calls)
+		}
+	}
+}
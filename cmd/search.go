@@ -0,0 +1,214 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	stdpath "path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SearchReq struct {
+	PageReq
+	ArchiveReq
+	SizeFilter
+	Path  string `json:"path"  form:"path"`
+	Query string `json:"query" form:"query" binding:"required"`
+	Fuzzy bool   `json:"fuzzy" form:"fuzzy"`
+	// Regex 为 true 时 query 为正则表达式, 否则包含 * ? [ 时为匹配文件名的 glob, 其余情况为忽略大小写的子串
+	Regex bool `json:"regex" form:"regex"`
+}
+
+// searchMatcher 根据 query 生成匹配归档内路径的函数
+func searchMatcher(query string, regex bool) (func(name string) bool, error) {
+	if regex {
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return nil, errors.New("invalid regex: " + err.Error())
+		}
+		return re.MatchString, nil
+	}
+	if strings.ContainsAny(query, "*?[") {
+		pattern := strings.ToLower(query)
+		if _, err := stdpath.Match(pattern, ""); err != nil {
+			return nil, errors.New("invalid glob: " + err.Error())
+		}
+		// 不含 / 的 glob 只匹配文件名, 否则匹配完整路径
+		return func(name string) bool {
+			name = strings.ToLower(strings.TrimSuffix(name, "/"))
+			if !strings.Contains(pattern, "/") {
+				name = stdpath.Base(name)
+			}
+			ok, _ := stdpath.Match(pattern, name)
+			return ok
+		}, nil
+	}
+	query = strings.ToLower(query)
+	return func(name string) bool {
+		return strings.Contains(strings.ToLower(name), query)
+	}, nil
+}
+
+func Search(c *gin.Context) {
+	var req SearchReq
+	if err := bindPageReq(c, &req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	if req.Fuzzy && req.Regex {
+		ErrorStrResp(c, "fuzzy and regex can not be used together", 400)
+		return
+	}
+	if err := req.SizeFilter.check(); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	match, err := searchMatcher(req.Query, req.Regex)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	reqPath, err := handerReqPath(req.Path, true, req.PathEncoded)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	dFiles, err := arc.CascadeExtractDirs(c, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	objs := make([]ObjResp, 0)
+	if req.Fuzzy {
+		// 模糊匹配只保留得分最高的 -search-max-scored 个结果
+		top := newTopMatches(conf.SearchMaxScored)
+		for _, f := range dFiles {
+			score, ok := fuzzyScore(req.Query, f.NameInArchive)
+			if !ok {
+				continue
+			}
+			if obj := buildObj(arc, &f); req.SizeFilter.match(obj) {
+				top.add(obj, score)
+			}
+		}
+		objs = top.sorted()
+	} else {
+		for _, f := range dFiles {
+			if !match(f.NameInArchive) {
+				continue
+			}
+			if obj := buildObj(arc, &f); req.SizeFilter.match(obj) {
+				objs = append(objs, obj)
+			}
+		}
+	}
+	total, objs := pagination(objs, &req.PageReq)
+
+	SuccessResp(c, ListResp{
+		Content:   objs,
+		Total:     int64(total),
+		TotalSize: sumSize(objs),
+	})
+}
+
+// fuzzyScore 判断 query 是否为 target 的子序列 (忽略大小写) 并打分,
+// 连续匹配和匹配在路径分段开头的字符得分更高, 例如 cfgmain 可以匹配 config/main.yaml
+func fuzzyScore(query, target string) (int, bool) {
+	q := []rune(strings.ToLower(query))
+	t := []rune(strings.ToLower(target))
+	score, qi, prev := 0, 0, -2
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+		score++
+		if prev == ti-1 {
+			score += 5
+		}
+		if ti == 0 || strings.ContainsRune("/_-. ", t[ti-1]) {
+			score += 3
+		}
+		prev = ti
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	// 同等匹配下越短的路径越相关
+	return score*100 - len(t), true
+}
+
+// topMatches 按得分保留最好的 n 个结果, 得分相同时保留先遇到的. 堆顶为当前最差的结果
+type topMatches struct {
+	n     int
+	seq   int
+	items []scoredObj
+}
+
+type scoredObj struct {
+	obj   ObjResp
+	score int
+	// seq 遇到的顺序, 得分相同时按该顺序排列
+	seq int
+}
+
+func newTopMatches(n int) *topMatches {
+	return &topMatches{n: n}
+}
+
+func (t *topMatches) add(obj ObjResp, score int) {
+	item := scoredObj{obj: obj, score: score, seq: t.seq}
+	t.seq++
+	if len(t.items) < t.n {
+		heap.Push(t, item)
+	} else if worse(t.items[0], item) {
+		t.items[0] = item
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted 按得分从高到低返回保留的结果
+func (t *topMatches) sorted() []ObjResp {
+	sort.Slice(t.items, func(i, j int) bool { return worse(t.items[j], t.items[i]) })
+	objs := make([]ObjResp, len(t.items))
+	for i, item := range t.items {
+		objs[i] = item.obj
+	}
+	return objs
+}
+
+// worse 判断 a 是否比 b 排名靠后
+func worse(a, b scoredObj) bool {
+	if a.score != b.score {
+		return a.score < b.score
+	}
+	return a.seq > b.seq
+}
+
+func (t *topMatches) Len() int           { return len(t.items) }
+func (t *topMatches) Less(i, j int) bool { return worse(t.items[i], t.items[j]) }
+func (t *topMatches) Swap(i, j int)      { t.items[i], t.items[j] = t.items[j], t.items[i] }
+func (t *topMatches) Push(x any)         { t.items = append(t.items, x.(scoredObj)) }
+func (t *topMatches) Pop() any {
+	item := t.items[len(t.items)-1]
+	t.items = t.items[:len(t.items)-1]
+	return item
+}
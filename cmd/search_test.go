@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFuzzyScoreOrdering(t *testing.T) {
+	for _, tc := range []struct {
+		query  string
+		better string
+		worse  string
+	}{
+		// 匹配在路径分段开头的更好
+		{"cfgmain", "config/main.yaml", "xconfig/xmain.yaml"},
+		// 连续匹配更好
+		{"main", "src/main.go", "src/m_a_i_n.go"},
+		// 同等匹配下路径越短越好
+		{"readme", "readme.md", "docs/readme.md"},
+	} {
+		better, ok := fuzzyScore(tc.query, tc.better)
+		if !ok {
+			t.Fatalf("%q should match %q", tc.query, tc.better)
+		}
+		worse, ok := fuzzyScore(tc.query, tc.worse)
+		if !ok {
+			t.Fatalf("%q should match %q", tc.query, tc.worse)
+		}
+		if better <= worse {
+			t.Errorf("%q: score of %q (%d) should be higher than %q (%d)", tc.query, tc.better, better, tc.worse, worse)
+		}
+	}
+	if _, ok := fuzzyScore("cfgmain", "main/config.yaml"); ok {
+		t.Errorf("query characters out of order should not match")
+	}
+}
+
+func TestSearchFuzzy(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "xconfig/xmain.yaml", Body: "1"},
+			testEntry{Name: "docs/unrelated.txt", Body: "2"},
+			testEntry{Name: "config/main.yaml", Body: "3"},
+		),
+	})
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		query     string
+		maxScored int
+		want      []string
+	}{
+		{"cfgmain", 10000, []string{"config/main.yaml", "xconfig/xmain.yaml"}},
+		{"unrel", 10000, []string{"docs/unrelated.txt"}},
+		{"nomatch", 10000, []string{}},
+		// 只保留得分最高的结果, 即使它们出现在归档末尾
+		{"cfgmain", 1, []string{"config/main.yaml"}},
+	} {
+		conf.SearchMaxScored = tc.maxScored
+		resp := getData[ListResp](t, r, query("/search", "link", origin.link("/a.zip"), "query", tc.query, "fuzzy", "true"))
+		got := make([]string, 0, len(resp.Content))
+		for _, obj := range resp.Content {
+			got = append(got, obj.NameInArchive)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("fuzzy %q (max %d) = %v, want %v", tc.query, tc.maxScored, got, tc.want)
+		}
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "README.md", Body: "1"},
+			testEntry{Name: "docs/Readme.txt", Body: "2"},
+			testEntry{Name: "docs/notes.txt", Body: "3"},
+			testEntry{Name: "src/main.go", Body: "4"},
+			testEntry{Name: "src/readme.txt.bak", Body: "5"},
+		),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		path, query string
+		regex       bool
+		want        []string
+	}{
+		{"", "readme", false, []string{"README.md", "docs/Readme.txt", "src/readme.txt.bak"}},
+		{"/docs", "README", false, []string{"docs/Readme.txt"}},
+		{"", "*.txt", false, []string{"docs/Readme.txt", "docs/notes.txt"}},
+		{"", `^src/.*\.go$`, true, []string{"src/main.go"}},
+	} {
+		resp := getData[ListResp](t, r, query("/search", "link", link, "path", tc.path, "query", tc.query, "regex", strconv.FormatBool(tc.regex)))
+		got := names(resp.Content)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("path %q, query %q, regex %v = %v, want %v", tc.path, tc.query, tc.regex, got, tc.want)
+		}
+	}
+
+	resp := decodeResp[ListResp](t, get(t, r, query("/search", "link", link, "query", "(unclosed", "regex", "true")))
+	if resp.Code != 400 || !strings.HasPrefix(resp.Message, "invalid regex") {
+		t.Errorf("malformed regex: code %d, message %q", resp.Code, resp.Message)
+	}
+}
@@ -1,46 +1,334 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"math/rand"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
 	"os"
+	"os/signal"
 	stdpath "path"
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
-	bufra "github.com/avvmoto/buf-readerat"
 	"github.com/gin-gonic/gin"
 	stdArchiever "github.com/mholt/archiver/v4"
-	"github.com/snabb/httpreaderat"
+)
+
+type Config struct {
+	GzipLevel       int
+	SearchMaxScored int
+	BodyCacheDir    string
+	BodyCacheSize   int64
+	MaxBodyBytes    int64
+	MaxRanges       int
+	StreamThreshold int64
+	StreamPolicy    string
+	SignKey         string
+	TokenTTL        time.Duration
+	Referer         string
+	GzipFirstMember bool
+	MaxRedirects    int
+	BlockPrivate    bool
+	AllowCIDRs      cidrList
+	ForwardHeaders  []string
+	IndexCacheSize  int64
+	IndexCacheLen   int
+	IndexCacheTTL   time.Duration
+	ListCacheLen    int
+	ListCacheTTL    time.Duration
+	// ListPrefetchConcurrency 同时在后台预取 /list 下一页的最大数量
+	ListPrefetchConcurrency int
+	ListSpillDir            string
+	ListSpillTTL            time.Duration
+	DefaultPerPage          int
+	MaxPerPage              int
+	// ImplausibleSize 文件声明的大小不合理时的处理方式: reject 或 unknown
+	ImplausibleSize string
+	MaxBufferBytes  int64
+	// MaxDecompressedBytes 一次请求中累计解压的字节数上限
+	MaxDecompressedBytes int64
+	HashConcurrency      int
+	BufSize              int
+	MaxNestDepth         int
+	HashMaxBytes         int64
+	RequestTimeout       time.Duration
+	ShutdownTimeout      time.Duration
+	CORSOrigins          []string
+	AllowLocalFiles      bool
+	RetryAttempts        int
+	RetryBackoff         time.Duration
+	RetryJitter          time.Duration
+	MaxCacheFileBytes    int64
+	// AuthToken 不为空时除健康检查外的请求都需要提供
+	AuthToken                string
+	MaxConcurrentExtractions int
+	ExtractionPolicy         string
+	TreeMaxNodes             int
+	MaxPreviewBytes          int
+}
+
+var (
+	conf            Config
+	bodyCache       *BodyCache
+	indexCache      *IndexCache
+	listCache       *ListCache
+	listPrefetch    *ListPrefetcher
+	listSpill       *ListSpill
+	originTransport http.RoundTripper
 )
 
 func main() {
 	port := flag.Int("port", 8080, "port to listen on")
+	configPath := flag.String(configFlag, "", "YAML or JSON file of options keyed by flag name, overridden by RADS_* environment variables (e.g. RADS_MAX_PER_PAGE) and flags")
+	flag.IntVar(&conf.GzipLevel, "gzip-level", gzip.DefaultCompression, "default gzip level for tgz output (-2~9, 0 means default)")
+	flag.IntVar(&conf.SearchMaxScored, "search-max-scored", 10000, "max number of best scored matches kept by fuzzy search")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
+	flag.StringVar(&conf.BodyCacheDir, "body-cache-dir", "", "directory to cache extracted file bodies in, a private subdirectory is created under it, empty to disable")
+	flag.Int64Var(&conf.BodyCacheSize, "body-cache-size", 1<<30, "max total bytes of the body cache")
+
+	flag.IntVar(&conf.IndexCacheLen, "index-cache-entries", 128, "max number of remote archive indexes cached in memory, 0 to disable")
+	flag.Int64Var(&conf.IndexCacheSize, "index-cache-size", 64<<20, "max total bytes of the remote archive index cache")
+	flag.DurationVar(&conf.IndexCacheTTL, "index-cache-ttl", 5*time.Minute, "lifetime of a cached remote archive index")
+	flag.IntVar(&conf.ListCacheLen, "list-cache-entries", 64, "max number of /list pages prefetched for prefetch=true kept in memory, 0 to disable")
+	flag.DurationVar(&conf.ListCacheTTL, "list-cache-ttl", time.Minute, "lifetime of a prefetched /list page")
+	flag.IntVar(&conf.ListPrefetchConcurrency, "list-prefetch-concurrency", 4, "max number of /list next pages prefetched in the background at once, 0 to disable prefetching")
+
+	flag.StringVar(&conf.ListSpillDir, "list-spill-dir", "", "directory to spill /list spill=true results to, a private subdirectory is created under it, empty to disable")
+	flag.DurationVar(&conf.ListSpillTTL, "list-spill-ttl", 10*time.Minute, "lifetime of a spilled /list result")
+
+	flag.StringVar(&conf.ImplausibleSize, "implausible-size", "reject", "policy for entries declaring a negative or impossible size: reject (422) or unknown (download without Content-Length)")
+	flag.Int64Var(&conf.MaxBufferBytes, "max-buffer-bytes", 64<<20, "max size of an entry that must be buffered in memory, e.g. ZipCrypto encrypted, 0 for no limit")
+	flag.Int64Var(&conf.MaxDecompressedBytes, "max-decompressed-bytes", 0, "max total bytes decompressed for one request, larger content is aborted with 413, 0 for no limit")
+	flag.DurationVar(&conf.RequestTimeout, "request-timeout", 0, "deadline of a whole request including reading the origin and extracting, 504 when exceeded, 0 for no limit")
+	flag.DurationVar(&conf.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "grace period for active requests to finish on SIGINT/SIGTERM before they are closed")
+	flag.IntVar(&conf.BufSize, "buf-size", 1<<20, "size of the blocks read from the archive origin, can be overridden per request by buf_size")
+	flag.IntVar(&conf.MaxNestDepth, "max-nest-depth", 3, "max number of nested archives entered through !/ in a path, 0 to disable")
+	flag.IntVar(&conf.MaxConcurrentExtractions, "max-concurrent-extractions", 0, "max number of requests reading and extracting archives at the same time, /hash is limited by -hash-concurrency, 0 for no limit")
+	flag.StringVar(&conf.ExtractionPolicy, "extraction-policy", "queue", "what to do with requests over -max-concurrent-extractions: queue (wait for a free slot) or reject (503)")
+	flag.IntVar(&conf.HashConcurrency, "hash-concurrency", 2, "max number of concurrent /hash jobs")
+	flag.Int64Var(&conf.HashMaxBytes, "hash-max-bytes", 4<<30, "max total bytes read by one /hash job, 0 for no limit")
+	flag.IntVar(&conf.TreeMaxNodes, "tree-max-nodes", 10000, "max number of entries returned by /tree, deeper levels are cut first, 0 for no limit")
+	flag.IntVar(&conf.MaxPreviewBytes, "max-preview-bytes", 64<<10, "max bytes of file content returned by /preview")
+	flag.IntVar(&conf.DefaultPerPage, "default-per-page", 10, "page size used when per_page is not set")
+	flag.IntVar(&conf.MaxPerPage, "max-per-page", 1000, "max page size, larger per_page values are clamped")
+	flag.Int64Var(&conf.MaxBodyBytes, "max-body-bytes", 32<<20, "max size of an archive uploaded in the request body")
+	flag.IntVar(&conf.MaxRanges, "max-ranges", 16, "max number of ranges accepted in a Range header")
+	flag.Int64Var(&conf.StreamThreshold, "stream-threshold", 0, "entries larger than this many bytes are handled by -stream-policy, 0 to disable")
+	flag.StringVar(&conf.StreamPolicy, "stream-policy", "reject", "policy for entries over -stream-threshold: reject (413) or token (signed download url)")
+	flag.StringVar(&conf.SignKey, "sign-key", "", "key to sign download tokens, random if empty")
+	flag.DurationVar(&conf.TokenTTL, "token-ttl", 10*time.Minute, "lifetime of signed download tokens")
+	flag.BoolVar(&conf.GzipFirstMember, "gzip-first-member", false, "only decompress the first member of a multi-member .gz file instead of all members like gunzip")
+	flag.IntVar(&conf.RetryAttempts, "retry-attempts", 3, "max attempts of a request to the archive origin on network errors or 500/502/503/504, 1 to disable retries")
+	flag.DurationVar(&conf.RetryBackoff, "retry-backoff", 200*time.Millisecond, "wait before the first retry, doubled for each further retry")
+	flag.DurationVar(&conf.RetryJitter, "retry-jitter", 100*time.Millisecond, "max random time added to each retry wait")
+	flag.Int64Var(&conf.MaxCacheFileBytes, "max-cache-file-bytes", 0, "download archives from origins without range support to a temporary file of at most this many bytes, 0 to reject such origins")
+	flag.IntVar(&conf.MaxRedirects, "max-redirects", 10, "max number of redirects followed when fetching the archive")
+	flag.BoolVar(&conf.AllowLocalFiles, "allow-local-files", false, "allow link to be a file:// url or an absolute path on this machine")
+	flag.BoolVar(&conf.BlockPrivate, "block-private", true, "reject links resolving to private, loopback or link-local addresses")
+	flag.Var(&conf.AllowCIDRs, "allow-cidr", "cidr allowed even if -block-private is set, repeatable")
+	forwardHeaderNames := flag.String("forward-headers", "Cookie,User-Agent", "comma separated client headers forwarded to the archive origin, Authorization is always forwarded")
+	corsOrigins := flag.String("cors-origins", "", "comma separated origins allowed to call the api from browsers, * for any origin, empty to disable CORS")
+	corsMethods := flag.String("cors-methods", "GET, POST, HEAD", "methods allowed in CORS preflight requests")
+	corsHeaders := flag.String("cors-headers", "Content-Type, Authorization, X-API-Key, Range, If-Range, If-None-Match, If-Modified-Since", "request headers allowed in CORS preflight requests")
+	flag.StringVar(&conf.AuthToken, "auth-token", "", "require Authorization: Bearer <token> or X-API-Key: <token> on all routes except /healthz and /readyz, empty to disable")
+	rateLimit := flag.Float64("rate-limit", 0, "requests per second allowed for each client, excess requests get 429, 0 to disable")
+	rateBurst := flag.Int("rate-burst", 0, "requests a client may send at once before -rate-limit applies, 0 for the rate rounded up")
+	rateLimitKey := flag.String("rate-limit-key", "ip", "how clients are told apart for -rate-limit: ip, or api-key (the bearer token or X-API-Key once -auth-token accepted it, falling back to ip)")
+	enableMetrics := flag.Bool("metrics", false, "expose Prometheus metrics at /metrics")
+	logFormat := flag.String("log-format", "text", "format of the request and server logs: text or json")
+	flag.StringVar(&conf.Referer, "referer", "none", "referer sent to the archive origin: none, forward (client's referer), origin (archive's own origin) or a fixed url")
+
 	flag.Parse()
+	if err := loadConfig(flag.CommandLine, *configPath); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	logger, err := newLogger(*logFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// log 包的输出也按同样的格式记录
+	slog.SetDefault(logger)
+
+	if conf.StreamPolicy != "reject" && conf.StreamPolicy != "token" {
+		log.Fatalf("unknown stream policy %q", conf.StreamPolicy)
+	}
+	if conf.DefaultPerPage <= 0 || conf.MaxPerPage <= 0 {
+		log.Fatalf("-default-per-page and -max-per-page must be positive")
+	}
+	if conf.SearchMaxScored <= 0 {
+		log.Fatalf("-search-max-scored must be positive")
+	}
+	if conf.MaxPreviewBytes <= 0 {
+		log.Fatalf("-max-preview-bytes must be positive")
+	}
+	if conf.BufSize <= 0 || conf.BufSize > maxBufSize {
+		log.Fatalf("-buf-size must be between 1 and %d", maxBufSize)
+	}
+	if conf.HashConcurrency <= 0 {
+		log.Fatalf("-hash-concurrency must be positive")
+	}
+	hashSlots = make(chan struct{}, conf.HashConcurrency)
+	if !extractionPolicies[conf.ExtractionPolicy] {
+		log.Fatalf("unknown extraction policy %q", conf.ExtractionPolicy)
+	}
+	if conf.MaxConcurrentExtractions > 0 {
+		extractionSlots = make(chan struct{}, conf.MaxConcurrentExtractions)
+	}
+	if conf.DefaultPerPage > conf.MaxPerPage {
+		conf.DefaultPerPage = conf.MaxPerPage
+	}
+	if conf.ImplausibleSize != "reject" && conf.ImplausibleSize != "unknown" {
+		log.Fatalf("unknown implausible size policy %q", conf.ImplausibleSize)
+	}
+	if err := checkRefererPolicy(conf.Referer); err != nil {
+		log.Fatalf("invalid referer: %v", err)
+	}
+	if conf.ForwardHeaders, err = parseForwardHeaders(*forwardHeaderNames); err != nil {
+		log.Fatalf("invalid forward headers: %v", err)
+	}
+	if err := initSignKey(conf.SignKey); err != nil {
+		log.Fatalf("init sign key: %v", err)
+	}
+
+	if conf.RetryAttempts <= 0 {
+		log.Fatalf("-retry-attempts must be positive")
+	}
+	originTransport = newOriginTransport()
+	if conf.RetryAttempts > 1 {
+		originTransport = &retryTransport{RoundTripper: originTransport, attempts: conf.RetryAttempts, backoff: conf.RetryBackoff, jitter: conf.RetryJitter}
+	}
+
+	if conf.BodyCacheDir != "" {
+		if bodyCache, err = NewBodyCache(conf.BodyCacheDir, conf.BodyCacheSize); err != nil {
+			log.Fatalf("init body cache: %v", err)
+		}
+	}
+
+	if conf.IndexCacheLen > 0 {
+		indexCache = NewIndexCache(conf.IndexCacheLen, conf.IndexCacheSize, conf.IndexCacheTTL)
+	}
+	if conf.ListCacheLen > 0 {
+		listCache = NewListCache(conf.ListCacheLen, conf.ListCacheTTL)
+		if conf.ListPrefetchConcurrency > 0 {
+			listPrefetch = NewListPrefetcher(conf.ListPrefetchConcurrency, conf.RequestTimeout)
+		}
+	}
+	if conf.ListSpillDir != "" {
+		if listSpill, err = NewListSpill(conf.ListSpillDir, conf.ListSpillTTL); err != nil {
+			log.Fatalf("init list spill: %v", err)
+		}
+	}
+
+	r := gin.New()
+	r.Use(requestLog(logger), gin.Recovery())
+	if *enableMetrics {
+		metrics = NewMetrics()
+		r.Use(metrics.middleware())
+	}
+	// 处理函数把 gin.Context 作为 context 使用, 需要它返回请求的截止时间
+	r.ContextWithFallback = true
+	if conf.CORSOrigins = splitList(*corsOrigins); len(conf.CORSOrigins) > 0 {
+		r.Use(cors(conf.CORSOrigins, *corsMethods, *corsHeaders))
+	}
+	if conf.RequestTimeout > 0 {
+		r.Use(requestTimeout(conf.RequestTimeout))
+	}
+
+	// 健康检查不需要归档来源, 也不需要认证
+	r.GET("/healthz", Healthz)
+	r.HEAD("/healthz", Healthz)
+	r.GET("/readyz", Readyz)
+	r.HEAD("/readyz", Readyz)
+
+	api := r.Group("/")
+	var rateLimited gin.HandlerFunc
+	if *rateLimit > 0 {
+		rl, err := NewRateLimiter(*rateLimit, *rateBurst, *rateLimitKey)
+		if err != nil {
+			log.Fatalf("rate limit: %v", err)
+		}
+		rateLimited = rl.middleware()
+	}
+	// 按 API 密钥限速时需要先认证, 未认证的密钥不能作为区分客户端的键
+	if rateLimited != nil && *rateLimitKey == "ip" {
+		api.Use(rateLimited)
+	}
+	if conf.AuthToken != "" {
+		api.Use(authToken(conf.AuthToken))
+	}
+	if rateLimited != nil && *rateLimitKey != "ip" {
+		api.Use(rateLimited)
+	}
+	if metrics != nil {
+		api.GET("/metrics", metrics.handler)
+	}
+	api.Any("/hash", Hash)
 
-	r := gin.Default()
+	archives := api.Group("/")
+	if extractionSlots != nil {
+		archives.Use(limitExtractions(conf.ExtractionPolicy))
+	}
+	archiveRoutes(archives)
 
-	r.Any("/list", List)
-	r.Any("/get", Get)
-	r.Any("/down", Down)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", *port), Handler: r}
+	if err := serve(ctx, srv, conf.ShutdownTimeout); err != nil {
+		log.Fatalf("server: %v", err)
+	}
+	if listPrefetch != nil {
+		listPrefetch.Close()
+	}
+	if bodyCache != nil {
+		bodyCache.Close()
+	}
+	if listSpill != nil {
+		listSpill.Close()
+	}
+}
 
-	r.Run(fmt.Sprintf(":%d", *port))
+// archiveRoutes 注册读取归档的接口
+func archiveRoutes(archives gin.IRoutes) {
+	archives.Any("/list", List)
+	archives.Any("/tree", Tree)
+	archives.Any("/batch-get", BatchGet)
+	archives.Any("/preview", Preview)
+	archives.Any("/get", Get)
+	archives.Any("/down", Down)
+	archives.Any("/extract", Extract)
+	archives.Any("/zip", DownDir)
+	archives.Any("/search", Search)
+	archives.Any("/stat", Stat)
+	archives.Any("/info", Info)
+	archives.Any("/exists", Exists)
+	archives.Any("/checksum", Checksum)
 }
 
 var (
-	ErrNotSupport   = errors.New("not support")
 	ErrRelativePath = errors.New("access using relative path is not allowed")
 )
 
@@ -49,77 +337,395 @@ type PageReq struct {
 	PerPage int `json:"per_page" form:"per_page"`
 }
 
+// pageReq 使嵌入 PageReq 的请求都能取到分页参数
+func (p *PageReq) pageReq() *PageReq { return p }
+
+type pageRequest interface {
+	pageReq() *PageReq
+}
+
+func pageParamError(field string) error {
+	return fmt.Errorf("%s must be a non-negative integer", field)
+}
+
+// bindPageReq 绑定带分页参数的请求, 分页参数不合法时返回指明字段的错误
+func bindPageReq(c *gin.Context, req pageRequest) error {
+	for _, field := range []string{"page", "per_page"} {
+		for _, get := range []func(string) (string, bool){c.GetQuery, c.GetPostForm} {
+			if v, ok := get(field); ok {
+				if n, err := strconv.Atoi(v); err != nil || n < 0 {
+					return pageParamError(field)
+				}
+			}
+		}
+	}
+	if err := c.ShouldBind(req); err != nil {
+		var ute *json.UnmarshalTypeError
+		if errors.As(err, &ute) && (ute.Field == "page" || ute.Field == "per_page") {
+			return pageParamError(ute.Field)
+		}
+		return err
+	}
+	if p := req.pageReq(); p.Page < 0 {
+		return pageParamError("page")
+	} else if p.PerPage < 0 {
+		return pageParamError("per_page")
+	}
+	return nil
+}
+
+// SizeFilter 按大小过滤文件, 边界包含在内, 目录不受影响
+type SizeFilter struct {
+	MinSize *int64 `json:"min_size" form:"min_size"`
+	MaxSize *int64 `json:"max_size" form:"max_size"`
+}
+
+func (sf SizeFilter) check() error {
+	if sf.MinSize != nil && *sf.MinSize < 0 {
+		return errors.New("min_size must be a non-negative integer")
+	}
+	if sf.MaxSize != nil && *sf.MaxSize < 0 {
+		return errors.New("max_size must be a non-negative integer")
+	}
+	if sf.MinSize != nil && sf.MaxSize != nil && *sf.MinSize > *sf.MaxSize {
+		return errors.New("min_size must not be greater than max_size")
+	}
+	return nil
+}
+
+// match 判断文件是否在大小范围内, 设置了范围时大小未知的文件不匹配
+func (sf SizeFilter) match(obj ObjResp) bool {
+	if obj.IsDir || (sf.MinSize == nil && sf.MaxSize == nil) {
+		return true
+	}
+	if obj.Size < 0 {
+		return false
+	}
+	return (sf.MinSize == nil || obj.Size >= *sf.MinSize) && (sf.MaxSize == nil || obj.Size <= *sf.MaxSize)
+}
+
 type ListReq struct {
 	PageReq
-	RawLink string `json:"link"    form:"link"    binding:"required"`
-	Path    string `json:"path"    form:"path"`
-	Cascade bool   `json:"cascade" form:"cascade"`
+	ArchiveReq
+	SizeFilter
+	Path      string `json:"path"    form:"path"`
+	Cascade   bool   `json:"cascade" form:"cascade"`
+	Group     string `json:"group"   form:"group"`
+	Shuffle   *int64 `json:"shuffle" form:"shuffle"`
+	Sniff     bool   `json:"sniff"   form:"sniff"`
+	Glob      string `json:"glob"    form:"glob"`
+	Sort      string `json:"sort"       form:"sort"`
+	Order     string `json:"order"      form:"order"`
+	DirsFirst bool   `json:"dirs_first" form:"dirs_first"`
+	// Prefetch 为 true 时缓存分页前的完整结果, 之后的翻页请求直接从缓存返回
+	Prefetch bool `json:"prefetch" form:"prefetch"`
+	// Spill 为 true 时将 cascade 的结果写入磁盘, 翻页时从磁盘读取, 用于文件数很多的归档
+	Spill bool `json:"spill" form:"spill"`
+	// WithSize 为 true 时返回目录下所有文件 (递归) 的大小之和
+	WithSize bool `json:"with_size" form:"with_size"`
+	// Depth cascade 时只返回 path 下不超过 depth 层的条目, 0 只返回目录本身, 1 与非 cascade 相同
+	Depth *int `json:"depth" form:"depth"`
+	// Type 为 file 时只返回文件, 为 dir 时只返回目录, 为空时都返回
+	Type string `json:"type" form:"type"`
+	// Ext 逗号分隔的扩展名, 例如 jpg,png, 只返回这些扩展名的文件 (不区分大小写), 目录总是保留
+	Ext string `json:"ext" form:"ext"`
+	// Cursor 不为 nil 时按游标分页, 第一页传空字符串, 之后传上一页返回的 next_cursor
+	Cursor *string `json:"cursor" form:"cursor"`
+	// Stream 为 true 时以 NDJSON 流式返回, 与 Accept: application/x-ndjson 相同
+	Stream bool `json:"stream" form:"stream"`
+}
+
+// parseExts 解析 ext 参数, 返回小写的 .jpg 形式
+func parseExts(ext string) []string {
+	var exts []string
+	for _, e := range splitList(ext) {
+		exts = append(exts, "."+strings.ToLower(strings.TrimPrefix(e, ".")))
+	}
+	return exts
+}
+
+// matchExts 判断文件名是否以 exts 之一结尾, exts 为空或条目为目录时总是符合
+func matchExts(obj ObjResp, exts []string) bool {
+	if len(exts) == 0 || obj.IsDir {
+		return true
+	}
+	name := strings.ToLower(obj.Name)
+	for _, ext := range exts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchType 判断条目是否符合 type 参数
+func matchType(obj ObjResp, typ string) bool {
+	switch typ {
+	case "file":
+		return !obj.IsDir
+	case "dir":
+		return obj.IsDir
+	}
+	return true
 }
 
 type ObjResp struct {
-	Name          string    `json:"name"`
-	Size          int64     `json:"size"`
-	IsDir         bool      `json:"is_dir"`
-	Modified      time.Time `json:"modified"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	IsDir    bool      `json:"is_dir"`
+	Modified time.Time `json:"modified"`
+	// Created 只有归档记录了创建时间时才有值 (7z, 带 NTFS 扩展字段的 zip), 否则为零值且 CreatedKnown 为 false
 	Created       time.Time `json:"created"`
+	CreatedKnown  bool      `json:"created_known"`
 	NameInArchive string    `json:"name_in_archive"`
 	LinkTarget    string    `json:"link_target"`
+	// Mode 八进制的权限位, 例如 0755, 没有记录权限的归档由格式决定默认值
+	Mode         string `json:"mode"`
+	IsSymlink    bool   `json:"is_symlink"`
+	Seekable     bool   `json:"seekable"`
+	NameEncoding string `json:"name_encoding"`
+	Category     string `json:"category,omitempty"`
+	LinkGroup    string `json:"link_group,omitempty"`
+	RelPath      string `json:"rel_path,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
 }
 
 type ListResp struct {
+	ArchiveMeta
 	Content []ObjResp `json:"content"`
 	Total   int64     `json:"total"`
+	// TotalSize 本页文件的大小之和
+	TotalSize int64          `json:"total_size"`
+	DirSize   *int64         `json:"dir_size,omitempty"`
+	Groups    map[string]int `json:"groups,omitempty"`
+	// NextCursor 按游标分页时下一页的游标, 已经是最后一页时为空
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 func List(c *gin.Context) {
 	// 非zip, 7zip, 无法流式解压, 限制大文件
 	var req ListReq
-	if err := c.ShouldBind(&req); err != nil {
+	if err := bindPageReq(c, &req); err != nil {
 		ErrorStrResp(c, err.Error(), 400)
 		return
 	}
+	if req.Group != "" && req.Group != "type" {
+		ErrorStrResp(c, fmt.Sprintf("unknown group %q, support type", req.Group), 400)
+		return
+	}
+	if _, err := stdpath.Match(req.Glob, ""); err != nil {
+		ErrorStrResp(c, fmt.Sprintf("invalid glob %q", req.Glob), 400)
+		return
+	}
+	if err := checkSort(req.Sort, req.Order); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	if err := req.SizeFilter.check(); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	if req.Sort != "" && req.Shuffle != nil {
+		ErrorStrResp(c, "sort and shuffle can not be used together", 400)
+		return
+	}
+	if req.Depth != nil && *req.Depth < 0 {
+		ErrorStrResp(c, "depth must be a non-negative integer", 400)
+		return
+	}
+	if req.Type != "" && req.Type != "file" && req.Type != "dir" {
+		ErrorStrResp(c, fmt.Sprintf("unknown type %q, support file, dir", req.Type), 400)
+		return
+	}
 
-	reqPath, err := handerReqPath(req.Path, true)
+	reqPath, err := handerReqPath(req.Path, true, req.PathEncoded)
 	if err != nil {
-		ErrorStrResp(c, err.Error(), 500)
+		ErrorStrResp(c, err.Error(), 400)
 		return
 	}
 
-	arc, err := getArchive(c, req.RawLink)
-	if err != nil {
-		ErrorStrResp(c, err.Error(), 500)
+	if wantsStream(c, &req) {
+		listStream(c, &req, reqPath)
+		return
+	}
+	if req.Cursor != nil {
+		listByCursor(c, &req, reqPath)
+		return
+	}
+	if req.Spill {
+		listSpilled(c, &req, reqPath)
 		return
 	}
 
+	// 只缓存远程归档, 上传的归档每次都不同
+	prefetch := listCache != nil && req.Prefetch && req.RawLink != "" && checkSource(c, &req.ArchiveReq) == nil
+	if prefetch {
+		resp, ok := listCache.Get(listPageKey(c, &req))
+		metrics.cacheLookup("list", ok)
+		if ok {
+			SuccessResp(c, resp)
+			prefetchNextPage(c, &req, reqPath, resp)
+			return
+		}
+		// 该页的预取还没有完成, 由这个请求自己读取
+		if listPrefetch != nil {
+			listPrefetch.Cancel(ListCacheKey(c, req))
+		}
+	}
+
+	resp, opened, err := listPage(c, &req, reqPath)
+	if err != nil && !opened {
+		ArchiveErrorResp(c, err)
+		return
+	} else if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+	SuccessResp(c, resp)
+	if prefetch {
+		prefetchNextPage(c, &req, reqPath, resp)
+	}
+}
+
+// listPage 读取归档并返回 /list 请求的一页. 打开归档失败时 opened 为 false
+func listPage(c *gin.Context, req *ListReq, reqPath string) (resp ListResp, opened bool, err error) {
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		return ListResp{}, false, err
+	}
+
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		return ListResp{}, true, err
+	}
+
+	arc.SetSniff(req.Sniff)
 	dirFunc := arc.ExtractDirs
-	if req.Cascade {
+	if req.Cascade && req.Depth == nil {
 		dirFunc = arc.CascadeExtractDirs
+	} else if req.Cascade && *req.Depth != 1 {
+		dirFunc = func(ctx context.Context, dir string) ([]stdArchiever.File, error) {
+			return arc.CascadeExtractDirsDepth(ctx, dir, *req.Depth)
+		}
+	}
+	if req.Glob != "" {
+		dirFunc = func(ctx context.Context, dir string) ([]stdArchiever.File, error) {
+			return arc.ExtractGlob(ctx, dir, req.Glob)
+		}
 	}
 	dFiles, err := dirFunc(c, reqPath)
 	if err != nil {
-		ErrorStrResp(c, ErrNotSupport.Error(), 500)
-		return
+		return ListResp{}, true, err
 	}
 
+	var size *int64
+	if req.WithSize {
+		var n int64
+		if req.Cascade && req.Depth == nil && req.Glob == "" {
+			// cascade 的结果已经包含所有文件, 不需要再遍历
+			for _, f := range dFiles {
+				if !f.IsDir() && f.Size() > 0 {
+					n += f.Size()
+				}
+			}
+		} else if n, err = dirSize(c.Request.Context(), arc, reqPath); err != nil {
+			return ListResp{}, true, err
+		}
+		size = &n
+	}
+
+	exts := parseExts(req.Ext)
 	objs := make([]ObjResp, 0, len(dFiles))
 	for _, f := range dFiles {
-		objs = append(objs, buildObj(&f))
+		if obj := listObj(arc, &f, reqPath); req.SizeFilter.match(obj) && matchType(obj, req.Type) && matchExts(obj, exts) {
+			objs = append(objs, obj)
+		}
+	}
+	if req.Shuffle != nil {
+		shuffleObjs(objs, *req.Shuffle)
+	}
+	sortObjs(objs, req.Sort, req.Order, req.DirsFirst)
+	var groups map[string]int
+	if req.Group == "type" {
+		groups = groupByCategory(objs)
 	}
 	total, objs := pagination(objs, &req.PageReq)
 
-	SuccessResp(c, ListResp{
-		Content: objs,
-		Total:   int64(total),
+	return ListResp{
+		ArchiveMeta: arc.Meta(),
+		Content:     objs,
+		Total:       int64(total),
+		TotalSize:   sumSize(objs),
+		DirSize:     size,
+		Groups:      groups,
+	}, true, nil
+}
+
+// sumSize 返回文件大小之和, 大小未知的文件不计入
+func sumSize(objs []ObjResp) int64 {
+	var n int64
+	for _, obj := range objs {
+		if !obj.IsDir && obj.Size > 0 {
+			n += obj.Size
+		}
+	}
+	return n
+}
+
+// dirSize 递归统计目录下所有文件的大小之和, ctx 取消时停止遍历
+func dirSize(ctx context.Context, arc *Archive, dir string) (int64, error) {
+	var n int64
+	err := arc.CascadeWalkDirs(ctx, dir, func(ctx context.Context, f stdArchiever.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !f.IsDir() && f.Size() > 0 {
+			n += f.Size()
+		}
+		return nil
 	})
+	return n, err
+}
+
+// listObj 构造 /list 返回的文件信息
+func listObj(arc *Archive, f *stdArchiever.File, reqPath string) ObjResp {
+	obj := buildObj(arc, f)
+	// 相对于请求目录的路径, 根目录时即为完整路径
+	obj.RelPath = arc.RelPath("/"+f.NameInArchive, reqPath)
+	if sniffed, ok := arc.SniffedContentType(*f); ok {
+		obj.ContentType = preferSniffed(mimeByName(f.Name()), sniffed)
+	}
+	return obj
 }
 
 type GetReq struct {
-	RawLink string `json:"link" form:"link" binding:"required"`
-	Path    string `json:"path" form:"path"`
+	ArchiveReq
+	Path string `json:"path" form:"path"`
+	// AutoNest 为 true 时路径不存在且其中某一级是 tar 文件时, 进入该 tar 查找剩余路径
+	AutoNest bool `json:"auto_nest" form:"auto_nest"`
+}
+
+// extractFile 按 auto_nest 参数提取文件
+func extractFile(c *gin.Context, arc *Archive, req *GetReq, reqPath string) (*stdArchiever.File, error) {
+	if req.AutoNest {
+		return arc.ExtractAutoNested(c, reqPath)
+	}
+	return arc.ExtractFile(c, reqPath)
+}
+
+type DownReq struct {
+	GetReq
+	Token   string `json:"token"   form:"token"`
+	Expires int64  `json:"expires" form:"expires"`
+	Sniff   bool   `json:"sniff"   form:"sniff"`
+	// Disposition 为 inline 时浏览器可以直接显示图片, PDF 等文件, 默认 attachment 下载
+	Disposition string `json:"disposition" form:"disposition"`
 }
 
 type GetResp struct {
 	ObjResp
+	// Format 文件所在归档的格式
+	Format string `json:"format"`
 }
 
 func Get(c *gin.Context) {
@@ -130,77 +736,331 @@ func Get(c *gin.Context) {
 		return
 	}
 
-	reqPath, err := handerReqPath(req.Path, false)
+	reqPath, err := handerReqPath(req.Path, false, req.PathEncoded)
 	if err != nil {
-		ErrorStrResp(c, err.Error(), 500)
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
 		return
 	}
 
-	arc, err := getArchive(c, req.RawLink)
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
 	if err != nil {
-		ErrorStrResp(c, err.Error(), 500)
+		ExtractErrorResp(c, err)
 		return
 	}
 
-	dFile, err := arc.ExtractFile(c, reqPath)
+	dFile, err := extractFile(c, arc, &req, reqPath)
 	if err != nil {
-		ErrorStrResp(c, ErrNotSupport.Error(), 500)
+		ExtractErrorResp(c, err)
 		return
 	}
 
-	SuccessResp(c, GetResp{ObjResp: buildObj(dFile)})
+	SuccessResp(c, GetResp{ObjResp: buildObj(arc, dFile), Format: arc.FormatName()})
 }
 
 func Down(c *gin.Context) {
 	// 非zip, 7zip, 无法流式解压, 限制大文件
-	var req GetReq
+	var req DownReq
+	if err := c.ShouldBind(&req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	switch req.Disposition {
+	case "":
+		req.Disposition = "attachment"
+	case "attachment", "inline":
+	default:
+		ErrorStrResp(c, fmt.Sprintf("unknown disposition %q, support attachment, inline", req.Disposition), 400)
+		return
+	}
+
+	reqPath, err := handerReqPath(req.Path, false, req.PathEncoded)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	fingerprint, cacheKey, f, ok := cachedEntry(c, &req.ArchiveReq, arc, reqPath)
+	if ok {
+		if checkStreamThreshold(c, &req, f) {
+			SuccessStreamResp(c, f, req.Sniff, entryETag(fingerprint, f), req.Disposition)
+		}
+		return
+	}
+
+	dFile, err := extractFile(c, arc, &req.GetReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+	if !checkStreamThreshold(c, &req, *dFile) {
+		return
+	}
+
+	// 只有完整下载时才能写入缓存, HEAD 请求不读取内容
+	if cacheKey != "" && c.GetHeader("Range") == "" && c.Request.Method != http.MethodHead && !archiver.IsEncrypted(*dFile) {
+		*dFile = bodyCache.Tee(cacheKey, *dFile)
+	}
+	SuccessStreamResp(c, *dFile, req.Sniff, entryETag(fingerprint, *dFile), req.Disposition)
+}
+
+// cachedEntry 计算归档指纹和缓存键, 并查找磁盘缓存中的文件内容.
+// 指纹用于缓存键和 ETag, 无法计算时两者都不使用; cacheKey 为空时不使用缓存
+func cachedEntry(c *gin.Context, req *ArchiveReq, arc *Archive, reqPath string) (fingerprint, cacheKey string, f stdArchiever.File, ok bool) {
+	fingerprint, err := arc.Fingerprint()
+	if err != nil {
+		_ = c.Error(err)
+	}
+	// 带密码的请求不使用缓存, 否则解密后的内容会返回给没有密码的请求
+	if bodyCache == nil || fingerprint == "" || req.Password != "" {
+		return fingerprint, "", f, false
+	}
+	cacheKey = BodyCacheKey(req.RawLink, bodyCachePath(req, reqPath), fingerprint)
+	f, file, ok := bodyCache.Get(cacheKey)
+	metrics.cacheLookup("body", ok)
+	if ok {
+		// 缓存的文件在请求结束后关闭
+		context.AfterFunc(c.Request.Context(), func() { file.Close() })
+	}
+	return fingerprint, cacheKey, f, ok
+}
+
+// bodyCachePath 返回缓存键中的文件路径, 包含会改变返回内容的参数
+func bodyCachePath(req *ArchiveReq, reqPath string) string {
+	if req.CaseInsensitive {
+		// 与区分大小写的请求分开缓存, 否则 /A.txt 可能命中 /a.txt 的缓存
+		reqPath = "i:" + strings.ToLower(reqPath)
+	}
+	return fmt.Sprintf("%s\x00%s\x00%t", reqPath, strings.ToLower(req.Encoding), req.ResolveSymlinks)
+}
+
+// entryETag 由归档指纹, 文件路径, 大小和修改时间计算文件的 ETag, 没有指纹时返回空
+func entryETag(fingerprint string, f stdArchiever.File) string {
+	if fingerprint == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d", fingerprint, f.NameInArchive, f.Size(), f.ModTime().UnixNano())))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified 按 If-None-Match 和 If-Modified-Since 判断客户端缓存是否仍然有效, 存在 If-None-Match 时忽略 If-Modified-Since
+func notModified(c *gin.Context, etag string, modTime time.Time) bool {
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+	if err != nil || modTime.IsZero() {
+		return false
+	}
+	// HTTP 时间只精确到秒
+	return !modTime.Truncate(time.Second).After(ims)
+}
+
+// ifRangeMatches 判断 If-Range 中的 ETag 或时间是否与文件匹配, 没有 If-Range 时视为匹配.
+// ETag 按强比较, 弱 ETag 总是不匹配; 时间需要与修改时间完全相同
+func ifRangeMatches(c *gin.Context, etag string, modTime time.Time) bool {
+	ifRange := c.GetHeader("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etag != "" && ifRange == etag
+	}
+	t, err := http.ParseTime(ifRange)
+	if err != nil || modTime.IsZero() {
+		return false
+	}
+	return modTime.Truncate(time.Second).Equal(t)
+}
+
+// checkStreamThreshold 检查文件是否超过流式下载的大小阈值, 超过时按配置的策略响应并返回 false
+func checkStreamThreshold(c *gin.Context, req *DownReq, f stdArchiever.File) bool {
+	if conf.StreamThreshold <= 0 || f.Size() <= conf.StreamThreshold ||
+		verifyDown(req.RawLink, req.Path, req.Token, req.Expires) {
+		return true
+	}
+	if conf.StreamPolicy == "token" {
+		SuccessResp(c, newDownToken(req.RawLink, req.Path))
+		return false
+	}
+	ErrorStrResp(c, fmt.Sprintf("file size exceeds the streaming threshold of %d bytes", conf.StreamThreshold),
+		http.StatusRequestEntityTooLarge)
+	return false
+}
+
+type ExtractReq struct {
+	ArchiveReq
+	Path   string `json:"path"   form:"path"`
+	Format string `json:"format" form:"format"`
+	Level  *int   `json:"level"  form:"level"`
+}
+
+var extractFormats = map[string]struct {
+	Ext  string
+	MIME string
+}{
+	"tar": {Ext: ".tar", MIME: "application/x-tar"},
+	"zip": {Ext: ".zip", MIME: "application/zip"},
+	"tgz": {Ext: ".tar.gz", MIME: "application/gzip"},
+}
+
+func Extract(c *gin.Context) {
+	var req ExtractReq
 	if err := c.ShouldBind(&req); err != nil {
 		ErrorStrResp(c, err.Error(), 400)
 		return
 	}
+	if req.Format == "" {
+		req.Format = "tar"
+	}
+	if _, ok := extractFormats[req.Format]; !ok {
+		ErrorStrResp(c, fmt.Sprintf("unknown format %q, support tar, zip, tgz", req.Format), 400)
+		return
+	}
+	level := conf.GzipLevel
+	if req.Level != nil {
+		level = *req.Level
+	}
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		ErrorStrResp(c, fmt.Sprintf("level must be between %d and %d", gzip.HuffmanOnly, gzip.BestCompression), 400)
+		return
+	}
 
-	reqPath, err := handerReqPath(req.Path, false)
+	reqPath, err := handerReqPath(req.Path, true, req.PathEncoded)
 	if err != nil {
-		ErrorStrResp(c, err.Error(), 500)
+		ErrorStrResp(c, err.Error(), 400)
 		return
 	}
 
-	arc, err := getArchive(c, req.RawLink)
+	arc, err := getArchive(c, &req.ArchiveReq)
 	if err != nil {
-		ErrorStrResp(c, err.Error(), 500)
+		ArchiveErrorResp(c, err)
 		return
 	}
 
-	dFile, err := arc.ExtractFile(c, reqPath)
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
 	if err != nil {
-		ErrorStrResp(c, ErrNotSupport.Error(), 500)
+		ExtractErrorResp(c, err)
 		return
 	}
 
-	SuccessStreamResp(c, *dFile)
+	var format stdArchiever.ArchiverAsync
+	switch req.Format {
+	case "tar":
+		format = stdArchiever.Tar{}
+	case "zip":
+		format = stdArchiever.Zip{}
+	case "tgz":
+		format = stdArchiever.CompressedArchive{
+			Compression: stdArchiever.Gz{CompressionLevel: level},
+			Archival:    stdArchiever.Tar{},
+		}
+	}
+
+	writeArchive(c, arc, reqPath, format, req.Format)
 }
 
-func getArchive(c *gin.Context, rawURL string) (*archiver.ArchiverExtractor, error) {
-	httpReaderAtReq, _ := http.NewRequest(http.MethodGet, rawURL, nil)
-	httpReaderAtReq.Header.Set("Cookie", c.GetHeader("Cookie"))
-	httpReaderAtReq.Header.Set("User-Agent", c.GetHeader("User-Agent"))
-	htrdr, err := httpreaderat.New(nil, httpReaderAtReq, nil)
+// writeArchive 将目录重新打包后流式写入响应
+func writeArchive(c *gin.Context, arc *Archive, reqPath string, format stdArchiever.ArchiverAsync, formatName string) {
+	ef := extractFormats[formatName]
+	name := "archive"
+	if reqPath != "/" {
+		name = stdpath.Base(reqPath)
+	}
+	// 输出大小未知, 不设置 Content-Length, 使用 chunked 编码
+	c.Writer.Header().Set("Content-Type", ef.MIME)
+	c.Writer.Header().Set("Content-Disposition", contentDisposition("attachment", name+ef.Ext))
+	// 写入完成后通过 trailer 告知已打包的文件数和字节数
+	c.Writer.Header().Set("Trailer", "X-Members-Written, X-Bytes-Written")
+	stats, err := arc.ArchiveDirs(c, reqPath, format, c.Writer)
+	if err != nil && !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Disposition")
+		c.Writer.Header().Del("Trailer")
+		ExtractErrorResp(c, err)
+		return
+	}
 	if err != nil {
-		return nil, err
+		_ = c.Error(err)
 	}
-	bhtrdr := bufra.NewBufReaderAt(htrdr, 1024*1024)
-	return archiver.DetectArchive(rawURL, io.NewSectionReader(bhtrdr, 0, htrdr.Size()))
+	c.Writer.Header().Set("X-Members-Written", strconv.Itoa(stats.Members))
+	c.Writer.Header().Set("X-Bytes-Written", strconv.FormatInt(stats.Bytes, 10))
 }
 
-func buildObj(f *stdArchiever.File) ObjResp {
+type DownDirReq struct {
+	ArchiveReq
+	Path string `json:"path" form:"path"`
+}
+
+// DownDir 将目录打包为 zip 下载, 与 format=zip 的 /extract 相同
+func DownDir(c *gin.Context) {
+	var req DownDirReq
+	if err := c.ShouldBind(&req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	reqPath, err := handerReqPath(req.Path, true, req.PathEncoded)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+	writeArchive(c, arc, reqPath, stdArchiever.Zip{}, "zip")
+}
+
+func buildObj(arc *Archive, f *stdArchiever.File) ObjResp {
+	created, createdKnown := archiver.Created(*f)
 	return ObjResp{
 		Name:          f.Name(),
 		Size:          f.Size(),
 		IsDir:         f.IsDir(),
-		Created:       f.ModTime(),
+		Created:       created,
+		CreatedKnown:  createdKnown,
 		Modified:      f.ModTime(),
 		NameInArchive: f.NameInArchive,
 		LinkTarget:    f.LinkTarget,
+		Mode:          fmt.Sprintf("%04o", f.Mode().Perm()),
+		IsSymlink:     f.Mode()&fs.ModeSymlink != 0,
+		Seekable:      archiver.Seekable(*f),
+		NameEncoding:  arc.NameEncoding(*f),
+		LinkGroup:     arc.LinkGroup(*f),
 	}
 }
 
@@ -237,38 +1097,62 @@ func FixAndCleanPath(path string) string {
 	return stdpath.Clean(path)
 }
 
-func handerReqPath(path string, isDir bool) (string, error) {
+// handerReqPath 校验并规范化请求的归档内路径, 返回以 / 开头的路径, isDir 为 true 时以 / 结尾.
+// path 已经由参数绑定解码, encoded 为 true 时再解码一次百分号编码 (例如 /my%20folder/a%2Bb.txt),
+// 不是有效的编码时 (例如 100%.txt) 按原样使用. 反斜杠视为分隔符, 含有 .. 分段时返回 ErrRelativePath,
+// 不解码时也拒绝解码后才出现的 .. (例如 %2e%2e%2f)
+func handerReqPath(path string, isDir, encoded bool) (string, error) {
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		if _, err := JoinBasePath("", strings.ReplaceAll(unescaped, "\\", "/")); err != nil {
+			return "", err
+		}
+		if encoded {
+			path = unescaped
+		}
+	}
+	path = strings.ReplaceAll(path, "\\", "/")
+	// 使用校验后清理过的路径, 而不是原始路径
 	reqPath, err := JoinBasePath("", path)
 	if err != nil {
 		return "", err
 	}
-	if path != "/" {
-		reqPath = stdpath.Join(path + "/")
-	}
 	if isDir && reqPath != "/" {
 		reqPath += "/"
 	}
 	return reqPath, nil
 }
 
+// shuffleObjs 按种子确定性地打乱顺序, 相同的种子得到相同的顺序
+func shuffleObjs(objs []ObjResp, seed int64) {
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(objs), func(i, j int) { objs[i], objs[j] = objs[j], objs[i] })
+}
+
 func pagination[T any](objs []T, req *PageReq) (int, []T) {
+	total := len(objs)
+	start, end := pageBounds(total, req)
+	return total, objs[start:end]
+}
+
+// pageBounds 返回请求的页在 total 个条目中的范围 [start, end), 超出最后一页时 start == end
+func pageBounds(total int, req *PageReq) (int, int) {
 	pageIndex, pageSize := req.Page, req.PerPage
 	if pageIndex <= 0 {
 		pageIndex = 1
 	}
 	if pageSize <= 0 {
-		pageSize = 10
+		pageSize = conf.DefaultPerPage
 	}
-	total := len(objs)
-	start := (pageIndex - 1) * pageSize
-	if start > total {
-		return total, []T{}
+	// 限制单页大小, total 仍为实际的总数
+	if pageSize > conf.MaxPerPage {
+		pageSize = conf.MaxPerPage
 	}
-	end := start + pageSize
-	if end > total {
-		end = total
+	// 超出最后一页时统一返回空页; 先比较页码, 避免很大的页码相乘后溢出
+	if pageIndex-1 >= (total+pageSize-1)/pageSize {
+		return total, total
 	}
-	return total, objs[start:end]
+	start := (pageIndex - 1) * pageSize
+	return start, min(start+pageSize, total)
 }
 
 func ErrorStrResp(c *gin.Context, msg string, code int) {
@@ -280,6 +1164,68 @@ func ErrorStrResp(c *gin.Context, msg string, code int) {
 	c.Abort()
 }
 
+// typedErrors 可以归类的错误, 对应的错误码和 error_type
+var typedErrors = []struct {
+	err     error
+	code    int
+	errType string
+}{
+	{archiver.ErrUnsupportedFormat, http.StatusUnsupportedMediaType, "unsupported_format"},
+	{archiver.ErrUpstreamUnreachable, http.StatusBadGateway, "upstream_unreachable"},
+	{archiver.ErrFileNotFound, http.StatusNotFound, "file_not_found"},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, "timeout"},
+	{archiver.ErrSymlinkEscape, http.StatusForbidden, "symlink_escape"},
+	{archiver.ErrSymlinkLoop, http.StatusUnprocessableEntity, "symlink_loop"},
+	{ErrIntegrity, http.StatusBadGateway, "integrity_mismatch"},
+}
+
+// typedErrorResp 错误属于 typedErrors 时返回对应的错误码和 error_type
+func typedErrorResp(c *gin.Context, err error) bool {
+	for _, te := range typedErrors {
+		if errors.Is(err, te.err) {
+			c.JSON(200, Resp[interface{}]{Code: te.code, Message: err.Error(), ErrorType: te.errType})
+			c.Abort()
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractErrorResp 根据提取归档时的错误类型返回对应的错误码
+func ExtractErrorResp(c *gin.Context, err error) {
+	if rateLimitedResp(c, err) {
+		return
+	}
+	code, errType := extractErrorCode(err)
+	msg := err.Error()
+	var te *archiver.TruncatedError
+	if errors.As(err, &te) {
+		msg = te.Error()
+	}
+	c.JSON(200, Resp[interface{}]{Code: code, Message: msg, ErrorType: errType})
+	c.Abort()
+}
+
+// extractErrorCode 提取归档时的错误对应的错误码和 error_type, 不属于 typedErrors 时 error_type 为空
+func extractErrorCode(err error) (int, string) {
+	for _, te := range typedErrors {
+		if errors.Is(err, te.err) {
+			return te.code, te.errType
+		}
+	}
+	switch {
+	case errors.As(err, new(*archiver.TruncatedError)), errors.Is(err, archiver.ErrImplausibleSize):
+		return http.StatusUnprocessableEntity, ""
+	case errors.Is(err, archiver.ErrEncrypted):
+		return http.StatusForbidden, ""
+	case errors.Is(err, archiver.ErrBufferLimit), errors.Is(err, archiver.ErrDecompressedLimit):
+		return http.StatusRequestEntityTooLarge, ""
+	case errors.Is(err, ErrNestTooDeep), errors.As(err, new(*NotArchiveError)):
+		return http.StatusBadRequest, ""
+	}
+	return http.StatusInternalServerError, ""
+}
+
 func SuccessResp(c *gin.Context, data ...interface{}) {
 	if len(data) == 0 {
 		c.JSON(200, Resp[interface{}]{
@@ -299,80 +1245,98 @@ func SuccessResp(c *gin.Context, data ...interface{}) {
 type Resp[T any] struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
-	Data    T      `json:"data"`
+	// ErrorType 稳定的错误类别, 只有可以归类的错误返回, 见 typedErrors
+	ErrorType string `json:"error_type,omitempty"`
+	Data      T      `json:"data"`
 }
 
-func SuccessStreamResp(c *gin.Context, f stdArchiever.File) {
-	frc, err := f.Open()
-	if err != nil {
-		ErrorStrResp(c, ErrNotSupport.Error(), 500)
+// SuccessStreamResp 返回文件内容, sniff 为 true 或扩展名未知时根据文件开头的内容识别 Content-Type
+func SuccessStreamResp(c *gin.Context, f stdArchiever.File, sniff bool, etag, disposition string) {
+	if etag != "" {
+		c.Writer.Header().Set("ETag", etag)
+	}
+	if !f.ModTime().IsZero() {
+		c.Writer.Header().Set("Last-Modified", f.ModTime().UTC().Format(http.TimeFormat))
+	}
+	if notModified(c, etag, f.ModTime()) {
+		c.Status(http.StatusNotModified)
 		return
 	}
-	defer frc.Close()
 
-	defaultMIME := "application/octet-stream"
-	switch {
-	case strings.HasSuffix(f.Name(), ".zip"):
-		defaultMIME = "application/zip"
-	case strings.HasSuffix(f.Name(), ".7z"):
-		defaultMIME = "application/x-7z-compressed"
-	case strings.HasSuffix(f.Name(), ".rar"):
-		defaultMIME = "application/x-rar-compressed"
-	case strings.HasSuffix(f.Name(), ".tar"):
-		defaultMIME = "application/x-tar"
-	case strings.HasSuffix(f.Name(), ".gz"):
-		defaultMIME = "application/gzip"
-	case strings.HasSuffix(f.Name(), ".bz2"):
-		defaultMIME = "application/x-bzip2"
-	case strings.HasSuffix(f.Name(), ".xz"):
-		defaultMIME = "application/x-xz"
-	case strings.HasSuffix(f.Name(), ".lz4"):
-		defaultMIME = "application/x-lz4"
-	case strings.HasSuffix(f.Name(), ".zst"):
-		defaultMIME = "application/zstd"
-	case strings.HasSuffix(f.Name(), ".mkv"):
-		defaultMIME = "video/x-matroska"
-	case strings.HasSuffix(f.Name(), ".mp4"):
-		defaultMIME = "video/mp4"
-	case strings.HasSuffix(f.Name(), ".mp3"):
-		defaultMIME = "audio/mpeg"
-	case strings.HasSuffix(f.Name(), ".flac"):
-		defaultMIME = "audio/flac"
-	case strings.HasSuffix(f.Name(), ".wav"):
-		defaultMIME = "audio/wav"
-	case strings.HasSuffix(f.Name(), ".ogg"):
-		defaultMIME = "audio/ogg"
-	case strings.HasSuffix(f.Name(), ".jpg"):
-		defaultMIME = "image/jpeg"
-	case strings.HasSuffix(f.Name(), ".jpeg"):
-		defaultMIME = "image/jpeg"
-	case strings.HasSuffix(f.Name(), ".png"):
-		defaultMIME = "image/png"
-	case strings.HasSuffix(f.Name(), ".gif"):
-		defaultMIME = "image/gif"
-	case strings.HasSuffix(f.Name(), ".webp"):
-		defaultMIME = "image/webp"
-	case strings.HasSuffix(f.Name(), ".pdf"):
-		defaultMIME = "application/pdf"
+	contentType := mimeByName(f.Name())
+	// 扩展名未知时总是根据内容识别, 例如没有扩展名的图片
+	detect := sniff || contentType == "application/octet-stream"
+	head := c.Request.Method == http.MethodHead
+	// HEAD 请求只在识别 Content-Type 时打开文件读取开头
+	var frc io.ReadCloser
+	if !head || detect {
+		var err error
+		if frc, err = f.Open(); err != nil {
+			ExtractErrorResp(c, err)
+			return
+		}
+		defer frc.Close()
+	}
+	var body io.Reader = frc
+	if detect {
+		br := bufio.NewReaderSize(frc, sniffLen)
+		head, _ := br.Peek(sniffLen)
+		contentType = preferSniffed(contentType, http.DetectContentType(head))
+		body = br
 	}
+
 	totalLength := strconv.FormatInt(f.Size(), 10)
-	c.Writer.Header().Set("Accept-Ranges", "bytes")
-	c.Writer.Header().Set("Content-Type", defaultMIME)
-	c.Writer.Header().Set("Content-Disposition", "attachment; filename="+f.Name())
+	// 压缩的文件只能从头解压读取, 告知客户端不要发起范围请求
+	ra, isReaderAt := frc.(io.ReaderAt)
+	// 大小未知时 (例如单个 .gz 文件) 只能完整下载
+	sizeKnown := f.Size() >= 0
+	if sizeKnown && (rangeable(f) || isReaderAt) {
+		c.Writer.Header().Set("Accept-Ranges", "bytes")
+	} else {
+		c.Writer.Header().Set("Accept-Ranges", "none")
+	}
+	c.Writer.Header().Set("Content-Type", contentType)
+	c.Writer.Header().Set("Content-Disposition", contentDisposition(disposition, f.Name()))
 	// c.Writer.Header().Set("Content-Transfer-Encoding", "binary")
-	c.Writer.Header().Set("Content-Length", totalLength)
+	if sizeKnown {
+		c.Writer.Header().Set("Content-Length", totalLength)
+	}
 	c.Writer.Header().Set("Expires", "0")
 	c.Writer.Header().Set("Cache-Control", "must-revalidate")
 	c.Writer.Header().Set("Pragma", "public")
+	// HEAD 请求只返回头部, 不读取文件内容
+	if head {
+		c.Status(200)
+		return
+	}
 	rangeHeader := c.GetHeader("Range")
-	if rangeHeader != "" {
+	// If-Range 与当前文件不匹配时说明文件已变化, 忽略 Range 返回完整内容
+	if rangeHeader != "" && !ifRangeMatches(c, etag, f.ModTime()) {
+		rangeHeader = ""
+	}
+	if rangeHeader != "" && sizeKnown {
 		ranges, err := parseRangeHeader(rangeHeader, f.Size())
 		if err != nil {
-			ErrorStrResp(c, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			// 已设置的文件响应头不适用于错误响应, 按 RFC 7233 返回空内容和文件的完整大小
+			for _, h := range []string{"Content-Type", "Content-Disposition", "Content-Length"} {
+				c.Writer.Header().Del(h)
+			}
+			c.Writer.Header().Set("Content-Range", "bytes */"+totalLength)
+			_ = c.Error(err)
+			c.AbortWithStatus(http.StatusRequestedRangeNotSatisfiable)
 			return
 		}
 
-		// 只处理单个范围的情况，例如 "bytes=0-999"
+		if !isReaderAt {
+			era := newEntryReaderAt(f, struct {
+				io.Reader
+				io.Closer
+			}{body, frc})
+			defer era.Close()
+			ra = era
+		}
+
+		// 单个范围的情况，例如 "bytes=0-999"
 		if len(ranges) == 1 {
 			start := ranges[0].Start
 			end := ranges[0].End
@@ -380,30 +1344,78 @@ func SuccessStreamResp(c *gin.Context, f stdArchiever.File) {
 			// 计算范围长度
 			length := end - start + 1
 
-			// 创建字节切片来接收读取的数据
-			buf := make([]byte, length)
-
-			myReader := NewMyReadAtReader(frc, f.Size())
-			// 调用 ReadAt 方法读取指定范围的数据
-			_, err := myReader.ReadAt(buf, start)
-			if err != nil {
-				ErrorStrResp(c, err.Error(), 500)
-				return
-			}
-
 			// 设置响应头部
 			c.Writer.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, end, totalLength))
 			c.Writer.Header().Set("Content-Length", strconv.FormatInt(length, 10))
 			c.Writer.WriteHeader(http.StatusPartialContent)
 
-			// 将数据写入响应体
-			_, _ = c.Writer.Write(buf)
+			// 流式写入指定范围的数据, 内存占用与范围长度无关; 客户端断开时写入出错, 直接结束
+			if _, err := io.CopyN(c.Writer, io.NewSectionReader(ra, start, length), length); err != nil {
+				_ = c.Error(err)
+			}
+			return
+		}
+
+		// 多个范围时以 multipart/byteranges 返回, 例如 "bytes=0-99,200-299"
+		if len(ranges) > 1 {
+			writeMultipartRanges(c, ra, ranges, f.Size(), contentType)
 			return
 		}
 	}
 	c.Status(200)
 
-	io.Copy(c.Writer, frc)
+	io.Copy(c.Writer, body)
+}
+
+// rangeable 不打开文件判断是否支持随机读取: zip 中未压缩的文件和缓存在磁盘上的文件
+func rangeable(f stdArchiever.File) bool {
+	_, cached := f.FileInfo.(cachedFileInfo)
+	return cached || archiver.Seekable(f)
+}
+
+// writeMultipartRanges 将多个范围写为 multipart/byteranges 响应
+func writeMultipartRanges(c *gin.Context, ra io.ReaderAt, ranges []httpRange, size int64, contentType string) {
+	partHeader := func(r httpRange) textproto.MIMEHeader {
+		return textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, size)},
+		}
+	}
+
+	// 先用同样的分隔符写一遍各部分的头部, 计算响应的总长度
+	var counter countingWriter
+	mw := multipart.NewWriter(&counter)
+	for _, r := range ranges {
+		_, _ = mw.CreatePart(partHeader(r))
+		counter += countingWriter(r.End - r.Start + 1)
+	}
+	_ = mw.Close()
+	boundary := mw.Boundary()
+
+	c.Writer.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	c.Writer.Header().Set("Content-Length", strconv.FormatInt(int64(counter), 10))
+	c.Writer.WriteHeader(http.StatusPartialContent)
+
+	mw = multipart.NewWriter(c.Writer)
+	_ = mw.SetBoundary(boundary)
+	for _, r := range ranges {
+		pw, err := mw.CreatePart(partHeader(r))
+		if err != nil {
+			return
+		}
+		if _, err := io.Copy(pw, io.NewSectionReader(ra, r.Start, r.End-r.Start+1)); err != nil {
+			return
+		}
+	}
+	_ = mw.Close()
+}
+
+// countingWriter 只统计写入的字节数
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
 }
 
 type httpRange struct {
@@ -423,28 +1435,50 @@ func parseRangeHeader(header string, totalLength int64) ([]httpRange, error) {
 	}
 
 	rangeSpecs := strings.Split(match[1], ",")
+	// 限制范围个数, 防止大量细碎的范围导致反复读取
+	if len(rangeSpecs) > conf.MaxRanges {
+		return nil, fmt.Errorf("too many ranges, at most %d", conf.MaxRanges)
+	}
 	for _, rangeSpec := range rangeSpecs {
-		rangeParts := strings.Split(rangeSpec, "-")
+		rangeParts := strings.Split(strings.TrimSpace(rangeSpec), "-")
 		if len(rangeParts) != 2 {
 			return nil, errors.New("Invalid Range Header")
 		}
 
-		var start, end int64
-
 		// 解析起始位置
+		var start, end int64
+		var err error
 		if rangeParts[0] == "" {
-			start = totalLength - 1 - toInt64(rangeParts[1])
+			// "-N" 表示最后 N 个字节, 超过文件大小时为整个文件
+			suffixLength, err := parseRangeInt(rangeParts[1])
+			if err != nil || suffixLength <= 0 {
+				return nil, errors.New("Invalid Range Header")
+			}
+			start = totalLength - suffixLength
+			if start < 0 {
+				start = 0
+			}
 			end = totalLength - 1
 		} else if rangeParts[1] == "" {
-			start = toInt64(rangeParts[0])
+			if start, err = parseRangeInt(rangeParts[0]); err != nil {
+				return nil, err
+			}
 			end = totalLength - 1
 		} else {
-			start = toInt64(rangeParts[0])
-			end = toInt64(rangeParts[1])
+			if start, err = parseRangeInt(rangeParts[0]); err != nil {
+				return nil, err
+			}
+			if end, err = parseRangeInt(rangeParts[1]); err != nil {
+				return nil, err
+			}
+			// 结束位置超过文件大小时截断到文件末尾
+			if end >= totalLength {
+				end = totalLength - 1
+			}
 		}
 
 		// 检查范围是否合法
-		if start > end || start >= totalLength || end >= totalLength {
+		if start > end || start >= totalLength {
 			return nil, errors.New("Invalid Range Header")
 		}
 
@@ -454,36 +1488,62 @@ func parseRangeHeader(header string, totalLength int64) ([]httpRange, error) {
 	return ranges, nil
 }
 
-func toInt64(s string) int64 {
-	val, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
-	return val
+// parseRangeInt 解析 Range 中的位置, 只接受十进制数字
+func parseRangeInt(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("Invalid Range Header: bad position %q", s)
+	}
+	return strconv.ParseInt(s, 10, 64)
 }
 
-type MyReadAtReader struct {
-	r    io.Reader
-	data []byte
-	size int64
+// entryReaderAt 为只能顺序读取的归档文件提供 io.ReaderAt, 向后读取时跳过中间的数据,
+// 向前读取时重新打开文件. 不支持并发调用
+type entryReaderAt struct {
+	f   stdArchiever.File
+	rc  io.ReadCloser
+	pos int64
+	// reopened 当前的 rc 是否由 entryReaderAt 自己打开, 需要自己关闭
+	reopened bool
 }
 
-func NewMyReadAtReader(r io.Reader, size int64) *MyReadAtReader {
-	return &MyReadAtReader{r: r, size: size}
+func newEntryReaderAt(f stdArchiever.File, rc io.ReadCloser) *entryReaderAt {
+	return &entryReaderAt{f: f, rc: rc}
 }
 
-func (r *MyReadAtReader) ReadAt(p []byte, off int64) (n int, err error) {
-	if off < 0 || off >= int64(r.size) {
+func (r *entryReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.f.Size() {
 		return 0, io.EOF
 	}
-
-	discat := make([]byte, off)
-	if _, err := r.r.Read(discat); err != nil {
-		return 0, err
+	if off < r.pos {
+		rc, err := r.f.Open()
+		if err != nil {
+			return 0, err
+		}
+		r.Close()
+		r.rc, r.pos, r.reopened = rc, 0, true
 	}
 
-	if n, err := r.r.Read(p); err != nil {
+	skipped, err := io.CopyN(io.Discard, r.rc, off-r.pos)
+	r.pos += skipped
+	if err != nil {
 		return 0, err
-	} else if n < len(p) {
+	}
+	n, err := io.ReadFull(r.rc, p)
+	r.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
 		err = io.EOF
 	}
-
 	return n, err
 }
+
+// Close 关闭重新打开的文件, 最初传入的 rc 由调用方关闭
+func (r *entryReaderAt) Close() error {
+	if r.reopened {
+		return r.rc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,2163 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+	stdArchiever "github.com/mholt/archiver/v4"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// testModTime 测试归档和源站文件使用的修改时间
+var testModTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// setupConf 使用与命令行参数默认值相同的配置, 并在测试结束后恢复全局状态
+func setupConf(t testing.TB) {
+	t.Helper()
+	oldConf := conf
+	oldBodyCache := bodyCache
+	oldIndexCache := indexCache
+	oldListCache := listCache
+	oldListPrefetch := listPrefetch
+	oldListSpill := listSpill
+	oldExtractionSlots := extractionSlots
+	oldMetrics := metrics
+	oldHashSlots := hashSlots
+	oldTransport := originTransport
+	t.Cleanup(func() {
+		conf = oldConf
+		bodyCache = oldBodyCache
+		indexCache = oldIndexCache
+		listCache = oldListCache
+		listPrefetch = oldListPrefetch
+		listSpill = oldListSpill
+		extractionSlots = oldExtractionSlots
+		metrics = oldMetrics
+		hashSlots = oldHashSlots
+		originTransport = oldTransport
+	})
+
+	conf = Config{
+		GzipLevel:         gzip.DefaultCompression,
+		SearchMaxScored:   10000,
+		MaxBodyBytes:      32 << 20,
+		MaxRanges:         16,
+		StreamPolicy:      "reject",
+		TokenTTL:          10 * time.Minute,
+		Referer:           "none",
+		MaxRedirects:      10,
+		ForwardHeaders:    []string{"Cookie", "User-Agent"},
+		DefaultPerPage:    10,
+		MaxPerPage:        1000,
+		ImplausibleSize:   "reject",
+		MaxBufferBytes:    64 << 20,
+		HashConcurrency:   2,
+		BufSize:           1 << 20,
+		MaxNestDepth:      3,
+		HashMaxBytes:      4 << 30,
+		ShutdownTimeout:   30 * time.Second,
+		RetryAttempts:     1,
+		ExtractionPolicy:  "queue",
+		TreeMaxNodes:      10000,
+		MaxPreviewBytes:   64 << 10,
+		MaxCacheFileBytes: 0,
+	}
+	bodyCache = nil
+	indexCache = nil
+	listCache = nil
+	listPrefetch = nil
+	listSpill = nil
+	extractionSlots = nil
+	metrics = nil
+	hashSlots = make(chan struct{}, conf.HashConcurrency)
+	originTransport = newOriginTransport()
+	if err := initSignKey("test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestRouter 注册与 main 相同的归档接口, 不带认证, 限速等中间件
+func newTestRouter() *gin.Engine {
+	r := gin.New()
+	r.ContextWithFallback = true
+	archiveRoutes(r)
+	r.Any("/hash", Hash)
+	return r
+}
+
+// testOrigin 支持 Range 的源站, 记录收到的请求
+type testOrigin struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	files map[string][]byte
+	// header 每个文件额外返回的头部
+	header map[string]http.Header
+	// requests 收到的请求数
+	requests atomic.Int64
+	// noRange 为 true 时忽略 Range, 总是返回完整内容
+	noRange bool
+	// intercept 不为 nil 时先处理请求, 返回 true 表示已经响应
+	intercept func(w http.ResponseWriter, r *http.Request) bool
+}
+
+func newTestOrigin(t testing.TB, files map[string][]byte) *testOrigin {
+	t.Helper()
+	o := &testOrigin{files: files, header: map[string]http.Header{}}
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.requests.Add(1)
+		if o.intercept != nil && o.intercept(w, r) {
+			return
+		}
+		o.mu.Lock()
+		data, ok := o.files[r.URL.Path]
+		for k, v := range o.header[r.URL.Path] {
+			w.Header()[k] = v
+		}
+		o.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if o.noRange {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			_, _ = w.Write(data)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, testModTime, bytes.NewReader(data))
+	}))
+	t.Cleanup(o.Close)
+	return o
+}
+
+// link 返回源站上文件的链接
+func (o *testOrigin) link(name string) string {
+	return o.URL + name
+}
+
+// testEntry 测试归档中的一个条目, Body 为空且名称以 / 结尾时为目录
+type testEntry struct {
+	Name string
+	Body string
+	Mode os.FileMode
+	// Stored 为 true 时 zip 中不压缩
+	Stored bool
+	// NonUTF8 为 true 时 zip 中不设置 UTF-8 标志, Name 为编码后的原始字节
+	NonUTF8 bool
+	// HardLink 不为空时为 tar 中指向该路径的硬链接
+	HardLink string
+	// Symlink 不为空时为 tar 中指向该路径的符号链接
+	Symlink string
+	// Modified 修改时间, 零值时为 testModTime
+	Modified time.Time
+}
+
+func (e testEntry) modTime() time.Time {
+	if e.Modified.IsZero() {
+		return testModTime
+	}
+	return e.Modified
+}
+
+// zipBytes 构造 zip 归档
+func zipBytes(t testing.TB, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		fh := &zip.FileHeader{Name: e.Name, Method: zip.Deflate, Modified: e.modTime(), NonUTF8: e.NonUTF8}
+		if e.Stored {
+			fh.Method = zip.Store
+		}
+		if e.Mode != 0 {
+			fh.SetMode(e.Mode)
+		}
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, e.Body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tarBytes 构造 tar 归档
+func tarBytes(t testing.TB, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.Name, Mode: 0o644, Size: int64(len(e.Body)), ModTime: e.modTime(), Typeflag: tar.TypeReg}
+		switch {
+		case strings.HasSuffix(e.Name, "/"):
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0o755
+		case e.HardLink != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, e.HardLink, 0
+		case e.Symlink != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size, hdr.Mode = tar.TypeSymlink, e.Symlink, 0, 0o777
+		}
+		if e.Mode != 0 {
+			hdr.Mode = int64(e.Mode.Perm())
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.Body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// gzipBytes 压缩 data
+func gzipBytes(t testing.TB, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compressBytes 用 archiver 的压缩格式压缩数据
+func compressBytes(t testing.TB, c stdArchiever.Compressor, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.OpenWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testdata 读取 testdata 目录中的归档, 7z 归档来自 github.com/bodgit/sevenzip 的测试数据
+func testdata(t testing.TB, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// query 拼接请求的查询参数, 参数成对给出
+func query(path string, kv ...string) string {
+	v := url.Values{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		v.Add(kv[i], kv[i+1])
+	}
+	return path + "?" + v.Encode()
+}
+
+// doRequest 发送请求并返回响应
+func doRequest(t testing.TB, h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// get 发送 GET 请求, header 成对给出
+func get(t testing.TB, h http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return doRequest(t, h, req)
+}
+
+// decodeResp 解析 JSON 响应, 成功时 data 为只有一个元素的数组
+func decodeResp[T any](t testing.TB, w *httptest.ResponseRecorder) Resp[[]T] {
+	t.Helper()
+	var resp Resp[[]T]
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+	return resp
+}
+
+// getData 发送 GET 请求并返回成功响应中的数据
+func getData[T any](t testing.TB, h http.Handler, target string, header ...string) T {
+	t.Helper()
+	resp := decodeResp[T](t, get(t, h, target, header...))
+	if resp.Code != 200 || len(resp.Data) != 1 {
+		t.Fatalf("GET %s: code %d, message %q", target, resp.Code, resp.Message)
+	}
+	return resp.Data[0]
+}
+
+// names 返回条目在归档中的路径
+func names(objs []ObjResp) []string {
+	s := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		s = append(s, obj.NameInArchive)
+	}
+	return s
+}
+
+// getCode 发送 GET 请求并返回 JSON 响应中的错误码
+func getCode(t testing.TB, h http.Handler, target string, header ...string) int {
+	t.Helper()
+	return decodeResp[json.RawMessage](t, get(t, h, target, header...)).Code
+}
+
+func TestExtractTgz(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "docs/"},
+			testEntry{Name: "docs/a.txt", Body: "alpha"},
+			testEntry{Name: "docs/sub/b.txt", Body: strings.Repeat("beta", 100)},
+			testEntry{Name: "other.txt", Body: "other"},
+		),
+	})
+	r := newTestRouter()
+
+	w := get(t, r, query("/extract", "link", origin.link("/a.zip"), "path", "/docs", "format", "tgz", "level", "9"))
+	if w.Code != 200 {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "docs.tar.gz") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if w.Header().Get("Content-Length") != "" {
+		t.Errorf("Content-Length should not be set for a streamed archive")
+	}
+
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			got[strings.TrimPrefix(hdr.Name, "/")] = string(body)
+		}
+	}
+	want := map[string]string{"docs/a.txt": "alpha", "docs/sub/b.txt": strings.Repeat("beta", 100)}
+	for name, body := range want {
+		if got[name] != body {
+			t.Errorf("member %s = %q, want %q", name, got[name], body)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d members, want %d", len(got), len(want))
+	}
+}
+
+func TestExtractTgzBadLevel(t *testing.T) {
+	setupConf(t)
+	r := newTestRouter()
+	if code := getCode(t, r, query("/extract", "link", "http://example.com/a.zip", "format", "tgz", "level", "10")); code != 400 {
+		t.Errorf("code = %d, want 400", code)
+	}
+}
+
+func TestDownAcceptRanges(t *testing.T) {
+	setupConf(t)
+	body := strings.Repeat("0123456789", 100)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "stored.txt", Body: body, Stored: true},
+			testEntry{Name: "deflated.txt", Body: body},
+		),
+		"/a.tar.gz":      gzipBytes(t, tarBytes(t, testEntry{Name: "member.txt", Body: body})),
+		"/single.txt.gz": gzipBytes(t, []byte(body)),
+	})
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		link, path, want string
+	}{
+		{"/a.zip", "/stored.txt", "bytes"},
+		{"/a.zip", "/deflated.txt", "none"},
+		{"/a.tar.gz", "/member.txt", "none"},
+		// 单个 .gz 文件解压后的大小未知
+		{"/single.txt.gz", "/single.txt", "none"},
+	} {
+		w := get(t, r, query("/down", "link", origin.link(tc.link), "path", tc.path))
+		if w.Code != 200 {
+			t.Fatalf("%s%s: status %d: %s", tc.link, tc.path, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Accept-Ranges"); got != tc.want {
+			t.Errorf("%s%s: Accept-Ranges = %q, want %q", tc.link, tc.path, got, tc.want)
+		}
+		if w.Body.String() != body {
+			t.Errorf("%s%s: body has %d bytes, want %d", tc.link, tc.path, w.Body.Len(), len(body))
+		}
+	}
+}
+
+// encodeName 按 enc 编码文件名, 用于构造非 UTF-8 文件名的 zip
+func encodeName(t testing.TB, enc encoding.Encoding, name string) string {
+	t.Helper()
+	encoded, err := enc.NewEncoder().String(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestListNameEncoding(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/mixed.zip": zipBytes(t,
+			testEntry{Name: encodeName(t, simplifiedchinese.GBK, "中文.txt"), Body: "gbk", NonUTF8: true},
+			testEntry{Name: "日本語.txt", Body: "utf-8"},
+			testEntry{Name: "plain.txt", Body: "ascii", NonUTF8: true},
+		),
+		"/sjis.zip": zipBytes(t,
+			testEntry{Name: encodeName(t, japanese.ShiftJIS, "日本語.txt"), Body: "shift-jis", NonUTF8: true},
+			testEntry{Name: "中文.txt", Body: "utf-8"},
+		),
+	})
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		link, encoding string
+		want           map[string]string
+	}{
+		{"/mixed.zip", "", map[string]string{"中文.txt": "gbk", "日本語.txt": "utf-8", "plain.txt": "utf-8"}},
+		{"/sjis.zip", "shift-jis", map[string]string{"日本語.txt": "shift-jis", "中文.txt": "utf-8"}},
+	} {
+		resp := decodeResp[ListResp](t, get(t, r, query("/list", "link", origin.link(tc.link), "encoding", tc.encoding)))
+		if resp.Code != 200 {
+			t.Fatalf("%s: code %d: %s", tc.link, resp.Code, resp.Message)
+		}
+		got := map[string]string{}
+		for _, obj := range resp.Data[0].Content {
+			got[obj.Name] = obj.NameEncoding
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: name encodings = %v, want %v", tc.link, got, tc.want)
+		}
+	}
+}
+
+func TestListTruncatedArchive(t *testing.T) {
+	setupConf(t)
+	zipData := zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"}, testEntry{Name: "b.txt", Body: "beta"})
+	// 前两个文件完整, 第三个文件的头部被截断
+	tarData := tarBytes(t,
+		testEntry{Name: "a.txt", Body: "alpha"},
+		testEntry{Name: "b.txt", Body: "beta"},
+		testEntry{Name: "c.txt", Body: strings.Repeat("c", 4096)},
+	)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/cut.zip": zipData[:len(zipData)-30],
+		"/cut.tar": tarData[:4*512+256],
+	})
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		link, want string
+	}{
+		{"/cut.zip", "archive appears truncated, 0 entries read before failure"},
+		{"/cut.tar", "archive appears truncated, 2 entries read before failure"},
+	} {
+		resp := decodeResp[json.RawMessage](t, get(t, r, query("/list", "link", origin.link(tc.link))))
+		if resp.Code != 422 {
+			t.Errorf("%s: code = %d, want 422 (%s)", tc.link, resp.Code, resp.Message)
+		}
+		if resp.Message != tc.want {
+			t.Errorf("%s: message = %q, want %q", tc.link, resp.Message, tc.want)
+		}
+	}
+}
+
+func TestListTarLinkGroups(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/links.tar": tarBytes(t,
+			testEntry{Name: "data/orig.bin", Body: "original"},
+			testEntry{Name: "data/other.bin", Body: "other"},
+			testEntry{Name: "a/link1.bin", HardLink: "data/orig.bin"},
+			testEntry{Name: "b/link2.bin", HardLink: "data/orig.bin"},
+			testEntry{Name: "c/link3.bin", HardLink: "data/other.bin"},
+			testEntry{Name: "plain.txt", Body: "plain"},
+		),
+	})
+	r := newTestRouter()
+
+	resp := getData[ListResp](t, r, query("/list", "link", origin.link("/links.tar"), "cascade", "true", "type", "file", "per_page", "100"))
+	got := map[string]string{}
+	for _, obj := range resp.Content {
+		got[obj.NameInArchive] = obj.LinkGroup
+	}
+	want := map[string]string{
+		"data/orig.bin":  "data/orig.bin",
+		"a/link1.bin":    "data/orig.bin",
+		"b/link2.bin":    "data/orig.bin",
+		"data/other.bin": "data/other.bin",
+		"c/link3.bin":    "data/other.bin",
+		"plain.txt":      "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("link groups = %v, want %v", got, want)
+	}
+}
+
+func TestListShuffleSeed(t *testing.T) {
+	setupConf(t)
+	var entries []testEntry
+	for i := 0; i < 20; i++ {
+		entries = append(entries, testEntry{Name: fmt.Sprintf("img%02d.png", i), Body: "x"})
+	}
+	origin := newTestOrigin(t, map[string][]byte{"/gallery.zip": zipBytes(t, entries...)})
+	r := newTestRouter()
+	list := func(seed string, page, perPage int) []string {
+		resp := getData[ListResp](t, r, query("/list", "link", origin.link("/gallery.zip"), "shuffle", seed,
+			"page", strconv.Itoa(page), "per_page", strconv.Itoa(perPage)))
+		var s []string
+		for _, obj := range resp.Content {
+			s = append(s, obj.Name)
+		}
+		return s
+	}
+
+	first := list("42", 1, 20)
+	if again := list("42", 1, 20); !reflect.DeepEqual(first, again) {
+		t.Errorf("same seed gave different orders:\n%v\n%v", first, again)
+	}
+	if other := list("7", 1, 20); reflect.DeepEqual(first, other) {
+		t.Errorf("different seeds gave the same order %v", first)
+	}
+	sorted := append([]string(nil), first...)
+	sort.Strings(sorted)
+	for i, name := range sorted {
+		if name != entries[i].Name {
+			t.Fatalf("shuffled entries %v are not a permutation of the archive", first)
+		}
+	}
+	if reflect.DeepEqual(first, sorted) {
+		t.Errorf("shuffle kept the archive order")
+	}
+	// 打乱在分页之前, 各页连起来与一次返回的顺序相同
+	if paged := append(list("42", 1, 8), append(list("42", 2, 8), list("42", 3, 8)...)...); !reflect.DeepEqual(paged, first) {
+		t.Errorf("pages = %v, want %v", paged, first)
+	}
+
+	if code := getCode(t, r, query("/list", "link", origin.link("/gallery.zip"), "shuffle", "1", "sort", "name")); code != 400 {
+		t.Errorf("shuffle with sort: code = %d, want 400", code)
+	}
+}
+
+func TestDownTooManyRanges(t *testing.T) {
+	setupConf(t)
+	conf.MaxRanges = 4
+	body := strings.Repeat("0123456789", 100)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: body, Stored: true})})
+	r := newTestRouter()
+	target := query("/down", "link", origin.link("/a.zip"), "path", "/a.txt")
+
+	ranges := func(n int) string {
+		specs := make([]string, n)
+		for i := range specs {
+			specs[i] = fmt.Sprintf("%d-%d", i*10, i*10+1)
+		}
+		return "bytes=" + strings.Join(specs, ",")
+	}
+	if w := get(t, r, target, "Range", ranges(4)); w.Code != 206 {
+		t.Errorf("%d ranges: status %d, want 206", 4, w.Code)
+	}
+	w := get(t, r, target, "Range", ranges(5))
+	if w.Code != 416 {
+		t.Fatalf("%d ranges: status %d, want 416", 5, w.Code)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes */1000" {
+		t.Errorf("Content-Range = %q, want bytes */1000", got)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("416 response should have no body, got %q", w.Body.String())
+	}
+}
+
+func TestListRelPath(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "top.txt", Body: "t"},
+			testEntry{Name: "a/b/c.txt", Body: "c"},
+			testEntry{Name: "a/b/d/e.txt", Body: "e"},
+		),
+	})
+	r := newTestRouter()
+	relPaths := func(kv ...string) map[string]string {
+		resp := getData[ListResp](t, r, query("/list", append([]string{"link", origin.link("/a.zip")}, kv...)...))
+		m := map[string]string{}
+		for _, obj := range resp.Content {
+			m[obj.NameInArchive] = obj.RelPath
+		}
+		return m
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want map[string]string
+	}{
+		// 根目录时为完整路径
+		{[]string{"path", "/"}, map[string]string{"top.txt": "top.txt", "a/": "a/"}},
+		{[]string{"path", "/a/b"}, map[string]string{"a/b/c.txt": "c.txt", "a/b/d/": "d/"}},
+		{[]string{"path", "/a/b/", "cascade", "true"}, map[string]string{"a/b/c.txt": "c.txt", "a/b/d/e.txt": "d/e.txt"}},
+	} {
+		if got := relPaths(tc.args...); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: rel_path = %v, want %v", tc.args, got, tc.want)
+		}
+	}
+}
+
+// plaintext 每个位置的内容都不同的测试数据, 读错位置时可以发现
+func plaintext(n int) string {
+	var b strings.Builder
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, "%08d\n", i)
+	}
+	return b.String()[:n]
+}
+
+func TestEntryReaderAtShortReads(t *testing.T) {
+	body := plaintext(1 << 16)
+	f := memFile(t, "a.txt", body)
+	open := f.Open
+	// 每次 Read 只返回一个字节, 跳过时必须循环读取
+	f.Open = func() (io.ReadCloser, error) {
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{iotest.OneByteReader(rc), rc}, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	era := newEntryReaderAt(f, rc)
+	defer era.Close()
+
+	// 向后, 重叠和向前 (需要重新打开) 的读取
+	for _, r := range []struct{ off, n int }{{5000, 1000}, {5500, 1000}, {60000, 5536}, {100, 50}, {0, 10}} {
+		p := make([]byte, r.n)
+		n, err := era.ReadAt(p, int64(r.off))
+		if err != nil && err != io.EOF {
+			t.Fatalf("ReadAt(%d, %d): %v", r.off, r.n, err)
+		}
+		if got, want := string(p[:n]), body[r.off:r.off+r.n]; got != want {
+			t.Errorf("ReadAt(%d, %d) = %q..., want %q...", r.off, r.n, got[:min(len(got), 16)], want[:16])
+		}
+	}
+	if _, err := era.ReadAt(make([]byte, 1), int64(len(body))); err != io.EOF {
+		t.Errorf("read past the end: err = %v, want EOF", err)
+	}
+}
+
+func TestDownRangeOnCompressedEntry(t *testing.T) {
+	setupConf(t)
+	body := plaintext(1 << 20)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "big.txt", Body: body})})
+	r := newTestRouter()
+	target := query("/down", "link", origin.link("/a.zip"), "path", "/big.txt")
+
+	for _, tc := range []struct {
+		header     string
+		start, end int
+	}{
+		{"bytes=5000-5999", 5000, 5999},
+		{"bytes=5500-6499", 5500, 6499},
+		{"bytes=1000000-1000999", 1000000, 1000999},
+		{"bytes=1048000-", 1048000, 1<<20 - 1},
+		{"bytes=-100", 1<<20 - 100, 1<<20 - 1},
+	} {
+		w := get(t, r, target, "Range", tc.header)
+		if w.Code != 206 {
+			t.Fatalf("%s: status %d", tc.header, w.Code)
+		}
+		if got, want := w.Body.String(), body[tc.start:tc.end+1]; got != want {
+			t.Errorf("%s: got %d bytes starting %q, want %d bytes starting %q", tc.header, len(got), got[:min(len(got), 9)], len(want), want[:9])
+		}
+		if got, want := w.Header().Get("Content-Range"), fmt.Sprintf("bytes %d-%d/%d", tc.start, tc.end, 1<<20); got != want {
+			t.Errorf("%s: Content-Range = %q, want %q", tc.header, got, want)
+		}
+	}
+}
+
+func TestDownMultipartRanges(t *testing.T) {
+	setupConf(t)
+	body := plaintext(10000)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "stored.txt", Body: body, Stored: true},
+			testEntry{Name: "deflated.txt", Body: body},
+		),
+	})
+	r := newTestRouter()
+	want := []struct{ start, end int }{{0, 99}, {200, 299}, {250, 349}, {9900, 9999}, {50, 59}}
+
+	for _, path := range []string{"/stored.txt", "/deflated.txt"} {
+		w := get(t, r, query("/down", "link", origin.link("/a.zip"), "path", path), "Range", "bytes=0-99,200-299,250-349,-100,50-59")
+		if w.Code != 206 {
+			t.Fatalf("%s: status %d", path, w.Code)
+		}
+		if got := w.Header().Get("Content-Length"); got != strconv.Itoa(w.Body.Len()) {
+			t.Errorf("%s: Content-Length = %s, body has %d bytes", path, got, w.Body.Len())
+		}
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("%s: Content-Type = %q", path, w.Header().Get("Content-Type"))
+		}
+		mr := multipart.NewReader(w.Body, params["boundary"])
+		for i := 0; ; i++ {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				if i != len(want) {
+					t.Errorf("%s: got %d parts, want %d", path, i, len(want))
+				}
+				break
+			} else if err != nil {
+				t.Fatalf("%s: part %d: %v", path, i, err)
+			}
+			if i >= len(want) {
+				t.Fatalf("%s: too many parts", path)
+			}
+			data, err := io.ReadAll(part)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rg := want[i]
+			if got, wantRange := part.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-%d/%d", rg.start, rg.end, len(body)); got != wantRange {
+				t.Errorf("%s: part %d Content-Range = %q, want %q", path, i, got, wantRange)
+			}
+			if got := part.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+				t.Errorf("%s: part %d Content-Type = %q", path, i, got)
+			}
+			if string(data) != body[rg.start:rg.end+1] {
+				t.Errorf("%s: part %d = %q, want %q", path, i, data, body[rg.start:rg.end+1])
+			}
+		}
+	}
+}
+
+func TestPaginationParamErrors(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	for _, endpoint := range []string{"/list", "/search"} {
+		base := []string{"link", link, "query", "a"}
+		if endpoint == "/list" {
+			base = []string{"link", link, "cascade", "true"}
+		}
+		for _, tc := range []struct{ field, value string }{
+			{"page", "abc"}, {"page", "-1"}, {"page", "1.5"},
+			{"per_page", "ten"}, {"per_page", "-5"},
+		} {
+			resp := decodeResp[json.RawMessage](t, get(t, r, query(endpoint, append(base, tc.field, tc.value)...)))
+			want := tc.field + " must be a non-negative integer"
+			if resp.Code != 400 || resp.Message != want {
+				t.Errorf("%s %s=%s: code %d, message %q, want 400 %q", endpoint, tc.field, tc.value, resp.Code, resp.Message, want)
+			}
+		}
+		if code := getCode(t, r, query(endpoint, append(base, "page", "0", "per_page", "0")...)); code != 200 {
+			t.Errorf("%s: zero pagination values should use the defaults, code %d", endpoint, code)
+		}
+	}
+
+	// JSON 请求体中的分页参数
+	for body, field := range map[string]string{
+		`{"link":"` + link + `","page":"abc"}`:  "page",
+		`{"link":"` + link + `","per_page":-2}`: "per_page",
+		`{"link":"` + link + `","page":-1}`:     "page",
+	} {
+		resp := decodeResp[json.RawMessage](t, post(t, r, "/list", "application/json", []byte(body)))
+		if want := field + " must be a non-negative integer"; resp.Code != 400 || resp.Message != want {
+			t.Errorf("%s: code %d, message %q, want 400 %q", body, resp.Code, resp.Message, want)
+		}
+	}
+}
+
+func TestParseRangeHeader(t *testing.T) {
+	setupConf(t)
+	for _, tc := range []struct {
+		header string
+		want   []httpRange
+	}{
+		{"bytes=-500", []httpRange{{500, 999}}},
+		{"bytes=0-", []httpRange{{0, 999}}},
+		{"bytes=999-", []httpRange{{999, 999}}},
+		{"bytes=0-0", []httpRange{{0, 0}}},
+		{"bytes=-2000", []httpRange{{0, 999}}},
+		{"bytes=900-5000", []httpRange{{900, 999}}},
+		{"bytes= 0-9 , 20-29", []httpRange{{0, 9}, {20, 29}}},
+		// 不合法或无法满足的范围
+		{"bytes=-0", nil},
+		{"bytes=1000-", nil},
+		{"bytes=10-5", nil},
+		{"bytes=abc-", nil},
+		{"bytes=-x", nil},
+		{"bytes=+5-10", nil},
+		{"bytes=5", nil},
+		{"items=0-9", nil},
+	} {
+		got, err := parseRangeHeader(tc.header, 1000)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", tc.header, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %v, %v, want %v", tc.header, got, err, tc.want)
+		}
+	}
+}
+
+func TestDownUnsatisfiableRange(t *testing.T) {
+	setupConf(t)
+	body := plaintext(1000)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: body})})
+	r := newTestRouter()
+	target := query("/down", "link", origin.link("/a.zip"), "path", "/a.txt")
+
+	w := get(t, r, target, "Range", "bytes=-500")
+	if w.Code != 206 || w.Body.String() != body[500:] {
+		t.Errorf("bytes=-500: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	w = get(t, r, target, "Range", "bytes=-0")
+	if w.Code != 416 || w.Header().Get("Content-Range") != "bytes */1000" {
+		t.Errorf("bytes=-0: status %d, Content-Range %q, want 416 bytes */1000", w.Code, w.Header().Get("Content-Range"))
+	}
+}
+
+func TestExtractTrailers(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "docs/a.txt", Body: "alpha"},
+			testEntry{Name: "docs/sub/b.txt", Body: "beta"},
+			testEntry{Name: "docs/sub/c.txt", Body: "gamma"},
+			testEntry{Name: "other.txt", Body: "other"},
+		),
+	})
+	srv := httptest.NewServer(newTestRouter())
+	defer srv.Close()
+
+	for _, format := range []string{"tar", "zip", "tgz"} {
+		resp, err := http.Get(srv.URL + query("/extract", "link", origin.link("/a.zip"), "path", "/docs", "format", format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// trailer 在读完响应体之后才可用
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != 200 || len(data) == 0 {
+			t.Fatalf("%s: status %d, %d bytes, %v", format, resp.StatusCode, len(data), err)
+		}
+		if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+			t.Errorf("%s: Transfer-Encoding = %v, want chunked", format, resp.TransferEncoding)
+		}
+		if got := resp.Trailer.Get("X-Members-Written"); got != "3" {
+			t.Errorf("%s: X-Members-Written = %q, want 3", format, got)
+		}
+		if got := resp.Trailer.Get("X-Bytes-Written"); got != strconv.Itoa(len("alpha"+"beta"+"gamma")) {
+			t.Errorf("%s: X-Bytes-Written = %q, want %d", format, got, len("alpha"+"beta"+"gamma"))
+		}
+	}
+}
+
+// zeroEntry 内容全为 0 的文件, 不占用内存, 记录读取的字节数
+type zeroEntry struct {
+	size int64
+	read atomic.Int64
+}
+
+func (z *zeroEntry) ReadAt(p []byte, off int64) (int, error) {
+	if off >= z.size {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), z.size-off))
+	clear(p[:n])
+	z.read.Add(int64(n))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (z *zeroEntry) file() stdArchiever.File {
+	fi := sizedFileInfo{name: "big.bin", size: z.size}
+	return stdArchiever.File{
+		FileInfo:      fi,
+		NameInArchive: "big.bin",
+		Open: func() (io.ReadCloser, error) {
+			return struct {
+				*io.SectionReader
+				io.Closer
+			}{io.NewSectionReader(z, 0, z.size), io.NopCloser(nil)}, nil
+		},
+	}
+}
+
+type sizedFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi sizedFileInfo) Name() string       { return fi.name }
+func (fi sizedFileInfo) Size() int64        { return fi.size }
+func (fi sizedFileInfo) Mode() os.FileMode  { return 0o644 }
+func (fi sizedFileInfo) ModTime() time.Time { return testModTime }
+func (fi sizedFileInfo) IsDir() bool        { return false }
+func (fi sizedFileInfo) Sys() any           { return nil }
+
+// discardWriter 丢弃写入的内容, 写入 limit 个字节后返回错误, 模拟客户端断开
+type discardWriter struct {
+	header  http.Header
+	status  int
+	written int64
+	limit   int64
+}
+
+func (w *discardWriter) Header() http.Header { return w.header }
+func (w *discardWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	if w.limit > 0 && w.written+int64(len(p)) > w.limit {
+		return 0, errors.New("client disconnected")
+	}
+	w.written += int64(len(p))
+	return len(p), nil
+}
+
+func TestStreamLargeRangeBoundedMemory(t *testing.T) {
+	setupConf(t)
+	const size = 1 << 30
+	entry := &zeroEntry{size: size}
+	serve := func(w *discardWriter) {
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/down", nil)
+		c.Request.Header.Set("Range", fmt.Sprintf("bytes=0-%d", size-1))
+		SuccessStreamResp(c, entry.file(), false, "", "attachment")
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	w := &discardWriter{header: http.Header{}}
+	serve(w)
+	runtime.ReadMemStats(&after)
+
+	if w.status != 206 || w.written != size {
+		t.Fatalf("status %d, wrote %d bytes, want 206 and %d", w.status, w.written, size)
+	}
+	if got := w.header.Get("Content-Length"); got != strconv.Itoa(size) {
+		t.Errorf("Content-Length = %s", got)
+	}
+	if got := w.header.Get("Content-Range"); got != fmt.Sprintf("bytes 0-%d/%d", size-1, size) {
+		t.Errorf("Content-Range = %s", got)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Errorf("serving a 1 GiB range allocated %d bytes", alloc)
+	}
+
+	// 客户端断开后停止读取文件
+	entry.read.Store(0)
+	w = &discardWriter{header: http.Header{}, limit: 1 << 20}
+	serve(w)
+	if read := entry.read.Load(); read > 2<<20 {
+		t.Errorf("read %d bytes after the client disconnected at 1 MiB", read)
+	}
+}
+
+func TestListBOMName(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/bom.zip": zipBytes(t, testEntry{Name: "\uFEFFreadme.txt", Body: "hello"}, testEntry{Name: "b.txt", Body: "beta"}),
+	})
+	r := newTestRouter()
+
+	list := getData[ListResp](t, r, query("/list", "link", origin.link("/bom.zip")))
+	if got := names(list.Content); !reflect.DeepEqual(got, []string{"readme.txt", "b.txt"}) {
+		t.Errorf("entries = %q", got)
+	}
+	for _, obj := range list.Content {
+		if obj.NameInArchive == "readme.txt" && obj.Name != "readme.txt" {
+			t.Errorf("name = %q, want readme.txt", obj.Name)
+		}
+	}
+	w := get(t, r, query("/down", "link", origin.link("/bom.zip"), "path", "/readme.txt"))
+	if w.Code != 200 || w.Body.String() != "hello" {
+		t.Errorf("down: status %d, body %q", w.Code, w.Body.String())
+	}
+}
+
+func TestDownMultiMemberGzip(t *testing.T) {
+	setupConf(t)
+	multi := append(gzipBytes(t, []byte("first member\n")), gzipBytes(t, []byte("second member\n"))...)
+	origin := newTestOrigin(t, map[string][]byte{"/notes.txt.gz": multi})
+	r := newTestRouter()
+	link := origin.link("/notes.txt.gz")
+
+	list := getData[ListResp](t, r, query("/list", "link", link))
+	if got := names(list.Content); !reflect.DeepEqual(got, []string{"notes.txt"}) {
+		t.Errorf("entries = %q", got)
+	}
+	for firstMember, want := range map[bool]string{false: "first member\nsecond member\n", true: "first member\n"} {
+		conf.GzipFirstMember = firstMember
+		w := get(t, r, query("/down", "link", link, "path", "/notes.txt"))
+		if w.Code != 200 || w.Body.String() != want {
+			t.Errorf("first member only %v: status %d, body %q, want %q", firstMember, w.Code, w.Body.String(), want)
+		}
+	}
+}
+
+func TestGetMissingPath(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"}),
+		"/a.bin": []byte("not an archive"),
+	})
+	r := newTestRouter()
+
+	for _, endpoint := range []string{"/get", "/down"} {
+		resp := decodeResp[json.RawMessage](t, get(t, r, query(endpoint, "link", origin.link("/a.zip"), "path", "/missing.txt")))
+		if resp.Code != 404 {
+			t.Errorf("%s missing path: code %d, message %q, want 404", endpoint, resp.Code, resp.Message)
+		}
+		// 不支持的格式不是文件不存在
+		resp = decodeResp[json.RawMessage](t, get(t, r, query(endpoint, "link", origin.link("/a.bin"), "path", "/a.txt")))
+		if resp.Code == 200 || resp.Code == 404 {
+			t.Errorf("%s unsupported format: code %d, message %q", endpoint, resp.Code, resp.Message)
+		}
+	}
+}
+
+func TestListImplicitDirs(t *testing.T) {
+	setupConf(t)
+	// 只包含文件条目, 没有目录条目
+	entries := []testEntry{
+		{Name: "docs/readme.txt", Body: "r"},
+		{Name: "docs/images/a.png", Body: "a"},
+		{Name: "docs/images/b.png", Body: "b"},
+		{Name: "docs/images/icons/c.png", Body: "c"},
+	}
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, entries...),
+		"/a.tar": tarBytes(t, entries...),
+	})
+	r := newTestRouter()
+
+	for _, link := range []string{"/a.zip", "/a.tar"} {
+		for dir, want := range map[string][]string{
+			"/":            {"docs/"},
+			"/docs":        {"docs/readme.txt", "docs/images/"},
+			"/docs/images": {"docs/images/a.png", "docs/images/b.png", "docs/images/icons/"},
+		} {
+			list := getData[ListResp](t, r, query("/list", "link", origin.link(link), "path", dir))
+			got := names(list.Content)
+			sort.Strings(got)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %s: entries = %q, want %q", link, dir, got, want)
+			}
+			for _, obj := range list.Content {
+				if strings.HasSuffix(obj.NameInArchive, "/") && (!obj.IsDir || obj.Size != 0) {
+					t.Errorf("%s %s: synthesized %s: is_dir %v, size %d", link, dir, obj.NameInArchive, obj.IsDir, obj.Size)
+				}
+			}
+		}
+	}
+}
+
+func TestListUTF8FlagNames(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/utf8.zip": zipBytes(t, testEntry{Name: "文档/报告.txt", Body: "utf-8"}),
+		"/gbk.zip":  zipBytes(t, testEntry{Name: encodeName(t, simplifiedchinese.GBK, "文档/报告.txt"), Body: "gbk", NonUTF8: true}),
+	})
+	r := newTestRouter()
+
+	// 设置了 UTF-8 标志的文件名不能按 gbk 解码
+	for link, body := range map[string]string{"/utf8.zip": "utf-8", "/gbk.zip": "gbk"} {
+		list := getData[ListResp](t, r, query("/list", "link", origin.link(link), "path", "/文档"))
+		if len(list.Content) != 1 || list.Content[0].Name != "报告.txt" || list.Content[0].NameInArchive != "文档/报告.txt" {
+			t.Errorf("%s: entries = %+v", link, list.Content)
+		}
+		w := get(t, r, query("/down", "link", origin.link(link), "path", "/文档/报告.txt"))
+		if w.Code != 200 || w.Body.String() != body {
+			t.Errorf("%s: down: status %d, body %q", link, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestListEncodingParam(t *testing.T) {
+	setupConf(t)
+	fixtures := map[string]struct {
+		enc  encoding.Encoding
+		name string
+	}{
+		"gbk":       {simplifiedchinese.GBK, "简体中文.txt"},
+		"shift-jis": {japanese.ShiftJIS, "日本語のファイル.txt"},
+		"euc-kr":    {korean.EUCKR, "한국어.txt"},
+	}
+	files := map[string][]byte{}
+	for encName, f := range fixtures {
+		files["/"+encName+".zip"] = zipBytes(t, testEntry{Name: encodeName(t, f.enc, f.name), Body: encName, NonUTF8: true})
+	}
+	origin := newTestOrigin(t, files)
+	r := newTestRouter()
+
+	for encName, f := range fixtures {
+		link := origin.link("/" + encName + ".zip")
+		list := getData[ListResp](t, r, query("/list", "link", link, "encoding", encName))
+		if got := names(list.Content); !reflect.DeepEqual(got, []string{f.name}) {
+			t.Errorf("%s: entries = %q, want %q", encName, got, f.name)
+		}
+		w := get(t, r, query("/down", "link", link, "path", "/"+f.name, "encoding", encName))
+		if w.Code != 200 || w.Body.String() != encName {
+			t.Errorf("%s: down: status %d, body %q", encName, w.Code, w.Body.String())
+		}
+	}
+
+	resp := decodeResp[json.RawMessage](t, get(t, r, query("/list", "link", origin.link("/gbk.zip"), "encoding", "latin1")))
+	if resp.Code != 400 || !strings.Contains(resp.Message, strings.Join(archiver.NameEncodings, ", ")) {
+		t.Errorf("unknown encoding: code %d, message %q", resp.Code, resp.Message)
+	}
+}
+
+func TestListGlob(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/logs.zip": zipBytes(t,
+			testEntry{Name: "logs/2023-12/a.log", Body: "a"},
+			testEntry{Name: "logs/2024-01/b.log", Body: "b"},
+			testEntry{Name: "logs/2024-02/"},
+			testEntry{Name: "logs/2024-02/c.log", Body: "c"},
+			testEntry{Name: "logs/2024-summary.txt", Body: "s"},
+			testEntry{Name: "other/2024-03/d.log", Body: "d"},
+		),
+	})
+	link := origin.link("/logs.zip")
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		dir, glob string
+		want      []string
+	}{
+		{"/", "logs/2024-*/", []string{"logs/2024-01/", "logs/2024-02/"}},
+		{"/", "logs/2024-*", []string{"logs/2024-01/", "logs/2024-02/", "logs/2024-summary.txt"}},
+		{"/logs", "*/*.log", []string{"logs/2023-12/a.log", "logs/2024-01/b.log", "logs/2024-02/c.log"}},
+		{"/", "*/2024-0[23]/", []string{"logs/2024-02/", "other/2024-03/"}},
+	} {
+		list := getData[ListResp](t, r, query("/list", "link", link, "path", tc.dir, "glob", tc.glob))
+		got := names(list.Content)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("path %s, glob %s: entries = %q, want %q", tc.dir, tc.glob, got, tc.want)
+		}
+	}
+
+	if code := getCode(t, r, query("/list", "link", link, "glob", "logs/[")); code != 400 {
+		t.Errorf("invalid glob: code = %d, want 400", code)
+	}
+}
+
+// hugeSizeZip 把中央目录中 name 的解压后大小改为接近 4 GiB, 模拟损坏或恶意构造的归档
+func hugeSizeZip(t testing.TB, entries ...testEntry) []byte {
+	t.Helper()
+	data := zipBytes(t, entries...)
+	cd := bytes.Index(data, []byte("PK\x01\x02"))
+	if cd < 0 {
+		t.Fatal("no central directory")
+	}
+	binary.LittleEndian.PutUint32(data[cd+24:], 0xfffffff0)
+	return data
+}
+
+func TestDownImplausibleSize(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/huge.zip": hugeSizeZip(t, testEntry{Name: "huge.txt", Body: "hello", Stored: true}, testEntry{Name: "ok.txt", Body: "fine"}),
+	})
+	link := origin.link("/huge.zip")
+	r := newTestRouter()
+
+	// 仍然可以列出
+	list := getData[ListResp](t, r, query("/list", "link", link))
+	if got := names(list.Content); !reflect.DeepEqual(got, []string{"huge.txt", "ok.txt"}) {
+		t.Errorf("entries = %q", got)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	resp := decodeResp[json.RawMessage](t, get(t, r, query("/down", "link", link, "path", "/huge.txt")))
+	runtime.ReadMemStats(&after)
+	if resp.Code != 422 {
+		t.Errorf("down: code %d, message %q, want 422", resp.Code, resp.Message)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+		t.Errorf("down allocated %d bytes", alloc)
+	}
+	if w := get(t, r, query("/down", "link", link, "path", "/ok.txt")); w.Body.String() != "fine" {
+		t.Errorf("other entry: status %d, body %q", w.Code, w.Body.String())
+	}
+
+	conf.ImplausibleSize = "unknown"
+	w := get(t, r, query("/down", "link", link, "path", "/huge.txt"))
+	if w.Code != 200 || w.Body.String() != "hello" || w.Header().Get("Content-Length") != "" {
+		t.Errorf("unknown size: status %d, body %q, Content-Length %q", w.Code, w.Body.String(), w.Header().Get("Content-Length"))
+	}
+}
+
+func TestDownDirZip(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.tar": tarBytes(t,
+			testEntry{Name: "top.txt", Body: "top"},
+			testEntry{Name: "docs/a.txt", Body: "alpha"},
+			testEntry{Name: "docs/sub/b.txt", Body: "beta"},
+			testEntry{Name: "docsx/c.txt", Body: "not in docs"},
+		),
+	})
+	r := newTestRouter()
+
+	w := get(t, r, query("/zip", "link", origin.link("/a.tar"), "path", "/docs"))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("status %d, Content-Type %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if _, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition")); err != nil || params["filename"] != "docs.zip" {
+		t.Errorf("Content-Disposition = %q", w.Header().Get("Content-Disposition"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[f.Name] = string(data)
+	}
+	if want := map[string]string{"docs/a.txt": "alpha", "docs/sub/b.txt": "beta"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("zip contents = %v, want %v", got, want)
+	}
+}
+
+func TestListPrefetchNextPage(t *testing.T) {
+	setupConf(t)
+	listCache = NewListCache(16, time.Minute)
+	listPrefetch = NewListPrefetcher(1, time.Minute)
+	defer listPrefetch.Close()
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "1.txt", Body: "1"}, testEntry{Name: "2.txt", Body: "2"},
+			testEntry{Name: "3.txt", Body: "3"}, testEntry{Name: "4.txt", Body: "4"},
+			testEntry{Name: "5.txt", Body: "5"},
+		),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	first := getData[ListResp](t, r, query("/list", "link", link, "per_page", "2", "prefetch", "true"))
+	if got := names(first.Content); !reflect.DeepEqual(got, []string{"1.txt", "2.txt"}) {
+		t.Fatalf("page 1 = %q", got)
+	}
+	// 预取结束时释放名额
+	deadline := time.Now().Add(5 * time.Second)
+	for len(listPrefetch.slots) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("prefetch did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	origin.requests.Store(0)
+	second := getData[ListResp](t, r, query("/list", "link", link, "per_page", "2", "page", "2", "prefetch", "true"))
+	if got := names(second.Content); !reflect.DeepEqual(got, []string{"3.txt", "4.txt"}) {
+		t.Errorf("page 2 = %q", got)
+	}
+	if n := origin.requests.Load(); n != 0 {
+		t.Errorf("cached page 2 made %d upstream requests", n)
+	}
+}
+
+func TestDownAutoNest(t *testing.T) {
+	setupConf(t)
+	inner := tarBytes(t, testEntry{Name: "dir/file.txt", Body: "nested"})
+	twice := tarBytes(t, testEntry{Name: "inner.tar", Body: string(inner)})
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "bundle/data.tar", Body: string(inner)},
+			testEntry{Name: "data.tar.gz", Body: string(gzipBytes(t, inner))},
+			testEntry{Name: "outer.tar", Body: string(twice)},
+		),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	for _, p := range []string{"/bundle/data.tar/dir/file.txt", "/data.tar.gz/dir/file.txt"} {
+		w := get(t, r, query("/down", "link", link, "path", p, "auto_nest", "true"))
+		if w.Code != 200 || w.Body.String() != "nested" {
+			t.Errorf("%s: status %d, body %q", p, w.Code, w.Body.String())
+		}
+		if code := getCode(t, r, query("/down", "link", link, "path", p)); code != 404 {
+			t.Errorf("%s without auto_nest: code = %d, want 404", p, code)
+		}
+	}
+	// 只进入一层
+	if code := getCode(t, r, query("/down", "link", link, "path", "/outer.tar/inner.tar/dir/file.txt", "auto_nest", "true")); code != 404 {
+		t.Errorf("two levels: code = %d, want 404", code)
+	}
+}
+
+func TestListPerPageClamp(t *testing.T) {
+	setupConf(t)
+	conf.DefaultPerPage, conf.MaxPerPage = 2, 3
+	var entries []testEntry
+	for i := 0; i < 5; i++ {
+		entries = append(entries, testEntry{Name: fmt.Sprintf("%d.txt", i), Body: "x"})
+	}
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, entries...)})
+	r := newTestRouter()
+
+	for perPage, want := range map[string]int{"0": 2, "1": 1, "3": 3, "100000000": 3} {
+		list := getData[ListResp](t, r, query("/list", "link", origin.link("/a.zip"), "per_page", perPage))
+		if len(list.Content) != want || list.Total != 5 {
+			t.Errorf("per_page %q: %d entries, total %d, want %d entries, total 5", perPage, len(list.Content), list.Total, want)
+		}
+	}
+}
+
+func TestAbsoluteEntryPaths(t *testing.T) {
+	setupConf(t)
+	entries := []testEntry{{Name: "/etc/config", Body: "key=value"}, {Name: "readme.txt", Body: "r"}}
+	origin := newTestOrigin(t, map[string][]byte{
+		"/abs.zip": zipBytes(t, entries...),
+		"/abs.tar": tarBytes(t, entries...),
+	})
+	r := newTestRouter()
+
+	for _, link := range []string{"/abs.zip", "/abs.tar"} {
+		list := getData[ListResp](t, r, query("/list", "link", origin.link(link), "path", "/etc"))
+		if got := names(list.Content); !reflect.DeepEqual(got, []string{"etc/config"}) {
+			t.Errorf("%s: /etc entries = %q", link, got)
+		}
+		list = getData[ListResp](t, r, query("/list", "link", origin.link(link)))
+		got := names(list.Content)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, []string{"etc/", "readme.txt"}) {
+			t.Errorf("%s: root entries = %q", link, got)
+		}
+		w := get(t, r, query("/down", "link", origin.link(link), "path", "/etc/config"))
+		if w.Code != 200 || w.Body.String() != "key=value" {
+			t.Errorf("%s: down: status %d, body %q", link, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPaginationBoundaries(t *testing.T) {
+	setupConf(t)
+	conf.DefaultPerPage, conf.MaxPerPage = 2, 100
+	objs := []int{1, 2, 3, 4}
+	for _, tc := range []struct {
+		page, perPage int
+		want          []int
+	}{
+		{0, 0, []int{1, 2}},
+		{-1, 2, []int{1, 2}},
+		{1, -3, []int{1, 2}},
+		{2, 2, []int{3, 4}},
+		// 最后一页之后的页都为空
+		{3, 2, []int{}},
+		{4, 2, []int{}},
+		{2, 3, []int{4}},
+		{1 << 62, 1 << 62, []int{}},
+	} {
+		total, page := pagination(objs, &PageReq{Page: tc.page, PerPage: tc.perPage})
+		if total != len(objs) || !reflect.DeepEqual(page, tc.want) {
+			t.Errorf("page %d, per_page %d: total %d, page %v, want total %d, page %v", tc.page, tc.perPage, total, page, len(objs), tc.want)
+		}
+	}
+	if total, page := pagination([]int{}, &PageReq{Page: 1}); total != 0 || len(page) != 0 {
+		t.Errorf("empty: total %d, page %v", total, page)
+	}
+}
+
+func TestDownHead(t *testing.T) {
+	setupConf(t)
+	body := strings.Repeat("head ", 100)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: body}, testEntry{Name: "stored.png", Body: "\x89PNG\r\n\x1a\n", Stored: true}),
+	})
+	r := newTestRouter()
+
+	for name, want := range map[string]struct {
+		size        int
+		contentType string
+		ranges      string
+	}{
+		"/a.txt":      {len(body), "text/plain; charset=utf-8", "none"},
+		"/stored.png": {8, "image/png", "bytes"},
+	} {
+		req := httptest.NewRequest(http.MethodHead, query("/down", "link", origin.link("/a.zip"), "path", name), nil)
+		w := doRequest(t, r, req)
+		if w.Code != 200 || w.Body.Len() != 0 {
+			t.Errorf("%s: status %d, %d body bytes", name, w.Code, w.Body.Len())
+		}
+		h := w.Header()
+		if h.Get("Content-Length") != strconv.Itoa(want.size) || h.Get("Content-Type") != want.contentType || h.Get("Accept-Ranges") != want.ranges {
+			t.Errorf("%s: Content-Length %q, Content-Type %q, Accept-Ranges %q, want %d %q %q", name,
+				h.Get("Content-Length"), h.Get("Content-Type"), h.Get("Accept-Ranges"), want.size, want.contentType, want.ranges)
+		}
+	}
+}
+
+func TestStreamHeadSkipsOpen(t *testing.T) {
+	setupConf(t)
+	for _, tc := range []struct {
+		name, body  string
+		sniff       bool
+		contentType string
+		opened      int
+	}{
+		{"a.txt", "hello", false, "text/plain; charset=utf-8", 0},
+		// 识别 Content-Type 时只为读取文件开头打开一次
+		{"a.txt", "\x89PNG\r\n\x1a\n", true, "image/png", 1},
+		{"image", "\x89PNG\r\n\x1a\n", false, "image/png", 1},
+	} {
+		f := memFile(t, tc.name, tc.body)
+		open, opened := f.Open, 0
+		f.Open = func() (io.ReadCloser, error) {
+			opened++
+			return open()
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodHead, "/down", nil)
+		SuccessStreamResp(c, f, tc.sniff, "", "attachment")
+
+		if w.Code != 200 || w.Body.Len() != 0 || opened != tc.opened {
+			t.Errorf("%s sniff=%v: status %d, %d body bytes, opened %d times, want %d", tc.name, tc.sniff, w.Code, w.Body.Len(), opened, tc.opened)
+		}
+		if got := w.Header().Get("Content-Type"); got != tc.contentType {
+			t.Errorf("%s sniff=%v: Content-Type = %q, want %q", tc.name, tc.sniff, got, tc.contentType)
+		}
+		if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(tc.body)) {
+			t.Errorf("%s sniff=%v: Content-Length = %q, want %d", tc.name, tc.sniff, got, len(tc.body))
+		}
+	}
+}
+
+func TestDownHeadBodyCache(t *testing.T) {
+	setupConf(t)
+	var err error
+	if bodyCache, err = NewBodyCache(t.TempDir(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	defer bodyCache.Close()
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	r := newTestRouter()
+	target := query("/down", "link", origin.link("/a.zip"), "path", "/a.txt")
+
+	if w := doRequest(t, r, httptest.NewRequest(http.MethodHead, target, nil)); w.Code != 200 || w.Body.Len() != 0 {
+		t.Fatalf("head: status %d, %d body bytes", w.Code, w.Body.Len())
+	}
+	if n := len(bodyCache.items); n != 0 {
+		t.Errorf("head cached %d entries", n)
+	}
+	// 之后的 GET 仍然解压并写入缓存, 缓存的文件在 HEAD 中支持 Range
+	if w := get(t, r, target); w.Code != 200 || w.Body.String() != "alpha" {
+		t.Fatalf("get: status %d, body %q", w.Code, w.Body.String())
+	}
+	w := doRequest(t, r, httptest.NewRequest(http.MethodHead, target, nil))
+	if n := len(bodyCache.items); n != 1 || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("cached entries %d, Accept-Ranges %q, want 1 bytes", n, w.Header().Get("Accept-Ranges"))
+	}
+}
+
+func TestDownConditional(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"}, testEntry{Name: "b.txt", Body: "beta"}),
+	})
+	r := newTestRouter()
+	target := query("/down", "link", origin.link("/a.zip"), "path", "/a.txt")
+
+	w := get(t, r, target)
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if w.Code != 200 || etag == "" || lastModified != testModTime.Format(http.TimeFormat) {
+		t.Fatalf("status %d, ETag %q, Last-Modified %q", w.Code, etag, lastModified)
+	}
+	if again := get(t, r, target).Header().Get("ETag"); again != etag {
+		t.Errorf("ETag changed between requests: %q, %q", etag, again)
+	}
+	if other := get(t, r, query("/down", "link", origin.link("/a.zip"), "path", "/b.txt")).Header().Get("ETag"); other == etag {
+		t.Error("different entries should have different ETags")
+	}
+
+	for _, tc := range []struct {
+		header []string
+		status int
+	}{
+		{[]string{"If-None-Match", etag}, 304},
+		{[]string{"If-None-Match", `"other", ` + etag}, 304},
+		{[]string{"If-None-Match", `"other"`}, 200},
+		{[]string{"If-Modified-Since", lastModified}, 304},
+		{[]string{"If-Modified-Since", testModTime.Add(-time.Hour).Format(http.TimeFormat)}, 200},
+	} {
+		w := get(t, r, target, tc.header...)
+		if w.Code != tc.status {
+			t.Errorf("%v: status %d, want %d", tc.header, w.Code, tc.status)
+		}
+		if tc.status == 304 && w.Body.Len() != 0 {
+			t.Errorf("%v: 304 with body %q", tc.header, w.Body.String())
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "docs/"}, testEntry{Name: "docs/a.txt", Body: "alpha"}, testEntry{Name: "img/b.png", Body: "png"}),
+	})
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		path, entryType, size, contentType string
+		status                             int
+	}{
+		{"/docs/a.txt", "file", "5", "text/plain; charset=utf-8", 200},
+		{"/docs", "dir", "", "", 200},
+		// 没有目录条目的目录
+		{"/img", "dir", "", "", 200},
+		{"/docs/missing.txt", "", "", "", 404},
+	} {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			w := doRequest(t, r, httptest.NewRequest(method, query("/exists", "link", origin.link("/a.zip"), "path", tc.path), nil))
+			h := w.Header()
+			if w.Code != tc.status || w.Body.Len() != 0 {
+				t.Errorf("%s %s: status %d, %d body bytes, want %d", method, tc.path, w.Code, w.Body.Len(), tc.status)
+			}
+			if h.Get("X-Entry-Type") != tc.entryType || h.Get("X-Entry-Size") != tc.size || (tc.contentType != "" && h.Get("Content-Type") != tc.contentType) {
+				t.Errorf("%s %s: type %q, size %q, Content-Type %q", method, tc.path, h.Get("X-Entry-Type"), h.Get("X-Entry-Size"), h.Get("Content-Type"))
+			}
+		}
+	}
+}
+
+func TestDownIfRange(t *testing.T) {
+	setupConf(t)
+	body := plaintext(100)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: body, Stored: true}),
+	})
+	r := newTestRouter()
+	target := query("/down", "link", origin.link("/a.zip"), "path", "/a.txt")
+	etag := get(t, r, target).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	for _, tc := range []struct {
+		ifRange string
+		partial bool
+	}{
+		{etag, true},
+		{testModTime.Format(http.TimeFormat), true},
+		{`"stale"`, false},
+		{"W/" + etag, false},
+		{testModTime.Add(time.Hour).Format(http.TimeFormat), false},
+	} {
+		w := get(t, r, target, "Range", "bytes=9-17", "If-Range", tc.ifRange)
+		switch {
+		case tc.partial && (w.Code != 206 || w.Body.String() != body[9:18]):
+			t.Errorf("If-Range %s: status %d, body %q, want the range", tc.ifRange, w.Code, w.Body.String())
+		case !tc.partial && (w.Code != 200 || w.Body.String() != body):
+			t.Errorf("If-Range %s: status %d, %d bytes, want the full body", tc.ifRange, w.Code, w.Body.Len())
+		}
+	}
+}
+
+func TestListSizeWindow(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "empty.txt"},
+			testEntry{Name: "ten.txt", Body: strings.Repeat("x", 10)},
+			testEntry{Name: "dir/"},
+			testEntry{Name: "dir/eleven.txt", Body: strings.Repeat("x", 11)},
+			testEntry{Name: "dir/hundred.txt", Body: strings.Repeat("x", 100)},
+		),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		kv   []string
+		want []string
+	}{
+		// 边界值包含在范围内, 目录不受限制
+		{[]string{"min_size", "10", "max_size", "11"}, []string{"dir/", "dir/eleven.txt", "ten.txt"}},
+		{[]string{"min_size", "11"}, []string{"dir/", "dir/eleven.txt", "dir/hundred.txt"}},
+		{[]string{"max_size", "0"}, []string{"dir/", "empty.txt"}},
+		{[]string{"min_size", "101"}, []string{"dir/"}},
+	} {
+		for _, endpoint := range []string{"/list", "/search"} {
+			base := []string{"link", link, "cascade", "true"}
+			if endpoint == "/search" {
+				base = []string{"link", link, "query", "*"}
+			}
+			resp := getData[ListResp](t, r, query(endpoint, append(base, tc.kv...)...))
+			got := names(resp.Content)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) || resp.Total != int64(len(tc.want)) {
+				t.Errorf("%s %v: entries %q, total %d, want %q", endpoint, tc.kv, got, resp.Total, tc.want)
+			}
+		}
+	}
+
+	for _, kv := range [][]string{{"min_size", "-1"}, {"min_size", "5", "max_size", "4"}} {
+		if code := getCode(t, r, query("/list", append([]string{"link", link}, kv...)...)); code != 400 {
+			t.Errorf("%v: code = %d, want 400", kv, code)
+		}
+	}
+}
+
+func TestDownRangeNotSatisfiable(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "stored.txt", Body: "alpha", Stored: true}, testEntry{Name: "deflated.txt", Body: "alpha"}),
+	})
+	r := newTestRouter()
+
+	for _, name := range []string{"/stored.txt", "/deflated.txt"} {
+		for _, rangeHeader := range []string{"bytes=99999999-", "bytes=5-10", "bytes=5-,7-"} {
+			w := get(t, r, query("/down", "link", origin.link("/a.zip"), "path", name), "Range", rangeHeader)
+			if w.Code != 416 || w.Header().Get("Content-Range") != "bytes */5" || w.Body.Len() != 0 {
+				t.Errorf("%s %s: status %d, Content-Range %q, body %q", name, rangeHeader, w.Code, w.Header().Get("Content-Range"), w.Body.String())
+			}
+		}
+	}
+}
+
+// ntfsExtra 构造记录了修改, 访问和创建时间的 NTFS 扩展字段
+func ntfsExtra(modified, created time.Time) []byte {
+	filetime := func(t time.Time) uint64 { return uint64(t.UnixNano()/100) + 116444736000000000 }
+	extra := binary.LittleEndian.AppendUint16(nil, 0x000a)
+	extra = binary.LittleEndian.AppendUint16(extra, 32)
+	extra = append(extra, 0, 0, 0, 0)
+	extra = binary.LittleEndian.AppendUint16(extra, 1)
+	extra = binary.LittleEndian.AppendUint16(extra, 24)
+	for _, t := range []time.Time{modified, modified, created} {
+		extra = binary.LittleEndian.AppendUint64(extra, filetime(t))
+	}
+	return extra
+}
+
+func TestListCreatedTime(t *testing.T) {
+	setupConf(t)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "ntfs.txt", Method: zip.Deflate, Modified: testModTime, Extra: ntfsExtra(testModTime, created)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "windows"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	origin := newTestOrigin(t, map[string][]byte{
+		"/ntfs.zip":  buf.Bytes(),
+		"/plain.zip": zipBytes(t, testEntry{Name: "plain.txt", Body: "plain"}),
+	})
+	r := newTestRouter()
+
+	list := getData[ListResp](t, r, query("/list", "link", origin.link("/ntfs.zip")))
+	if obj := list.Content[0]; !obj.CreatedKnown || !obj.Created.Equal(created) || !obj.Modified.Equal(testModTime) {
+		t.Errorf("ntfs: created %v (known %v), modified %v", obj.Created, obj.CreatedKnown, obj.Modified)
+	}
+	list = getData[ListResp](t, r, query("/list", "link", origin.link("/plain.zip")))
+	if obj := list.Content[0]; obj.CreatedKnown || !obj.Created.IsZero() {
+		t.Errorf("plain: created %v (known %v), want zero", obj.Created, obj.CreatedKnown)
+	}
+}
+
+func TestHanderReqPath(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		isDir bool
+		want  string
+	}{
+		{"", false, "/"},
+		{"/", true, "/"},
+		{"a/b.txt", false, "/a/b.txt"},
+		{"/a//b/./c.txt", false, "/a/b/c.txt"},
+		{"/a/b", true, "/a/b/"},
+		{`\a\b.txt`, false, "/a/b.txt"},
+	} {
+		if got, err := handerReqPath(tc.path, tc.isDir, false); err != nil || got != tc.want {
+			t.Errorf("handerReqPath(%q, %v) = %q, %v, want %q", tc.path, tc.isDir, got, err, tc.want)
+		}
+	}
+	// 不要求解码时百分号编码按原样保留
+	for path, want := range map[string]string{"/a%20b.txt": "/a%20b.txt", "/100%.txt": "/100%.txt"} {
+		if got, err := handerReqPath(path, false, false); err != nil || got != want {
+			t.Errorf("handerReqPath(%q) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+	for _, path := range []string{"..", "/../../etc", "/a/../b", "../a", `..\etc`, `\a\..\..\etc`, "/%2e%2e/etc", "/a/%2E%2E%2fb", `%2e%2e%5cetc`} {
+		for _, encoded := range []bool{false, true} {
+			if got, err := handerReqPath(path, false, encoded); err != ErrRelativePath {
+				t.Errorf("handerReqPath(%q, encoded %v) = %q, %v, want ErrRelativePath", path, encoded, got, err)
+			}
+		}
+	}
+}
+
+func TestDownPathTraversal(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "b.txt", Body: "beta"})})
+	r := newTestRouter()
+
+	for _, path := range []string{"/../b.txt", "/a/../b.txt", `\..\b.txt`, "/%2e%2e/b.txt"} {
+		resp := decodeResp[json.RawMessage](t, get(t, r, query("/down", "link", origin.link("/a.zip"), "path", path)))
+		if resp.Code != 400 || resp.Message != ErrRelativePath.Error() {
+			t.Errorf("%s: code %d, message %q, want 400", path, resp.Code, resp.Message)
+		}
+	}
+}
+
+func TestPercentEncodedPath(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "my folder/a+b.txt", Body: "plus"},
+			testEntry{Name: "100%.txt", Body: "percent"},
+			testEntry{Name: "a%20b.txt", Body: "literal"},
+		),
+	})
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		path    string
+		encoded string
+		body    string
+	}{
+		{"/my%20folder/a%2Bb.txt", "true", "plus"},
+		{"/my folder/a+b.txt", "true", "plus"},
+		{"/my folder/a+b.txt", "", "plus"},
+		// 不是合法的编码时按原样匹配
+		{"/100%.txt", "true", "percent"},
+		// 参数已经解码过, 默认不再解码, 文件名中的 %20 按原样匹配
+		{"/a%20b.txt", "", "literal"},
+		{"/a%2520b.txt", "true", "literal"},
+	} {
+		w := get(t, r, query("/down", "link", origin.link("/a.zip"), "path", tc.path, "path_encoded", tc.encoded))
+		if w.Code != 200 || w.Body.String() != tc.body {
+			t.Errorf("%s (path_encoded=%s): status %d, body %q, want %q", tc.path, tc.encoded, w.Code, w.Body.String(), tc.body)
+		}
+	}
+	// 从 query string 中只解码一次: %2520 得到文件名中的 %20
+	w := get(t, r, "/down?link="+url.QueryEscape(origin.link("/a.zip"))+"&path=/a%2520b.txt")
+	if w.Code != 200 || w.Body.String() != "literal" {
+		t.Errorf("raw /a%%2520b.txt: status %d, body %q, want %q", w.Code, w.Body.String(), "literal")
+	}
+	list := getData[ListResp](t, r, query("/list", "link", origin.link("/a.zip"), "path", "/my%20folder", "path_encoded", "true"))
+	if want := []string{"my folder/a+b.txt"}; !reflect.DeepEqual(names(list.Content), want) {
+		t.Errorf("list encoded dir: %v, want %v", names(list.Content), want)
+	}
+	for _, path := range []string{"/%2e%2e%2fetc", "/my%20folder/%2E%2E/%2e%2e/a.txt"} {
+		resp := decodeResp[json.RawMessage](t, get(t, r, query("/get", "link", origin.link("/a.zip"), "path", path)))
+		if resp.Code != 400 || resp.Message != ErrRelativePath.Error() {
+			t.Errorf("%s: code %d, message %q, want 400", path, resp.Code, resp.Message)
+		}
+	}
+}
+
+func TestCompressedTar(t *testing.T) {
+	setupConf(t)
+	tarball := tarBytes(t, testEntry{Name: "a.txt", Body: "alpha"}, testEntry{Name: "dir/b.txt", Body: "beta"})
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.tar.gz":  gzipBytes(t, tarball),
+		"/a.tgz":     gzipBytes(t, tarball),
+		"/a.tar.bz2": compressBytes(t, stdArchiever.Bz2{}, tarball),
+		"/a.tar.zst": compressBytes(t, stdArchiever.Zstd{}, tarball),
+	})
+	r := newTestRouter()
+
+	for _, link := range []string{"/a.tar.gz", "/a.tgz", "/a.tar.bz2", "/a.tar.zst"} {
+		list := getData[ListResp](t, r, query("/list", "link", origin.link(link), "cascade", "true"))
+		if got, want := names(list.Content), []string{"a.txt", "dir/b.txt"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: entries = %v, want %v", link, got, want)
+		}
+		// 遍历结束后下载文件时从头重新扫描解压
+		for path, body := range map[string]string{"/a.txt": "alpha", "/dir/b.txt": "beta"} {
+			w := get(t, r, query("/down", "link", origin.link(link), "path", path))
+			if w.Code != 200 || w.Body.String() != body {
+				t.Errorf("%s%s: status %d, body %q", link, path, w.Code, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestListSizeAggregate(t *testing.T) {
+	setupConf(t)
+	data := zipBytes(t,
+		testEntry{Name: "docs/a.txt", Body: "alpha"},
+		testEntry{Name: "docs/b.txt", Body: "0123456789"},
+		testEntry{Name: "docs/sub/c.txt", Body: strings.Repeat("c", 100)},
+		testEntry{Name: "other.txt", Body: "other"},
+	)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": data})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		kv        []string
+		totalSize int64
+		dirSize   int64 // -1 表示不返回
+	}{
+		{[]string{"path", "/docs"}, 15, -1},
+		{[]string{"path", "/docs", "with_size", "true"}, 15, 115},
+		{[]string{"path", "/docs", "with_size", "true", "per_page", "1"}, 5, 115},
+		{[]string{"path", "/docs", "with_size", "true", "cascade", "true"}, 115, 115},
+		{[]string{"with_size", "true"}, 5, 120},
+	} {
+		list := getData[ListResp](t, r, query("/list", append([]string{"link", link}, tc.kv...)...))
+		if list.TotalSize != tc.totalSize {
+			t.Errorf("%v: total_size = %d, want %d", tc.kv, list.TotalSize, tc.totalSize)
+		}
+		if (list.DirSize == nil) != (tc.dirSize < 0) || list.DirSize != nil && *list.DirSize != tc.dirSize {
+			t.Errorf("%v: dir_size = %v, want %d", tc.kv, list.DirSize, tc.dirSize)
+		}
+	}
+
+	// 取消后停止统计
+	arc, err := getBytesArchive("a.zip", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dirSize(ctx, arc, "/"); !errors.Is(err, context.Canceled) {
+		t.Errorf("dirSize with cancelled context: err = %v, want context.Canceled", err)
+	}
+}
+
+func TestListCascadeDepth(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "docs/"},
+			testEntry{Name: "docs/a.txt", Body: "a"},
+			testEntry{Name: "docs/sub/"},
+			testEntry{Name: "docs/sub/b.txt", Body: "b"},
+			testEntry{Name: "docs/sub/deep/"},
+			testEntry{Name: "docs/sub/deep/c.txt", Body: "c"},
+		),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	plain := getData[ListResp](t, r, query("/list", "link", link, "path", "/docs", "per_page", "100"))
+	for depth, want := range map[string][]string{
+		"0": {"docs/"},
+		"1": names(plain.Content),
+		"2": {"docs/", "docs/a.txt", "docs/sub/", "docs/sub/b.txt", "docs/sub/deep/"},
+	} {
+		list := getData[ListResp](t, r, query("/list", "link", link, "path", "/docs", "cascade", "true", "depth", depth, "per_page", "100"))
+		if got := names(list.Content); !reflect.DeepEqual(got, want) {
+			t.Errorf("depth %s: entries = %v, want %v", depth, got, want)
+		}
+	}
+	if code := getCode(t, r, query("/list", "link", link, "cascade", "true", "depth", "-1")); code != 400 {
+		t.Errorf("negative depth: code = %d, want 400", code)
+	}
+}
+
+func TestMaxDecompressedBytes(t *testing.T) {
+	setupConf(t)
+	conf.MaxDecompressedBytes = 64 << 10
+	bomb := strings.Repeat("\x00", 1<<20)
+	part := strings.Repeat("p", 40<<10)
+	data := zipBytes(t,
+		testEntry{Name: "bomb.bin", Body: bomb},
+		testEntry{Name: "small.txt", Body: "small"},
+		testEntry{Name: "parts/a.txt", Body: part},
+		testEntry{Name: "parts/b.txt", Body: part},
+	)
+	if len(data) > 32<<10 {
+		t.Fatalf("fixture should compress well, got %d bytes", len(data))
+	}
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": data})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	resp := decodeResp[json.RawMessage](t, get(t, r, query("/down", "link", link, "path", "/bomb.bin")))
+	if resp.Code != 413 || !strings.HasPrefix(resp.Message, archiver.ErrDecompressedLimit.Error()) {
+		t.Errorf("bomb: code %d, message %q, want 413", resp.Code, resp.Message)
+	}
+	if w := get(t, r, query("/down", "link", link, "path", "/small.txt")); w.Code != 200 || w.Body.String() != "small" {
+		t.Errorf("small: status %d, body %q", w.Code, w.Body.String())
+	}
+
+	// 单个文件没有超过上限, 但一次请求累计超过上限
+	w := get(t, r, query("/zip", "link", link, "path", "/parts"))
+	if zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len())); err == nil && len(zr.File) == 2 {
+		t.Errorf("zip of parts should be aborted, got %d bytes", w.Body.Len())
+	}
+	var hashErr string
+	for _, ev := range parseSSE(get(t, r, query("/hash", "link", link, "path", "/parts")).Body.String()) {
+		if ev.Event == "error" {
+			hashErr = ev.Data
+		}
+	}
+	if !strings.Contains(hashErr, `"code":413`) {
+		t.Errorf("hash of parts: error event %q, want code 413", hashErr)
+	}
+}
+
+func TestListTypeFilter(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "b.txt", Body: "b"},
+			testEntry{Name: "docs/"},
+			testEntry{Name: "docs/a.txt", Body: "a"},
+			testEntry{Name: "docs/sub/"},
+			testEntry{Name: "assets/"},
+			testEntry{Name: "a.txt", Body: "a"},
+		),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		kv    []string
+		want  []string
+		total int64
+	}{
+		{[]string{"type", "dir", "sort", "name"}, []string{"assets/", "docs/"}, 2},
+		{[]string{"type", "file", "sort", "name"}, []string{"a.txt", "b.txt"}, 2},
+		{[]string{"type", "dir", "cascade", "true", "sort", "name"}, []string{"assets/", "docs/", "docs/sub/"}, 3},
+		// 在分页之前过滤, total 为过滤后的数量
+		{[]string{"type", "dir", "cascade", "true", "sort", "name", "per_page", "2", "page", "2"}, []string{"docs/sub/"}, 3},
+	} {
+		list := getData[ListResp](t, r, query("/list", append([]string{"link", link}, tc.kv...)...))
+		if got := names(list.Content); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: entries = %v, want %v", tc.kv, got, tc.want)
+		}
+		for _, obj := range list.Content {
+			if want := tc.kv[1] == "dir"; obj.IsDir != want {
+				t.Errorf("%v: %s is_dir = %v", tc.kv, obj.NameInArchive, obj.IsDir)
+			}
+		}
+		if list.Total != tc.total {
+			t.Errorf("%v: total = %d, want %d", tc.kv, list.Total, tc.total)
+		}
+	}
+	if code := getCode(t, r, query("/list", "link", link, "type", "link")); code != 400 {
+		t.Errorf("unknown type: code = %d, want 400", code)
+	}
+}
+
+func TestListExtFilter(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "cover.JPG", Body: "j"},
+			testEntry{Name: "notes.txt", Body: "n"},
+			testEntry{Name: "photo.png", Body: "p"},
+			testEntry{Name: "album/"},
+			testEntry{Name: "album/pic.webp", Body: "w"},
+			testEntry{Name: "archive.png.zip", Body: "z"},
+		),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		kv   []string
+		want []string
+	}{
+		// 不区分大小写, 目录保留
+		{[]string{"ext", "jpg", "sort", "name"}, []string{"album/", "cover.JPG"}},
+		{[]string{"ext", "jpg,.PNG, webp", "sort", "name", "type", "file", "cascade", "true"}, []string{"album/pic.webp", "cover.JPG", "photo.png"}},
+		{[]string{"ext", "png,webp", "sort", "name", "type", "file", "cascade", "true", "per_page", "1", "page", "2"}, []string{"photo.png"}},
+	} {
+		list := getData[ListResp](t, r, query("/list", append([]string{"link", link}, tc.kv...)...))
+		if got := names(list.Content); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: entries = %v, want %v", tc.kv, got, tc.want)
+		}
+	}
+}
+
+func TestListModeAndSymlink(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.tar": tarBytes(t,
+			testEntry{Name: "bin/"},
+			testEntry{Name: "bin/run.sh", Body: "#!/bin/sh\n", Mode: 0o755},
+			testEntry{Name: "bin/secret", Body: "s", Mode: 0o600},
+			testEntry{Name: "bin/readme.txt", Body: "r"},
+			testEntry{Name: "bin/latest", Symlink: "run.sh"},
+		),
+	})
+	r := newTestRouter()
+
+	list := getData[ListResp](t, r, query("/list", "link", origin.link("/a.tar"), "cascade", "true"))
+	type entry struct {
+		mode       string
+		symlink    bool
+		linkTarget string
+	}
+	got := map[string]entry{}
+	for _, obj := range list.Content {
+		got[obj.NameInArchive] = entry{obj.Mode, obj.IsSymlink, obj.LinkTarget}
+	}
+	want := map[string]entry{
+		"bin/":           {"0755", false, ""},
+		"bin/run.sh":     {"0755", false, ""},
+		"bin/secret":     {"0600", false, ""},
+		"bin/readme.txt": {"0644", false, ""},
+		"bin/latest":     {"0777", true, "run.sh"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+}
+
+func TestResolveSymlinks(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.tar": tarBytes(t,
+			testEntry{Name: "releases/v2/"},
+			testEntry{Name: "releases/v2/config.json", Body: `{"v":2}`},
+			testEntry{Name: "current", Symlink: "releases/v2"},
+			testEntry{Name: "config.json", Symlink: "current/config.json"},
+			testEntry{Name: "escape", Symlink: "../../etc/passwd"},
+			testEntry{Name: "absolute", Symlink: "/etc/passwd"},
+			testEntry{Name: "loop-a", Symlink: "loop-b"},
+			testEntry{Name: "loop-b", Symlink: "loop-a"},
+		),
+	})
+	link := origin.link("/a.tar")
+	r := newTestRouter()
+
+	for _, path := range []string{"/config.json", "/current/config.json"} {
+		w := get(t, r, query("/down", "link", link, "path", path, "resolve_symlinks", "true"))
+		if w.Code != 200 || w.Body.String() != `{"v":2}` {
+			t.Errorf("%s: status %d, body %q", path, w.Code, w.Body.String())
+		}
+	}
+	list := getData[ListResp](t, r, query("/list", "link", link, "path", "/current", "resolve_symlinks", "true"))
+	if len(list.Content) != 1 || list.Content[0].Name != "config.json" {
+		t.Errorf("list linked dir = %v", names(list.Content))
+	}
+
+	for path, want := range map[string]struct {
+		code    int
+		errType string
+	}{
+		"/escape":   {403, "symlink_escape"},
+		"/absolute": {403, "symlink_escape"},
+		"/loop-a":   {422, "symlink_loop"},
+	} {
+		resp := decodeResp[json.RawMessage](t, get(t, r, query("/down", "link", link, "path", path, "resolve_symlinks", "true")))
+		if resp.Code != want.code || resp.ErrorType != want.errType {
+			t.Errorf("%s: code %d, error_type %q, want %d %q", path, resp.Code, resp.ErrorType, want.code, want.errType)
+		}
+	}
+	// 不解析时链接所在的目录不能浏览
+	if code := getCode(t, r, query("/down", "link", link, "path", "/current/config.json")); code != 404 {
+		t.Errorf("without resolve_symlinks: code = %d, want 404", code)
+	}
+}
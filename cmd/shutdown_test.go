@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr 返回一个当前空闲的本地地址
+func freeAddr(t testing.TB) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startServe 在后台运行 serve, 等待开始监听后返回地址和 serve 的结果
+func startServe(t testing.TB, ctx context.Context, grace time.Duration, handler http.HandlerFunc) (string, <-chan error) {
+	t.Helper()
+	addr := freeAddr(t)
+	srv := &http.Server{Addr: addr, Handler: handler}
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, srv, grace) }()
+	// 等待开始监听
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr, done
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeShutdownWaitsForActiveRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started, proceed := make(chan struct{}), make(chan struct{})
+	addr, done := startServe(t, ctx, 5*time.Second, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "first ")
+		w.(http.Flusher).Flush()
+		close(started)
+		<-proceed
+		_, _ = io.WriteString(w, "second")
+	})
+
+	type result struct {
+		body string
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resc <- result{string(body), err}
+	}()
+	<-started
+	cancel()
+
+	// 关闭时不再接受新连接, 但等待进行中的请求
+	time.Sleep(100 * time.Millisecond)
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("server should stop accepting connections after shutdown starts")
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("serve returned %v before the active request finished", err)
+	default:
+	}
+
+	close(proceed)
+	if res := <-resc; res.err != nil || res.body != "first second" {
+		t.Errorf("active request: body %q, err %v", res.body, res.err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("serve = %v, want nil", err)
+	}
+}
+
+func TestServeShutdownGracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	addr, done := startServe(t, ctx, 100*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	errc := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		errc <- err
+	}()
+	<-started
+	cancel()
+
+	// 超过宽限期后关闭仍在进行的连接
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after the grace period")
+	}
+	if err := <-errc; err == nil {
+		t.Error("request still active after the grace period should be cut off")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var signKey []byte
+
+// initSignKey 未配置签名密钥时随机生成, 重启后之前签发的令牌失效
+func initSignKey(key string) error {
+	if key != "" {
+		signKey = []byte(key)
+		return nil
+	}
+	signKey = make([]byte, 32)
+	_, err := rand.Read(signKey)
+	return err
+}
+
+func signDown(link, filePath string, expires int64) string {
+	mac := hmac.New(sha256.New, signKey)
+	mac.Write([]byte(link + "\x00" + filePath + "\x00" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyDown 校验下载令牌是否有效且未过期
+func verifyDown(link, filePath, token string, expires int64) bool {
+	if token == "" || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(signDown(link, filePath, expires)))
+}
+
+type DownTokenResp struct {
+	Token   string `json:"token"`
+	Expires int64  `json:"expires"`
+	URL     string `json:"url"`
+}
+
+// newDownToken 签发一个限时的下载令牌, 持有令牌的请求不受大小阈值限制
+func newDownToken(link, filePath string) DownTokenResp {
+	expires := time.Now().Add(conf.TokenTTL).Unix()
+	token := signDown(link, filePath, expires)
+	query := url.Values{
+		"link":    {link},
+		"path":    {filePath},
+		"token":   {token},
+		"expires": {strconv.FormatInt(expires, 10)},
+	}
+	return DownTokenResp{Token: token, Expires: expires, URL: "/down?" + query.Encode()}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownStreamThreshold(t *testing.T) {
+	setupConf(t)
+	small, large := "small", strings.Repeat("x", 100)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "small.txt", Body: small}, testEntry{Name: "large.txt", Body: large}, testEntry{Name: "large2.txt", Body: large}),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+	conf.StreamThreshold = 10
+
+	// 未超过阈值时直接返回内容
+	for _, policy := range []string{"reject", "token"} {
+		conf.StreamPolicy = policy
+		w := get(t, r, query("/down", "link", link, "path", "/small.txt"))
+		if w.Code != 200 || w.Body.String() != small {
+			t.Errorf("%s: small file: status %d, body %q", policy, w.Code, w.Body.String())
+		}
+	}
+
+	conf.StreamPolicy = "reject"
+	if code := getCode(t, r, query("/down", "link", link, "path", "/large.txt")); code != 413 {
+		t.Errorf("reject: code = %d, want 413", code)
+	}
+
+	conf.StreamPolicy = "token"
+	tok := getData[DownTokenResp](t, r, query("/down", "link", link, "path", "/large.txt"))
+	if tok.Token == "" || tok.Expires <= time.Now().Unix() || !strings.HasPrefix(tok.URL, "/down?") {
+		t.Fatalf("token response = %+v", tok)
+	}
+	w := get(t, r, tok.URL)
+	if w.Code != 200 || w.Body.String() != large {
+		t.Errorf("signed url: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+
+	// 令牌只对签发的文件有效, 过期后失效
+	for name, target := range map[string]string{
+		"other path": query("/down", "link", link, "path", "/large2.txt", "token", tok.Token, "expires", strconv.FormatInt(tok.Expires, 10)),
+		"forged":     query("/down", "link", link, "path", "/large.txt", "token", strings.Repeat("0", 64), "expires", strconv.FormatInt(tok.Expires, 10)),
+		"expired": query("/down", "link", link, "path", "/large.txt", "token", signDown(link, "/large.txt", time.Now().Unix()-1),
+			"expires", strconv.FormatInt(time.Now().Unix()-1, 10)),
+	} {
+		if data := getData[DownTokenResp](t, r, target); data.Token == "" {
+			t.Errorf("%s: want a new token instead of the content", name)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/width"
+)
+
+// checkSort 检查排序参数
+func checkSort(by, order string) error {
+	switch by {
+	case "", "name", "size", "modified":
+	default:
+		return fmt.Errorf("unknown sort %q, support name, size, modified", by)
+	}
+	switch order {
+	case "", "asc", "desc":
+	default:
+		return fmt.Errorf("unknown order %q, support asc, desc", order)
+	}
+	return nil
+}
+
+// sortObjs 按 by 排序, 相同时按路径自然排序; by 为空时保持原有顺序. dirsFirst 为 true 时目录排在文件前面
+func sortObjs(objs []ObjResp, by, order string, dirsFirst bool) {
+	if by == "" && !dirsFirst {
+		return
+	}
+	desc := order == "desc"
+	// 预先计算自然排序的键, 避免每次比较时重新解析名称
+	items := make([]sortItem, len(objs))
+	col := newNaturalCollator()
+	for i, obj := range objs {
+		items[i] = sortItem{obj: obj}
+		if by != "" {
+			items[i].key = naturalKey(col, obj.NameInArchive)
+		}
+	}
+	less := func(a, b sortItem) bool {
+		switch by {
+		case "size":
+			if a.obj.Size != b.obj.Size {
+				return a.obj.Size < b.obj.Size
+			}
+		case "modified":
+			if !a.obj.Modified.Equal(b.obj.Modified) {
+				return a.obj.Modified.Before(b.obj.Modified)
+			}
+		}
+		if c := compareNaturalKeys(a.key, b.key); c != 0 {
+			return c < 0
+		}
+		return a.obj.NameInArchive < b.obj.NameInArchive
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if dirsFirst && a.obj.IsDir != b.obj.IsDir {
+			return a.obj.IsDir
+		}
+		if by == "" {
+			return false
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	for i := range items {
+		objs[i] = items[i].obj
+	}
+}
+
+type sortItem struct {
+	obj ObjResp
+	key []naturalChunk
+}
+
+// naturalChunk 自然排序键的一段, 路径按 / 分段, 每段再分为连续的数字和非数字
+type naturalChunk struct {
+	kind chunkKind
+	// digits 去掉前导 0 的数字 (全角数字转换为半角); key 非数字部分的排序键
+	digits string
+	key    []byte
+}
+
+type chunkKind int
+
+// 同一位置上分隔符排在最前, 数字排在文字前面
+const (
+	chunkSep chunkKind = iota
+	chunkNum
+	chunkText
+)
+
+// newNaturalCollator 按 Unicode 排序规则比较文字, 忽略大小写和全半角, 带重音的字母紧跟基本字母 (e < é < f).
+// Collator 不能并发使用, 每次排序创建一个
+func newNaturalCollator() *collate.Collator {
+	return collate.New(language.Und, collate.IgnoreCase, collate.IgnoreWidth)
+}
+
+func isDigit(r rune) bool { return r >= '0' && r <= '9' || r >= '０' && r <= '９' }
+
+// naturalKey 计算名称的自然排序键, 例如 file2 < file10, docs/a < docs2
+func naturalKey(col *collate.Collator, name string) []naturalChunk {
+	var chunks []naturalChunk
+	var buf collate.Buffer
+	for i, segment := range strings.Split(name, "/") {
+		if i > 0 {
+			chunks = append(chunks, naturalChunk{kind: chunkSep})
+		}
+		rs := []rune(segment)
+		for start := 0; start < len(rs); {
+			end := start + 1
+			digit := isDigit(rs[start])
+			for end < len(rs) && isDigit(rs[end]) == digit {
+				end++
+			}
+			if digit {
+				digits := strings.TrimLeft(width.Narrow.String(string(rs[start:end])), "0")
+				chunks = append(chunks, naturalChunk{kind: chunkNum, digits: digits})
+			} else {
+				key := col.KeyFromString(&buf, string(rs[start:end]))
+				chunks = append(chunks, naturalChunk{kind: chunkText, key: append([]byte(nil), key...)})
+			}
+			start = end
+		}
+	}
+	return chunks
+}
+
+// compareNaturalKeys 比较两个自然排序键, 数字按数值比较
+func compareNaturalKeys(a, b []naturalChunk) int {
+	for k := 0; k < len(a) && k < len(b); k++ {
+		x, y := a[k], b[k]
+		if x.kind != y.kind {
+			return int(x.kind) - int(y.kind)
+		}
+		switch x.kind {
+		case chunkNum:
+			if len(x.digits) != len(y.digits) {
+				return len(x.digits) - len(y.digits)
+			}
+			if c := strings.Compare(x.digits, y.digits); c != 0 {
+				return c
+			}
+		case chunkText:
+			if c := bytes.Compare(x.key, y.key); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(a) - len(b)
+}
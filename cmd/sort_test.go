@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortObjs(t *testing.T) {
+	day := func(d int) time.Time { return testModTime.AddDate(0, 0, d) }
+	objs := []ObjResp{
+		{NameInArchive: "file10.txt", Size: 30, Modified: day(1)},
+		{NameInArchive: "docs/", IsDir: true, Modified: day(3)},
+		{NameInArchive: "file2.txt", Size: 10, Modified: day(2)},
+		{NameInArchive: "b.txt", Size: 20, Modified: day(0)},
+	}
+	for _, tc := range []struct {
+		by, order string
+		dirsFirst bool
+		want      []string
+	}{
+		{"", "", false, []string{"file10.txt", "docs/", "file2.txt", "b.txt"}},
+		{"name", "", false, []string{"b.txt", "docs/", "file2.txt", "file10.txt"}},
+		{"name", "desc", false, []string{"file10.txt", "file2.txt", "docs/", "b.txt"}},
+		{"size", "asc", false, []string{"docs/", "file2.txt", "b.txt", "file10.txt"}},
+		{"size", "desc", false, []string{"file10.txt", "b.txt", "file2.txt", "docs/"}},
+		{"modified", "asc", false, []string{"b.txt", "file10.txt", "file2.txt", "docs/"}},
+		{"modified", "desc", true, []string{"docs/", "file2.txt", "file10.txt", "b.txt"}},
+		{"", "", true, []string{"docs/", "file10.txt", "file2.txt", "b.txt"}},
+	} {
+		sorted := append([]ObjResp(nil), objs...)
+		sortObjs(sorted, tc.by, tc.order, tc.dirsFirst)
+		if got := names(sorted); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("sort %q %q dirs_first %v = %v, want %v", tc.by, tc.order, tc.dirsFirst, got, tc.want)
+		}
+	}
+}
+
+func TestListSort(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "file10.txt", Body: "1"},
+			testEntry{Name: "sub/"},
+			testEntry{Name: "file2.txt", Body: "222"},
+		),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	list := getData[ListResp](t, r, query("/list", "link", link, "sort", "name", "dirs_first", "true"))
+	if got, want := names(list.Content), []string{"sub/", "file2.txt", "file10.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+	for _, kv := range [][]string{{"sort", "type"}, {"sort", "name", "order", "up"}} {
+		if code := getCode(t, r, query("/list", append([]string{"link", link}, kv...)...)); code != 400 {
+			t.Errorf("%v: code = %d, want 400", kv, code)
+		}
+	}
+}
+
+func TestNaturalOrder(t *testing.T) {
+	col := newNaturalCollator()
+	for _, pair := range [][2]string{
+		{"img2", "img10"},
+		{"IMG2", "img10"},
+		{"img9.png", "Img10.png"},
+		{"v1.2", "v1.10"},
+		{"e.txt", "é.txt"},
+		{"é.txt", "f.txt"},
+		{"docs/a", "docs2"},
+		{"file９", "file10"},
+		{"2024", "report"},
+	} {
+		a, b := naturalKey(col, pair[0]), naturalKey(col, pair[1])
+		if compareNaturalKeys(a, b) >= 0 || compareNaturalKeys(b, a) <= 0 {
+			t.Errorf("%q should sort before %q", pair[0], pair[1])
+		}
+	}
+
+	objs := []ObjResp{{NameInArchive: "img10.jpg"}, {NameInArchive: "Img3.jpg"}, {NameInArchive: "img2.jpg"}, {NameInArchive: "img1.jpg"}}
+	sortObjs(objs, "name", "", false)
+	if got, want := names(objs), []string{"img1.jpg", "img2.jpg", "Img3.jpg", "img10.jpg"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	bufra "github.com/avvmoto/buf-readerat"
+	"github.com/gin-gonic/gin"
+	"github.com/snabb/httpreaderat"
+)
+
+// Archive 远程归档, 同时记录源站首次响应的头部
+type Archive struct {
+	*archiver.ArchiverExtractor
+	OriginHeader http.Header
+	// Size 归档大小, 未知时为 -1
+	Size int64
+	// source 归档的原始数据
+	source io.ReaderAt
+}
+
+type ArchiveMeta struct {
+	ArchiveModified *time.Time `json:"archive_modified,omitempty"`
+	ArchiveSize     *int64     `json:"archive_size,omitempty"`
+	// Format 归档格式, 例如 zip, tar.gz, 7z
+	Format string `json:"format,omitempty"`
+}
+
+// Meta 返回归档的格式, 修改时间和大小, 源站没有提供时省略修改时间和大小
+func (a *Archive) Meta() ArchiveMeta {
+	meta := ArchiveMeta{Format: a.FormatName()}
+	if t, err := http.ParseTime(a.OriginHeader.Get("Last-Modified")); err == nil {
+		meta.ArchiveModified = &t
+	}
+	if a.Size >= 0 {
+		size := a.Size
+		meta.ArchiveSize = &size
+	}
+	return meta
+}
+
+// fingerprintSampleSize 没有源站校验值时, 分别从归档开头, 中间和结尾抽样计算哈希的字节数
+const fingerprintSampleSize = 64 << 10
+
+// Fingerprint 归档的稳定指纹, 可用于多个服务之间协调缓存
+// 由源站的 ETag, 大小和修改时间计算, 源站没有提供 ETag 和修改时间时改用抽样的内容哈希
+func (a *Archive) Fingerprint() (string, error) {
+	h := sha256.New()
+	etag, lastModified := a.OriginHeader.Get("ETag"), a.OriginHeader.Get("Last-Modified")
+	if etag != "" || lastModified != "" {
+		fmt.Fprintf(h, "origin\x00%s\x00%d\x00%s", etag, a.Size, lastModified)
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	fmt.Fprintf(h, "content\x00%d\x00", a.Size)
+	for _, off := range []int64{0, a.Size/2 - fingerprintSampleSize/2, a.Size - fingerprintSampleSize} {
+		if off < 0 {
+			off = 0
+		}
+		if _, err := io.Copy(h, io.NewSectionReader(a.source, off, fingerprintSampleSize)); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ArchiveReq 归档来源, 可以是远程链接, 也可以在请求体中直接上传归档
+// (Content-Type 为 application/octet-stream 的原始数据, 或 data 字段中的 base64 数据)
+type ArchiveReq struct {
+	RawLink string `json:"link" form:"link"`
+	Data    string `json:"data" form:"data"`
+	Name    string `json:"name" form:"name"`
+	// Password 解密加密归档使用的密码
+	Password string `json:"password" form:"password"`
+	// Encoding zip 中非 UTF-8 文件名的编码
+	Encoding string `json:"encoding" form:"encoding"`
+	// Headers 额外发往源站的头部, 只能在 JSON 请求体中指定
+	Headers map[string]string `json:"headers" form:"-"`
+	// BufSize 从源站读取时缓冲的块大小, 为 0 时使用 -buf-size
+	BufSize int `json:"buf_size" form:"buf_size"`
+	// ResolveSymlinks 为 true 时 path 可以经过归档内的符号链接, 指向归档之外的链接返回 403
+	ResolveSymlinks bool `json:"resolve_symlinks" form:"resolve_symlinks"`
+	// CaseInsensitive 为 true 时 path 忽略大小写匹配归档中的文件名, 用于 Windows 下创建的归档
+	CaseInsensitive bool `json:"case_insensitive" form:"case_insensitive"`
+	// PathEncoded 为 true 时 path 在参数解码后仍是百分号编码的 (例如 /my%20folder/a%2Bb.txt), 再解码一次
+	PathEncoded bool `json:"path_encoded" form:"path_encoded"`
+	// Volumes 大于 1 时 link 为分卷归档的第一卷 (例如 a.7z.001), 共 Volumes 卷
+	Volumes int `json:"volumes" form:"volumes"`
+}
+
+var (
+	ErrNoSource     = errors.New("exactly one of link/data/body required")
+	ErrBodyTooLarge = errors.New("archive in request body is too large")
+	ErrBufSize      = fmt.Errorf("buf_size must be between 1 and %d", maxBufSize)
+	// ErrCacheFileLimit 源站不支持 Range, 且归档超过了 -max-cache-file-bytes
+	ErrCacheFileLimit = errors.New("origin does not support range requests and the archive exceeds the download limit")
+)
+
+func getArchive(c *gin.Context, req *ArchiveReq) (*Archive, error) {
+	if req.Encoding != "" {
+		if err := archiver.CheckNameEncoding(req.Encoding); err != nil {
+			return nil, err
+		}
+	}
+	start := time.Now()
+	arc, err := openArchive(c, req)
+	metrics.observeOpen(time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	configureArchive(arc, req)
+	return arc, nil
+}
+
+// configureArchive 按请求参数和配置设置归档的解码方式
+func configureArchive(arc *Archive, req *ArchiveReq) {
+	if req.Encoding != "" {
+		_ = arc.SetNameEncoding(req.Encoding)
+	}
+	arc.SetPassword(req.Password)
+	arc.SetGzipFirstMemberOnly(conf.GzipFirstMember)
+	arc.SetImplausibleSizeUnknown(conf.ImplausibleSize == "unknown")
+	arc.SetMaxBufferBytes(conf.MaxBufferBytes)
+	arc.SetMaxDecompressedBytes(conf.MaxDecompressedBytes)
+	arc.SetResolveSymlinks(req.ResolveSymlinks)
+	arc.SetCaseInsensitive(req.CaseInsensitive)
+	if metrics != nil {
+		arc.SetExtractObserver(metrics.observeExtract)
+	}
+}
+
+func openArchive(c *gin.Context, req *ArchiveReq) (*Archive, error) {
+	if err := checkSource(c, req); err != nil {
+		return nil, err
+	}
+	switch {
+	case req.Data != "":
+		if int64(base64.StdEncoding.DecodedLen(len(req.Data))) > conf.MaxBodyBytes {
+			return nil, ErrBodyTooLarge
+		}
+		data, err := base64.StdEncoding.DecodeString(req.Data)
+		if err != nil {
+			return nil, fmt.Errorf("decode data: %w", err)
+		}
+		return getBytesArchive(req.Name, data)
+	case hasRawBody(c):
+		data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, conf.MaxBodyBytes))
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return nil, ErrBodyTooLarge
+		} else if err != nil {
+			return nil, err
+		}
+		return getBytesArchive(req.Name, data)
+	}
+	if name, ok := localPath(req.RawLink); ok {
+		return getLocalArchive(c, name)
+	}
+	bufSize := conf.BufSize
+	if req.BufSize != 0 {
+		if req.BufSize < 0 || req.BufSize > maxBufSize {
+			return nil, ErrBufSize
+		}
+		bufSize = req.BufSize
+	}
+	if req.Volumes < 0 || req.Volumes > maxVolumes {
+		return nil, ErrVolumes
+	}
+	return getRemoteArchive(c, req.RawLink, req.Headers, bufSize, req.Volumes)
+}
+
+// hasRawBody 判断请求体中是否直接上传了归档
+func hasRawBody(c *gin.Context) bool {
+	return c.ContentType() == "application/octet-stream" && c.Request.ContentLength != 0
+}
+
+// checkSource 检查是否恰好提供了一个归档来源
+func checkSource(c *gin.Context, req *ArchiveReq) error {
+	sources := 0
+	for _, provided := range []bool{req.RawLink != "", req.Data != "", hasRawBody(c)} {
+		if provided {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return ErrNoSource
+	}
+	return nil
+}
+
+// getBytesArchive 从内存中的数据构造归档
+func getBytesArchive(name string, data []byte) (*Archive, error) {
+	source := bytes.NewReader(data)
+	arc, err := archiver.DetectArchive(name, io.NewSectionReader(source, 0, int64(len(data))))
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{ArchiverExtractor: arc, OriginHeader: http.Header{}, Size: int64(len(data)), source: source}, nil
+}
+
+// getRemoteArchive 打开源站上的归档, 每次按 bufSize 字节的块从源站读取.
+// volumes 大于 1 时 rawURL 为第一个分卷, 其余分卷的链接由 rawURL 末尾的序号递增得到
+func getRemoteArchive(c *gin.Context, rawURL string, headers map[string]string, bufSize, volumes int) (*Archive, error) {
+	if err := checkHeaders(headers); err != nil {
+		return nil, err
+	}
+	if volumes > 1 {
+		return getVolumesArchive(c, rawURL, headers, bufSize, volumes)
+	}
+	origin, httpReaderAtReq, err := openOrigin(c, rawURL, headers, bufSize)
+	if err != nil {
+		return nil, err
+	}
+	// 用链接的路径识别格式, 避免查询参数干扰扩展名
+	return newRemoteArchive(c, httpReaderAtReq.URL.Path, origin, bufSize)
+}
+
+// newRemoteArchive 从源站读取归档, name 用于识别格式
+func newRemoteArchive(c *gin.Context, name string, origin originSource, bufSize int) (*Archive, error) {
+	bhtrdr := bufra.NewBufReaderAt(ctxReaderAt{ctx: c.Request.Context(), ra: origin, read: upstreamBytes(c)}, bufSize)
+	arc, err := archiver.DetectArchive(name, io.NewSectionReader(bhtrdr, 0, origin.Size()))
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{ArchiverExtractor: arc, OriginHeader: origin.Header(), Size: origin.Size(), source: bhtrdr}, nil
+}
+
+// openOrigin 检查链接后打开源站上的文件, 返回发往源站的请求
+func openOrigin(c *gin.Context, rawURL string, headers map[string]string, bufSize int) (originSource, *http.Request, error) {
+	httpReaderAtReq, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	forwardHeaders(c, httpReaderAtReq, headers)
+	if id := c.GetString(requestIDKey); id != "" {
+		httpReaderAtReq.Header.Set(requestIDHeader, id)
+	}
+	if referer := originReferer(c, httpReaderAtReq.URL); referer != "" {
+		httpReaderAtReq.Header.Set("Referer", referer)
+	}
+	if err := checkLink(c, httpReaderAtReq.URL); err != nil {
+		return nil, nil, err
+	}
+	c.Set(archiveLinkKey, redactURL(httpReaderAtReq.URL))
+	ctx := c.Request.Context()
+	origin, err := withContext(ctx, func() (originSource, error) {
+		var origin originSource
+		var err error
+		if indexCache != nil {
+			origin, err = indexCache.Open(httpReaderAtReq, bufSize)
+		} else {
+			origin, err = newOriginReader(httpReaderAtReq)
+		}
+		// 没有缓存的源站在请求结束后关闭, 删除可能下载的临时文件. 请求超时后才返回时立即关闭
+		if o, ok := origin.(*originReader); ok && err == nil {
+			context.AfterFunc(ctx, func() { o.Close() })
+		}
+		return origin, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return origin, httpReaderAtReq, nil
+}
+
+// maxBufSize 请求中 buf_size 的上限
+const maxBufSize = 64 << 20
+
+// originSource 源站上的归档, 以及源站首次响应的头部
+type originSource interface {
+	io.ReaderAt
+	Size() int64
+	Header() http.Header
+}
+
+// originReader 通过 Range 请求读取源站上的归档
+type originReader struct {
+	*httpreaderat.HTTPReaderAt
+	client   *http.Client
+	req      *http.Request
+	header   http.Header
+	recorder *headerRecorder
+	store    *downloadStore
+}
+
+func newOriginReader(req *http.Request) (*originReader, error) {
+	recorder := &headerRecorder{RoundTripper: originTransport}
+	// httpreaderat 包装后的错误无法用 errors.As 取出, 在这里记录
+	var redirectErr error
+	client := &http.Client{
+		Transport: recorder,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > conf.MaxRedirects {
+				redirectErr = &TooManyRedirectsError{Max: conf.MaxRedirects, URL: redactURL(req.URL)}
+				return redirectErr
+			}
+			return nil
+		},
+	}
+	// 源站不支持 Range 时把整个归档下载到临时文件
+	var store *downloadStore
+	var bs httpreaderat.Store
+	if conf.MaxCacheFileBytes > 0 {
+		store = newDownloadStore(conf.MaxCacheFileBytes, func() http.Header { return recorder.header })
+		bs = store
+	}
+	htrdr, err := httpreaderat.New(client, req, bs)
+	if errors.Is(err, httpreaderat.ErrStoreLimit) {
+		store.Close()
+		return nil, fmt.Errorf("%w of %d bytes", ErrCacheFileLimit, conf.MaxCacheFileBytes)
+	} else if err != nil && store != nil {
+		store.Close()
+	}
+	if redirectErr != nil {
+		return nil, redirectErr
+	} else if store != nil && store.err != nil {
+		return nil, store.err
+	} else if errors.Is(recorder.err, ErrLinkBlocked) {
+		return nil, recorder.err
+	} else if rle := recorder.rateLimited(); err != nil && rle != nil {
+		return nil, rle
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", archiver.ErrUpstreamUnreachable, err)
+	}
+	return &originReader{HTTPReaderAt: htrdr, client: client, req: req, header: recorder.header, recorder: recorder, store: store}, nil
+}
+
+// downloaded 源站不支持 Range, 归档已完整下载到临时文件
+func (o *originReader) downloaded() bool {
+	return o.store != nil && o.store.used
+}
+
+// Close 删除下载的临时文件
+func (o *originReader) Close() error {
+	if o.store == nil {
+		return nil
+	}
+	return o.store.Close()
+}
+
+// ReadAt 源站限流时返回 OriginRateLimitedError, 以便向客户端返回 429
+func (o *originReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := o.HTTPReaderAt.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		if rle := o.recorder.rateLimited(); rle != nil {
+			return n, rle
+		}
+		return n, fmt.Errorf("%w: %v", archiver.ErrUpstreamUnreachable, err)
+	}
+	return n, err
+}
+
+func (o *originReader) Header() http.Header {
+	return o.header
+}
+
+// TooManyRedirectsError 源站重定向次数超过 -max-redirects
+type TooManyRedirectsError struct {
+	Max int
+	// URL 最后尝试访问的地址, 已去掉用户信息和查询参数
+	URL string
+}
+
+func (e *TooManyRedirectsError) Error() string {
+	return fmt.Sprintf("too many redirects (>%d), last url %s", e.Max, e.URL)
+}
+
+// redactURL 去掉地址中可能包含凭据的用户信息和查询参数
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	redacted.RawQuery = ""
+	redacted.Fragment = ""
+	return redacted.String()
+}
+
+// checkRefererPolicy 检查 -referer 配置, 除预设策略外只接受绝对地址
+func checkRefererPolicy(policy string) error {
+	switch policy {
+	case "none", "forward", "origin":
+		return nil
+	}
+	if u, err := url.Parse(policy); err != nil || !u.IsAbs() {
+		return fmt.Errorf("%q is neither a policy nor an absolute url", policy)
+	}
+	return nil
+}
+
+// originReferer 按 -referer 配置生成发往源站的 Referer, 用于通过防盗链检查
+func originReferer(c *gin.Context, archiveURL *url.URL) string {
+	switch conf.Referer {
+	case "none":
+		return ""
+	case "forward":
+		return c.GetHeader("Referer")
+	case "origin":
+		return archiveURL.Scheme + "://" + archiveURL.Host + "/"
+	default:
+		return conf.Referer
+	}
+}
+
+// headerRecorder 记录源站第一次响应的头部 (跳过重定向) 和最近一次请求的错误
+// httpreaderat 包装后的错误无法用 errors.As 取出, 需要在这里记录
+type headerRecorder struct {
+	http.RoundTripper
+	once   sync.Once
+	header http.Header
+	mu     sync.Mutex
+	err    error
+}
+
+func (hr *headerRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := hr.RoundTripper.RoundTrip(req)
+	if err != nil {
+		hr.setErr(err)
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		hr.setErr(&OriginRateLimitedError{RetryAfter: resp.Header.Get("Retry-After")})
+		return resp, err
+	}
+	hr.setErr(nil)
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		hr.once.Do(func() { hr.header = resp.Header.Clone() })
+	}
+	return resp, err
+}
+
+func (hr *headerRecorder) setErr(err error) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	hr.err = err
+}
+
+// rateLimited 返回最近一次请求被源站限流的错误
+func (hr *headerRecorder) rateLimited() *OriginRateLimitedError {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	var rle *OriginRateLimitedError
+	if errors.As(hr.err, &rle) {
+		return rle
+	}
+	return nil
+}
+
+// OriginRateLimitedError 源站返回了 429, RetryAfter 为源站的 Retry-After
+type OriginRateLimitedError struct {
+	RetryAfter string
+}
+
+func (e *OriginRateLimitedError) Error() string {
+	if e.RetryAfter == "" {
+		return "rate limited by the origin"
+	}
+	return fmt.Sprintf("rate limited by the origin, retry after %s", e.RetryAfter)
+}
+
+// rateLimitedResp 源站限流时返回 HTTP 429, 并转发源站的 Retry-After.
+// 与 RateLimiter 相同使用真实的状态码, 客户端和代理只在 429 响应中识别 Retry-After
+func rateLimitedResp(c *gin.Context, err error) bool {
+	var rle *OriginRateLimitedError
+	if !errors.As(err, &rle) {
+		return false
+	}
+	if rle.RetryAfter != "" {
+		c.Header("Retry-After", rle.RetryAfter)
+	}
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, Resp[interface{}]{Code: http.StatusTooManyRequests, Message: rle.Error(), ErrorType: "origin_rate_limited"})
+	return true
+}
+
+// ArchiveErrorResp 根据获取归档时的错误类型返回对应的错误码
+func ArchiveErrorResp(c *gin.Context, err error) {
+	if rateLimitedResp(c, err) || typedErrorResp(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, ErrNoSource), errors.Is(err, archiver.ErrUnknownEncoding), errors.Is(err, ErrBufSize),
+		errors.Is(err, ErrVolumes), errors.Is(err, ErrVolumeLink), errors.As(err, new(*HeaderNotAllowedError)):
+		ErrorStrResp(c, err.Error(), 400)
+	case errors.Is(err, ErrVolumeSize):
+		ErrorStrResp(c, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrBodyTooLarge), errors.Is(err, ErrCacheFileLimit):
+		ErrorStrResp(c, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrLinkBlocked):
+		ErrorStrResp(c, err.Error(), http.StatusForbidden)
+	case errors.As(err, new(*TooManyRedirectsError)):
+		ErrorStrResp(c, err.Error(), http.StatusBadGateway)
+	default:
+		ErrorStrResp(c, err.Error(), 500)
+	}
+}
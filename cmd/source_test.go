@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// post 发送 POST 请求
+func post(t testing.TB, h http.Handler, target, contentType string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	return doRequest(t, h, req)
+}
+
+func TestListUploadedArchive(t *testing.T) {
+	setupConf(t)
+	data := zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"}, testEntry{Name: "dir/b.txt", Body: "beta"})
+	r := newTestRouter()
+	want := []string{"a.txt", "dir/"}
+
+	jsonBody, err := json.Marshal(map[string]string{"data": base64.StdEncoding.EncodeToString(data), "name": "a.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"base64": post(t, r, "/list", "application/json", jsonBody),
+		"raw":    post(t, r, query("/list", "name", "a.zip"), "application/octet-stream", data),
+	} {
+		resp := decodeResp[ListResp](t, w)
+		if resp.Code != 200 {
+			t.Errorf("%s: code %d: %s", name, resp.Code, resp.Message)
+			continue
+		}
+		var got []string
+		for _, obj := range resp.Data[0].Content {
+			got = append(got, obj.NameInArchive)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: entries = %v, want %v", name, got, want)
+		}
+	}
+
+	w := post(t, r, query("/down", "path", "/dir/b.txt"), "application/octet-stream", data)
+	if w.Code != 200 || w.Body.String() != "beta" {
+		t.Errorf("down from raw body: status %d, body %q", w.Code, w.Body.String())
+	}
+}
+
+func TestUploadedArchiveTooLarge(t *testing.T) {
+	setupConf(t)
+	data := zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})
+	conf.MaxBodyBytes = int64(len(data) - 1)
+	r := newTestRouter()
+
+	jsonBody, err := json.Marshal(map[string]string{"data": base64.StdEncoding.EncodeToString(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"base64": post(t, r, "/list", "application/json", jsonBody),
+		"raw":    post(t, r, "/list", "application/octet-stream", data),
+	} {
+		if code := decodeResp[json.RawMessage](t, w).Code; code != 413 {
+			t.Errorf("%s: code = %d, want 413", name, code)
+		}
+	}
+}
+
+func TestOriginReferer(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	// 防盗链的源站只接受来自自身站点的 Referer
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Referer() != origin.URL+"/" {
+			http.Error(w, "hotlinking is not allowed", http.StatusForbidden)
+			return true
+		}
+		return false
+	}
+	r := newTestRouter()
+	target := query("/list", "link", origin.link("/a.zip"))
+
+	for _, tc := range []struct {
+		policy, clientReferer string
+		ok                    bool
+	}{
+		{"none", origin.URL + "/", false},
+		{"origin", "", true},
+		{"forward", origin.URL + "/", true},
+		{"forward", "https://elsewhere.example/", false},
+		{origin.URL + "/", "", true},
+		{"https://fixed.example/", "", false},
+	} {
+		conf.Referer = tc.policy
+		var header []string
+		if tc.clientReferer != "" {
+			header = []string{"Referer", tc.clientReferer}
+		}
+		if code := getCode(t, r, target, header...); (code == 200) != tc.ok {
+			t.Errorf("policy %q, client referer %q: code %d, want success %v", tc.policy, tc.clientReferer, code, tc.ok)
+		}
+	}
+}
+
+func TestCheckRefererPolicy(t *testing.T) {
+	for policy, ok := range map[string]bool{
+		"none": true, "forward": true, "origin": true,
+		"https://example.com/page": true, "example.com": false, "bogus": false,
+	} {
+		if err := checkRefererPolicy(policy); (err == nil) != ok {
+			t.Errorf("checkRefererPolicy(%q) = %v, want ok %v", policy, err, ok)
+		}
+	}
+}
+
+func TestListSourceCount(t *testing.T) {
+	setupConf(t)
+	data := zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": data})
+	r := newTestRouter()
+	link, b64 := origin.link("/a.zip"), base64.StdEncoding.EncodeToString(data)
+
+	for name, tc := range map[string]struct {
+		w    *httptest.ResponseRecorder
+		code int
+	}{
+		"none":          {get(t, r, "/list"), 400},
+		"link":          {get(t, r, query("/list", "link", link)), 200},
+		"data":          {get(t, r, query("/list", "data", b64)), 200},
+		"link and data": {get(t, r, query("/list", "link", link, "data", b64)), 400},
+		"link and body": {post(t, r, query("/list", "link", link), "application/octet-stream", data), 400},
+	} {
+		resp := decodeResp[json.RawMessage](t, tc.w)
+		if resp.Code != tc.code {
+			t.Errorf("%s: code %d, message %q, want %d", name, resp.Code, resp.Message, tc.code)
+		}
+		if tc.code == 400 && resp.Message != ErrNoSource.Error() {
+			t.Errorf("%s: message %q, want %q", name, resp.Message, ErrNoSource)
+		}
+	}
+}
+
+func TestOriginRedirectLoop(t *testing.T) {
+	setupConf(t)
+	conf.MaxRedirects = 3
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		switch r.URL.Path {
+		case "/moved.zip":
+			http.Redirect(w, r, "/a.zip", http.StatusFound)
+		case "/loop":
+			// 查询参数中的凭据不能出现在错误信息中
+			http.Redirect(w, r, "/loop?token=secret", http.StatusFound)
+		default:
+			return false
+		}
+		return true
+	}
+	r := newTestRouter()
+
+	if code := getCode(t, r, query("/list", "link", origin.link("/moved.zip"))); code != 200 {
+		t.Errorf("single redirect: code = %d, want 200", code)
+	}
+	resp := decodeResp[json.RawMessage](t, get(t, r, query("/list", "link", origin.link("/loop"))))
+	if want := "too many redirects (>3), last url " + origin.URL + "/loop"; resp.Code != 502 || resp.Message != want {
+		t.Errorf("redirect loop: code %d, message %q, want 502 %q", resp.Code, resp.Message, want)
+	}
+}
+
+func TestOriginRateLimited(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+		return true
+	}
+	r := newTestRouter()
+
+	for _, target := range []string{
+		query("/list", "link", origin.link("/a.zip")),
+		query("/down", "link", origin.link("/a.zip"), "path", "/a.txt"),
+	} {
+		w := get(t, r, target)
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" {
+			t.Errorf("%s: status %d, Retry-After %q", target, w.Code, w.Header().Get("Retry-After"))
+		}
+		if code := decodeResp[json.RawMessage](t, w).Code; code != 429 {
+			t.Errorf("%s: code = %d, want 429", target, code)
+		}
+	}
+}
+
+func TestBufSize(t *testing.T) {
+	setupConf(t)
+	const size = 256 << 10
+	body := string(bytes.Repeat([]byte("0123456789abcdef"), size/16))
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.bin", Body: body, Stored: true})})
+	r := newTestRouter()
+	requests := func(bufSize string) int64 {
+		t.Helper()
+		origin.requests.Store(0)
+		w := get(t, r, query("/down", "link", origin.link("/a.zip"), "path", "/a.bin", "buf_size", bufSize))
+		if w.Code != 200 || w.Body.String() != body {
+			t.Fatalf("buf_size %s: status %d, %d bytes", bufSize, w.Code, w.Body.Len())
+		}
+		return origin.requests.Load()
+	}
+
+	// 每个块一次 Range 请求, 块越小请求越多
+	large, small := requests("1048576"), requests("4096")
+	if large > 4 {
+		t.Errorf("buf_size 1MiB: %d upstream requests, want at most 4", large)
+	}
+	if small < size/4096 {
+		t.Errorf("buf_size 4KiB: %d upstream requests, want at least %d", small, size/4096)
+	}
+	conf.BufSize = 4096
+	if got := requests("0"); got != small {
+		t.Errorf("-buf-size 4096: %d upstream requests, want %d", got, small)
+	}
+
+	for _, bufSize := range []string{"-1", strconv.Itoa(maxBufSize + 1)} {
+		resp := decodeResp[json.RawMessage](t, get(t, r, query("/list", "link", origin.link("/a.zip"), "buf_size", bufSize)))
+		if resp.Code != 400 || resp.Message != ErrBufSize.Error() {
+			t.Errorf("buf_size %s: code %d, message %q, want 400", bufSize, resp.Code, resp.Message)
+		}
+	}
+}
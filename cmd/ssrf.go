@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrLinkBlocked 链接指向的地址不允许访问
+var ErrLinkBlocked = errors.New("link is blocked")
+
+// cidrList 可重复指定的 -allow-cidr 参数
+type cidrList []*net.IPNet
+
+func (l *cidrList) String() string {
+	cidrs := make([]string, 0, len(*l))
+	for _, n := range *l {
+		cidrs = append(cidrs, n.String())
+	}
+	return strings.Join(cidrs, ",")
+}
+
+func (l *cidrList) Set(v string) error {
+	_, n, err := net.ParseCIDR(v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, n)
+	return nil
+}
+
+// checkIP 拒绝私有, 回环和链路本地地址, -allow-cidr 中的地址除外
+func checkIP(ip net.IP) error {
+	for _, n := range conf.AllowCIDRs {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	if conf.BlockPrivate && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()) {
+		return fmt.Errorf("%w: %s is a private address", ErrLinkBlocked, ip)
+	}
+	return nil
+}
+
+// checkLink 在请求前解析链接的主机名, 检查所有解析到的地址
+func checkLink(ctx context.Context, u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", ErrLinkBlocked, u.Scheme)
+	}
+	if !conf.BlockPrivate {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := checkIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newOriginTransport 访问源站使用的 Transport, 建立连接时再次检查地址,
+// 防止通过重定向或 DNS 重新绑定绕过 checkLink
+func newOriginTransport() http.RoundTripper {
+	if !conf.BlockPrivate {
+		return http.DefaultTransport
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return checkIP(net.ParseIP(host))
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return transport
+}
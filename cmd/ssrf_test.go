@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestCheckLink(t *testing.T) {
+	setupConf(t)
+	conf.BlockPrivate = true
+
+	for link, blocked := range map[string]bool{
+		"http://127.0.0.1/a.zip":                   true,
+		"http://169.254.169.254/latest/meta-data/": true,
+		"http://10.0.0.1/a.zip":                    true,
+		"http://[::1]/a.zip":                       true,
+		"file:///etc/passwd":                       true,
+		"https://93.184.216.34/a.zip":              false,
+	} {
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkLink(context.Background(), u); errors.Is(err, ErrLinkBlocked) != blocked {
+			t.Errorf("checkLink(%s) = %v, want blocked %v", link, err, blocked)
+		}
+	}
+
+	// -allow-cidr 中的地址不受限制
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	conf.AllowCIDRs = cidrList{loopback}
+	if err := checkIP(net.ParseIP("127.0.0.1")); err != nil {
+		t.Errorf("allowlisted loopback: %v", err)
+	}
+	if err := checkIP(net.ParseIP("169.254.169.254")); !errors.Is(err, ErrLinkBlocked) {
+		t.Errorf("link-local outside the allowlist: %v", err)
+	}
+}
+
+func TestListBlockedLink(t *testing.T) {
+	setupConf(t)
+	conf.BlockPrivate = true
+	originTransport = newOriginTransport()
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	r := newTestRouter()
+
+	for _, link := range []string{origin.link("/a.zip"), "http://169.254.169.254/latest/meta-data/"} {
+		resp := decodeResp[json.RawMessage](t, get(t, r, query("/list", "link", link)))
+		if resp.Code != 403 {
+			t.Errorf("%s: code %d, message %q, want 403", link, resp.Code, resp.Message)
+		}
+	}
+	if origin.requests.Load() != 0 {
+		t.Errorf("blocked link reached the origin %d times", origin.requests.Load())
+	}
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	conf.AllowCIDRs = cidrList{loopback}
+	if code := getCode(t, r, query("/list", "link", origin.link("/a.zip"))); code != 200 {
+		t.Errorf("allowlisted origin: code = %d, want 200", code)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+type StatReq struct {
+	ArchiveReq
+}
+
+type StatResp struct {
+	ArchiveMeta
+	Fingerprint string `json:"fingerprint"`
+	// Zip64 仅 zip 归档返回
+	Zip64        *bool        `json:"zip64,omitempty"`
+	Capabilities Capabilities `json:"capabilities"`
+	// FirstModified, LastModified 其中文件最早和最晚的修改时间, 只有扫描了文件且存在时间时返回
+	FirstModified *time.Time `json:"first_modified,omitempty"`
+	LastModified  *time.Time `json:"last_modified,omitempty"`
+}
+
+// Capabilities 归档支持的操作, 由格式和一次快速扫描得出.
+// 只有支持随机访问的归档才会扫描其中的文件, 否则 Scanned 为 false, 依赖文件内容的字段都为 false
+type Capabilities struct {
+	// RandomAccess 无需从头扫描即可列出和定位文件, 例如 zip 和非固实 7z
+	RandomAccess bool `json:"random_access"`
+	Solid        bool `json:"solid"`
+	Scanned      bool `json:"scanned"`
+	// RangedDownload 存在可以直接按范围读取的文件 (zip 中未压缩存储的文件), 其它文件的范围请求需要从头解压
+	RangedDownload bool `json:"ranged_download"`
+	Encrypted      bool `json:"encrypted"`
+	// Preview 存在可以预览的图片或文档
+	Preview   bool `json:"preview"`
+	Thumbnail bool `json:"thumbnail"`
+	// NestedTraversal 存在可以通过 auto_nest 进入的 tar 文件
+	NestedTraversal bool `json:"nested_traversal"`
+}
+
+// tarExts auto_nest 可以进入的文件的扩展名
+var tarExts = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz", ".tar.zst", ".tar.lz4"}
+
+// formatCapabilities 根据格式计算归档支持的操作
+func formatCapabilities(arc *Archive) (Capabilities, error) {
+	var caps Capabilities
+	var err error
+	if caps.Solid, err = arc.Solid(); err != nil {
+		return caps, err
+	}
+	caps.RandomAccess, err = arc.RandomAccess()
+	return caps, err
+}
+
+// scan 根据扫描到的文件计算依赖文件内容的操作
+func (caps *Capabilities) scan(files []stdArchiever.File) {
+	caps.Scanned = true
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		caps.RangedDownload = caps.RangedDownload || archiver.Seekable(f)
+		caps.Encrypted = caps.Encrypted || archiver.IsEncrypted(f)
+		switch fileCategory(ObjResp{Name: f.Name()}) {
+		case "image":
+			caps.Preview, caps.Thumbnail = true, true
+		case "document":
+			caps.Preview = true
+		}
+		name := strings.ToLower(f.Name())
+		for _, ext := range tarExts {
+			if strings.HasSuffix(name, ext) {
+				caps.NestedTraversal = true
+			}
+		}
+	}
+}
+
+// modTimeRange 返回文件最早和最晚的修改时间, 忽略没有时间的文件, 都没有时返回 nil
+func modTimeRange(files []stdArchiever.File) (first, last *time.Time) {
+	for _, f := range files {
+		t := f.ModTime()
+		if t.IsZero() {
+			continue
+		}
+		if first == nil || t.Before(*first) {
+			first = &t
+		}
+		if last == nil || t.After(*last) {
+			last = &t
+		}
+	}
+	return first, last
+}
+
+// Stat 返回归档本身的信息, 只有支持随机访问的归档才会扫描其中的文件
+func Stat(c *gin.Context) {
+	var req StatReq
+	if err := c.ShouldBind(&req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+
+	fingerprint, err := arc.Fingerprint()
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+	resp := StatResp{ArchiveMeta: arc.Meta(), Fingerprint: fingerprint}
+	if arc.IsZip() {
+		zip64, err := arc.Zip64()
+		if err != nil {
+			ExtractErrorResp(c, err)
+			return
+		}
+		resp.Zip64 = &zip64
+	}
+	if resp.Capabilities, err = formatCapabilities(arc); err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+	if resp.Capabilities.RandomAccess {
+		files, err := arc.CascadeExtractDirs(c, "/")
+		if err != nil {
+			ExtractErrorResp(c, err)
+			return
+		}
+		resp.Capabilities.scan(files)
+		resp.FirstModified, resp.LastModified = modTimeRange(files)
+	}
+	SuccessResp(c, resp)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+func TestArchiveMetaFromOrigin(t *testing.T) {
+	setupConf(t)
+	data := zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": data})
+	r := newTestRouter()
+
+	for _, endpoint := range []string{"/list", "/stat"} {
+		meta := getData[ArchiveMeta](t, r, query(endpoint, "link", origin.link("/a.zip")))
+		if meta.ArchiveModified == nil || !meta.ArchiveModified.Equal(testModTime) {
+			t.Errorf("%s: archive_modified = %v, want %v", endpoint, meta.ArchiveModified, testModTime)
+		}
+		if meta.ArchiveSize == nil || *meta.ArchiveSize != int64(len(data)) {
+			t.Errorf("%s: archive_size = %v, want %d", endpoint, meta.ArchiveSize, len(data))
+		}
+		if meta.Format != "zip" {
+			t.Errorf("%s: format = %q, want zip", endpoint, meta.Format)
+		}
+	}
+
+	// 上传的归档没有修改时间, 省略该字段
+	for _, endpoint := range []string{"/list", "/stat"} {
+		raw := getData[map[string]json.RawMessage](t, r, query(endpoint, "data", base64.StdEncoding.EncodeToString(data)))
+		if _, ok := raw["archive_modified"]; ok {
+			t.Errorf("%s: archive_modified should be omitted, got %s", endpoint, raw["archive_modified"])
+		}
+		if got := string(raw["archive_size"]); got != strconv.Itoa(len(data)) {
+			t.Errorf("%s: archive_size = %s, want %d", endpoint, got, len(data))
+		}
+	}
+}
+
+func TestStatZip64(t *testing.T) {
+	setupConf(t)
+	// 超过 65535 个文件时 archive/zip 写入 zip64 的中央目录结束记录
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	const n = 1 << 16
+	for i := 0; i < n; i++ {
+		if _, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("d%d/f%05d.txt", i%16, i), Method: zip.Store}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	origin := newTestOrigin(t, map[string][]byte{
+		"/big.zip":   buf.Bytes(),
+		"/small.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"}),
+	})
+	r := newTestRouter()
+
+	for link, want := range map[string]bool{"/big.zip": true, "/small.zip": false} {
+		resp := getData[StatResp](t, r, query("/stat", "link", origin.link(link)))
+		if resp.Zip64 == nil || *resp.Zip64 != want {
+			t.Errorf("%s: zip64 = %v, want %v", link, resp.Zip64, want)
+		}
+	}
+
+	list := getData[ListResp](t, r, query("/list", "link", origin.link("/big.zip"), "path", "/d3", "per_page", "2"))
+	if list.Total != n/16 || len(list.Content) != 2 || list.Content[0].NameInArchive != "d3/f00003.txt" {
+		t.Errorf("zip64 listing: total %d, first page %v", list.Total, names(list.Content))
+	}
+}
+
+func TestStatFingerprint(t *testing.T) {
+	setupConf(t)
+	data := zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": data})
+	setETag := func(etag string) {
+		origin.mu.Lock()
+		origin.header["/a.zip"] = http.Header{"Etag": {etag}}
+		origin.mu.Unlock()
+	}
+	r := newTestRouter()
+	fingerprint := func(kv ...string) string {
+		t.Helper()
+		fp := getData[StatResp](t, r, query("/stat", kv...)).Fingerprint
+		if fp == "" {
+			t.Fatalf("%v: empty fingerprint", kv)
+		}
+		return fp
+	}
+	link := origin.link("/a.zip")
+
+	setETag(`"v1"`)
+	first := fingerprint("link", link)
+	if again := fingerprint("link", link); again != first {
+		t.Errorf("identical origin responses: fingerprint %s, then %s", first, again)
+	}
+	setETag(`"v2"`)
+	if changed := fingerprint("link", link); changed == first {
+		t.Error("fingerprint should change with the ETag")
+	}
+
+	// 没有 ETag 和修改时间时按内容抽样计算
+	b64 := base64.StdEncoding.EncodeToString(data)
+	other := base64.StdEncoding.EncodeToString(zipBytes(t, testEntry{Name: "a.txt", Body: "omega"}))
+	if fingerprint("data", b64) != fingerprint("data", b64) {
+		t.Error("content fingerprint should be stable")
+	}
+	if fingerprint("data", b64) == fingerprint("data", other) {
+		t.Error("content fingerprint should change with the content")
+	}
+}
+
+func TestStatCapabilities(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "stored.txt", Body: "stored", Stored: true},
+			testEntry{Name: "photo.png", Body: "\x89PNG\r\n\x1a\n"},
+			testEntry{Name: "inner.tar", Body: string(tarBytes(t, testEntry{Name: "a.txt", Body: "a"}))},
+		),
+		"/solid.7z":    testdata(t, "lzma2.7z"),
+		"/nonsolid.7z": testdata(t, "t0.7z"),
+	})
+	r := newTestRouter()
+
+	for link, want := range map[string]Capabilities{
+		"/a.zip": {RandomAccess: true, Scanned: true, RangedDownload: true, Preview: true, Thumbnail: true, NestedTraversal: true},
+		// 固实 7z 需要从头解压, 不扫描其中的文件
+		"/solid.7z":    {Solid: true},
+		"/nonsolid.7z": {RandomAccess: true, Scanned: true},
+	} {
+		if got := getData[StatResp](t, r, query("/stat", "link", origin.link(link))).Capabilities; got != want {
+			t.Errorf("%s: capabilities = %+v, want %+v", link, got, want)
+		}
+	}
+}
+
+func TestStatModTimeRange(t *testing.T) {
+	setupConf(t)
+	first, last := time.Date(2019, 3, 1, 8, 0, 0, 0, time.UTC), time.Date(2023, 11, 20, 18, 30, 0, 0, time.UTC)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "b.txt", Body: "b", Modified: last},
+			testEntry{Name: "dir/a.txt", Body: "a", Modified: first},
+			testEntry{Name: "c.txt", Body: "c", Modified: time.Date(2021, 6, 15, 0, 0, 0, 0, time.UTC)},
+		),
+	})
+	r := newTestRouter()
+
+	resp := getData[StatResp](t, r, query("/stat", "link", origin.link("/a.zip")))
+	if resp.FirstModified == nil || !resp.FirstModified.Equal(first) || resp.LastModified == nil || !resp.LastModified.Equal(last) {
+		t.Errorf("first_modified %v, last_modified %v, want %v, %v", resp.FirstModified, resp.LastModified, first, last)
+	}
+
+	// 没有修改时间的文件不参与计算
+	fsys := fstest.MapFS{"a": {ModTime: first}, "b": {}, "c": {ModTime: last}}
+	files := make([]stdArchiever.File, 0, len(fsys))
+	for name := range fsys {
+		fi, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, stdArchiever.File{FileInfo: fi, NameInArchive: name})
+	}
+	if lo, hi := modTimeRange(files); lo == nil || !lo.Equal(first) || hi == nil || !hi.Equal(last) {
+		t.Errorf("modTimeRange = %v, %v, want %v, %v", lo, hi, first, last)
+	}
+	var zeroOnly []stdArchiever.File
+	for _, f := range files {
+		if f.ModTime().IsZero() {
+			zeroOnly = append(zeroOnly, f)
+		}
+	}
+	if lo, hi := modTimeRange(zeroOnly); len(zeroOnly) != 1 || lo != nil || hi != nil {
+		t.Errorf("no times: modTimeRange = %v, %v, want nil", lo, hi)
+	}
+}
+
+func TestFormatName(t *testing.T) {
+	setupConf(t)
+	entries := []testEntry{{Name: "a.txt", Body: "alpha"}}
+	files := map[string][]byte{
+		"/a.zip":     zipBytes(t, entries...),
+		"/a.tar":     tarBytes(t, entries...),
+		"/a.tar.gz":  gzipBytes(t, tarBytes(t, entries...)),
+		"/a.tar.zst": compressBytes(t, stdArchiever.Zstd{}, tarBytes(t, entries...)),
+		"/a.7z":      testdata(t, "t0.7z"),
+	}
+	origin := newTestOrigin(t, files)
+	r := newTestRouter()
+
+	for link, want := range map[string]string{"/a.zip": "zip", "/a.tar": "tar", "/a.tar.gz": "tar.gz", "/a.tar.zst": "tar.zst", "/a.7z": "7z"} {
+		arc, err := archiver.DetectArchive(link, bytes.NewReader(files[link]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := arc.FormatName(); got != want {
+			t.Errorf("FormatName(%s) = %q, want %q", link, got, want)
+		}
+		if got := getData[ListResp](t, r, query("/list", "link", origin.link(link))).Format; got != want {
+			t.Errorf("list %s: format = %q, want %q", link, got, want)
+		}
+		// 7z 测试数据中没有 a.txt
+		if link == "/a.7z" {
+			continue
+		}
+		if got := getData[GetResp](t, r, query("/get", "link", origin.link(link), "path", "/a.txt")).Format; got != want {
+			t.Errorf("get %s: format = %q, want %q", link, got, want)
+		}
+	}
+}
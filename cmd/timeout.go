@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestTimeout 为每个请求设置 -request-timeout 的截止时间, 源站读取和解压都使用该 context
+func requestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// withContext 在 ctx 结束时不再等待 fn 返回, 直接返回 ctx 的错误.
+// 用于不接受 context 的源站请求, fn 仍会在后台执行完
+func withContext[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// ctxReaderAt 在 ctx 结束后停止等待源站的读取, read 不为 nil 时累计读取的字节数.
+// 源站的索引缓存在多个请求间共享, 无法把某个请求的 context 放进源站请求中
+type ctxReaderAt struct {
+	ctx  context.Context
+	ra   io.ReaderAt
+	read *atomic.Int64
+}
+
+func (r ctxReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	// 提前返回后后台的读取仍可能写入, 不能直接使用 p
+	type result struct {
+		buf []byte
+		n   int
+	}
+	res, err := withContext(r.ctx, func() (result, error) {
+		buf := make([]byte, len(p))
+		n, err := r.ra.ReadAt(buf, off)
+		return result{buf, n}, err
+	})
+	if res.buf != nil {
+		copy(p, res.buf[:res.n])
+	}
+	if r.read != nil {
+		r.read.Add(int64(res.n))
+	}
+	return res.n, err
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: "alpha"})})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	var stallAfter atomic.Int64
+	// 源站在收到 stallAfter 个请求后停止响应
+	origin.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if origin.requests.Load() > stallAfter.Load() {
+			<-release
+			return true
+		}
+		return false
+	}
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(requestTimeout(100 * time.Millisecond))
+	archiveRoutes(r)
+
+	for _, n := range []int64{0, 1} {
+		stallAfter.Store(n)
+		origin.requests.Store(0)
+		start := time.Now()
+		resp := decodeResp[json.RawMessage](t, get(t, r, query("/down", "link", origin.link("/a.zip"), "path", "/a.txt")))
+		if resp.Code != 504 || resp.ErrorType != "timeout" {
+			t.Errorf("stall after %d requests: code %d, error_type %q, want 504 timeout", n, resp.Code, resp.ErrorType)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("stall after %d requests: took %v", n, elapsed)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	archiver "github.com/SheltonZhu/remote-archive-decompression-server"
+	"github.com/gin-gonic/gin"
+	stdArchiever "github.com/mholt/archiver/v4"
+)
+
+type TreeReq struct {
+	ArchiveReq
+	Path string `json:"path" form:"path"`
+	// Depth 只返回 path 下不超过 depth 层的条目, 为空时不限制
+	Depth *int `json:"depth" form:"depth"`
+}
+
+// TreeNode 目录树中的一个文件或目录, 目录的 Children 按目录在前, 名称自然排序
+type TreeNode struct {
+	ObjResp
+	Children []*TreeNode `json:"children,omitempty"`
+	// Truncated 超过 -tree-max-nodes 时没有返回该目录下的条目, 可以用该目录的路径再次请求
+	Truncated bool `json:"truncated,omitempty"`
+
+	depth int
+}
+
+type TreeResp struct {
+	ArchiveMeta
+	Root *TreeNode `json:"root"`
+	// Nodes 返回的条目数, 不包括 Root
+	Nodes     int  `json:"nodes"`
+	Truncated bool `json:"truncated"`
+}
+
+// Tree 以嵌套的目录树返回 path 下的所有文件和目录, 条目超过 -tree-max-nodes 时按层级从浅到深保留
+func Tree(c *gin.Context) {
+	var req TreeReq
+	if err := c.ShouldBind(&req); err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+	if req.Depth != nil && *req.Depth < 0 {
+		ErrorStrResp(c, "depth must be a non-negative integer", 400)
+		return
+	}
+	reqPath, err := handerReqPath(req.Path, true, req.PathEncoded)
+	if err != nil {
+		ErrorStrResp(c, err.Error(), 400)
+		return
+	}
+
+	arc, err := getArchive(c, &req.ArchiveReq)
+	if err != nil {
+		ArchiveErrorResp(c, err)
+		return
+	}
+	arc, reqPath, err = openNested(c, arc, &req.ArchiveReq, reqPath)
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+	files := make([]stdArchiever.File, 0)
+	// 超过 depth 的文件不返回, 但其所在的第 depth 层目录即使没有单独记录也要补全
+	var implied []string
+	err = arc.CascadeWalkDirs(c, reqPath, func(ctx context.Context, f stdArchiever.File) error {
+		if req.Depth == nil || archiver.DirDepth(reqPath, f.NameInArchive) <= *req.Depth {
+			files = append(files, f)
+		} else if *req.Depth > 0 {
+			rel := strings.Split(strings.Trim(arc.RelPath("/"+f.NameInArchive, reqPath), "/"), "/")
+			implied = append(implied, strings.Join(rel[:*req.Depth], "/"))
+		}
+		return nil
+	})
+	if err != nil {
+		ExtractErrorResp(c, err)
+		return
+	}
+
+	root, nodes := buildTree(arc, files, reqPath, implied)
+	kept := pruneTree(root, nodes, conf.TreeMaxNodes)
+	sortTree(root)
+	SuccessResp(c, TreeResp{
+		ArchiveMeta: arc.Meta(),
+		Root:        root,
+		Nodes:       kept,
+		Truncated:   kept < len(nodes),
+	})
+}
+
+// buildTree 构造 dir 下的目录树, 归档中没有单独记录的中间目录根据其下文件的路径和 implied 中的相对路径补全.
+// 返回根节点和其余所有节点
+func buildTree(arc *Archive, files []stdArchiever.File, dir string, implied []string) (*TreeNode, []*TreeNode) {
+	name := strings.TrimPrefix(dir, "/")
+	root := &TreeNode{ObjResp: implicitDirObj(name)}
+	byPath := map[string]*TreeNode{"": root}
+	var nodes []*TreeNode
+
+	// node 返回相对路径 rel 对应的目录节点, 不存在时补全
+	var node func(rel string) *TreeNode
+	node = func(rel string) *TreeNode {
+		if n, ok := byPath[rel]; ok {
+			return n
+		}
+		parentRel, _ := splitParent(rel)
+		parent := node(parentRel)
+		n := &TreeNode{ObjResp: implicitDirObj(name + rel + "/"), depth: parent.depth + 1}
+		byPath[rel] = n
+		parent.Children = append(parent.Children, n)
+		nodes = append(nodes, n)
+		return n
+	}
+
+	for i := range files {
+		f := &files[i]
+		rel := strings.Trim(arc.RelPath("/"+f.NameInArchive, dir), "/")
+		if rel == "" {
+			// 目录本身
+			root.ObjResp = buildObj(arc, f)
+			continue
+		}
+		obj := listObj(arc, f, dir)
+		if n, ok := byPath[rel]; ok && f.IsDir() {
+			// 记录的目录替换之前补全的目录
+			n.ObjResp = obj
+			continue
+		}
+		parentRel, _ := splitParent(rel)
+		parent := node(parentRel)
+		n := &TreeNode{ObjResp: obj, depth: parent.depth + 1}
+		if f.IsDir() {
+			byPath[rel] = n
+		}
+		parent.Children = append(parent.Children, n)
+		nodes = append(nodes, n)
+	}
+	for _, rel := range implied {
+		node(rel)
+	}
+	return root, nodes
+}
+
+// splitParent 拆分相对路径为父目录和名称, 父目录为根目录时为空
+func splitParent(rel string) (string, string) {
+	i := strings.LastIndex(rel, "/")
+	if i < 0 {
+		return "", rel
+	}
+	return rel[:i], rel[i+1:]
+}
+
+func implicitDirObj(nameInArchive string) ObjResp {
+	name := strings.TrimSuffix(nameInArchive, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return ObjResp{Name: name, IsDir: true, NameInArchive: nameInArchive, Mode: "0755"}
+}
+
+// pruneTree 条目超过 maxNodes 时从最深的层级开始去掉, 被去掉子项的目录标记为 Truncated.
+// 最浅的一层仍然超过时只保留该层前 maxNodes 个. 返回保留的条目数
+func pruneTree(root *TreeNode, nodes []*TreeNode, maxNodes int) int {
+	if maxNodes <= 0 || len(nodes) <= maxNodes {
+		return len(nodes)
+	}
+	byDepth := make([]*TreeNode, len(nodes))
+	copy(byDepth, nodes)
+	sort.SliceStable(byDepth, func(i, j int) bool { return byDepth[i].depth < byDepth[j].depth })
+	// 保留完整的层级, 直到下一层会超过上限
+	keep := 0
+	for keep < len(byDepth) {
+		next := keep
+		for next < len(byDepth) && byDepth[next].depth == byDepth[keep].depth {
+			next++
+		}
+		if next > maxNodes {
+			break
+		}
+		keep = next
+	}
+	if keep == 0 {
+		keep = maxNodes
+	}
+	kept := make(map[*TreeNode]bool, keep)
+	for _, n := range byDepth[:keep] {
+		kept[n] = true
+	}
+	var prune func(n *TreeNode)
+	prune = func(n *TreeNode) {
+		children := n.Children[:0]
+		for _, child := range n.Children {
+			if kept[child] {
+				children = append(children, child)
+				prune(child)
+			}
+		}
+		n.Truncated = len(children) < len(n.Children)
+		n.Children = children
+	}
+	prune(root)
+	return keep
+}
+
+// sortTree 按目录在前, 名称自然排序每个目录的子项
+func sortTree(root *TreeNode) {
+	col := newNaturalCollator()
+	var walk func(n *TreeNode)
+	walk = func(n *TreeNode) {
+		keys := make(map[*TreeNode][]naturalChunk, len(n.Children))
+		for _, child := range n.Children {
+			keys[child] = naturalKey(col, child.Name)
+		}
+		sort.SliceStable(n.Children, func(i, j int) bool {
+			a, b := n.Children[i], n.Children[j]
+			if a.IsDir != b.IsDir {
+				return a.IsDir
+			}
+			return compareNaturalKeys(keys[a], keys[b]) < 0
+		})
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+}
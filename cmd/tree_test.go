@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// renderTree 将目录树写为 name/[children] 的形式, 被截断的目录以 ... 结尾
+func renderTree(n *TreeNode) string {
+	s := n.Name
+	if n.IsDir {
+		s += "/"
+	}
+	if len(n.Children) == 0 && !n.Truncated {
+		return s
+	}
+	children := make([]string, 0, len(n.Children)+1)
+	for _, child := range n.Children {
+		children = append(children, renderTree(child))
+	}
+	if n.Truncated {
+		children = append(children, "...")
+	}
+	return s + "[" + strings.Join(children, " ") + "]"
+}
+
+func TestTree(t *testing.T) {
+	setupConf(t)
+	origin := newTestOrigin(t, map[string][]byte{
+		"/a.zip": zipBytes(t,
+			testEntry{Name: "readme.md", Body: "r"},
+			testEntry{Name: "docs/guide/c10.txt", Body: "c"},
+			testEntry{Name: "docs/a.txt", Body: "a"},
+			testEntry{Name: "docs/guide/c2.txt", Body: "c"},
+			testEntry{Name: "docs/guide/b.txt", Body: "b"},
+			testEntry{Name: "src/"},
+		),
+	})
+	link := origin.link("/a.zip")
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		kv    []string
+		want  string
+		nodes int
+	}{
+		// 目录在前, 名称自然排序, 中间目录根据文件路径补全
+		{nil, "/[docs/[guide/[b.txt c2.txt c10.txt] a.txt] src/ readme.md]", 8},
+		{[]string{"depth", "1"}, "/[docs/ src/ readme.md]", 3},
+		{[]string{"path", "/docs"}, "docs/[guide/[b.txt c2.txt c10.txt] a.txt]", 5},
+		{[]string{"path", "/docs", "depth", "1"}, "docs/[guide/ a.txt]", 2},
+	} {
+		resp := getData[TreeResp](t, r, query("/tree", append([]string{"link", link}, tc.kv...)...))
+		if got := renderTree(resp.Root); got != tc.want || resp.Nodes != tc.nodes || resp.Truncated {
+			t.Errorf("%v: tree %s, %d nodes, truncated %v, want %s, %d nodes", tc.kv, got, resp.Nodes, resp.Truncated, tc.want, tc.nodes)
+		}
+	}
+
+	// 超过上限时保留较浅的层级
+	conf.TreeMaxNodes = 4
+	resp := getData[TreeResp](t, r, query("/tree", "link", link))
+	if got, want := renderTree(resp.Root), "/[docs/[...] src/ readme.md]"; got != want || resp.Nodes != 3 || !resp.Truncated {
+		t.Errorf("max nodes 4: tree %s, %d nodes, truncated %v, want %s", got, resp.Nodes, resp.Truncated, want)
+	}
+
+	if code := getCode(t, r, query("/tree", "link", link, "depth", "-1")); code != 400 {
+		t.Errorf("negative depth: code = %d, want 400", code)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxVolumes 请求中 volumes 的上限
+const maxVolumes = 999
+
+var (
+	ErrVolumes = fmt.Errorf("volumes must be between 0 and %d", maxVolumes)
+	// ErrVolumeLink 分卷归档的链接路径没有以序号结尾
+	ErrVolumeLink = errors.New("link of a split archive must end with the volume number, e.g. archive.7z.001")
+	// ErrVolumeSize 除最后一卷外各卷大小不同, 或最后一卷比其他卷大, 通常是分卷不属于同一个归档
+	ErrVolumeSize = errors.New("volumes of the split archive have inconsistent sizes")
+)
+
+// volumeNumber 链接路径末尾的分卷序号
+var volumeNumber = regexp.MustCompile(`\d+$`)
+
+// volumeLinks 由第一卷的链接生成所有分卷的链接, 序号保持相同的位数, 例如 a.7z.001, a.7z.002.
+// 同时返回去掉序号后用于识别格式的路径
+func volumeLinks(rawURL string, volumes int) ([]*url.URL, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	digits := volumeNumber.FindString(u.Path)
+	if digits == "" {
+		return nil, "", ErrVolumeLink
+	}
+	first, err := strconv.Atoi(digits)
+	if err != nil {
+		return nil, "", ErrVolumeLink
+	}
+	base := strings.TrimSuffix(u.Path, digits)
+	links := make([]*url.URL, volumes)
+	for i := range links {
+		v := *u
+		v.Path, v.RawPath = fmt.Sprintf("%s%0*d", base, len(digits), first+i), ""
+		links[i] = &v
+	}
+	return links, strings.TrimSuffix(base, "."), nil
+}
+
+// getVolumesArchive 打开按字节切分的分卷归档 (例如 7-Zip 的 a.7z.001 或 split 切分的 a.zip.001), 各卷依次拼接为一个归档
+func getVolumesArchive(c *gin.Context, rawURL string, headers map[string]string, bufSize, volumes int) (*Archive, error) {
+	links, name, err := volumeLinks(rawURL, volumes)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]originSource, 0, volumes)
+	for i, link := range links {
+		part, _, err := openOrigin(c, link.String(), headers, bufSize)
+		if err != nil {
+			return nil, fmt.Errorf("volume %d of %d (%s): %w", i+1, volumes, redactURL(link), err)
+		}
+		parts = append(parts, part)
+	}
+	origin, err := newVolumeReader(parts)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(archiveLinkKey, redactURL(links[0]))
+	return newRemoteArchive(c, name, origin, bufSize)
+}
+
+// volumeReader 将多个分卷拼接为一个 originSource, 头部为第一卷的头部
+type volumeReader struct {
+	parts []originSource
+	// offsets 每一卷在拼接后的起始位置
+	offsets []int64
+	size    int64
+}
+
+func newVolumeReader(parts []originSource) (*volumeReader, error) {
+	vr := &volumeReader{parts: parts, offsets: make([]int64, len(parts))}
+	for i, part := range parts {
+		if part.Size() <= 0 || (i > 0 && part.Size() > parts[0].Size()) ||
+			(i > 0 && i < len(parts)-1 && part.Size() != parts[0].Size()) {
+			return nil, fmt.Errorf("%w: volume %d has %d bytes, volume 1 has %d", ErrVolumeSize, i+1, part.Size(), parts[0].Size())
+		}
+		vr.offsets[i] = vr.size
+		vr.size += part.Size()
+	}
+	return vr, nil
+}
+
+func (vr *volumeReader) Size() int64 { return vr.size }
+
+func (vr *volumeReader) Header() http.Header { return vr.parts[0].Header() }
+
+func (vr *volumeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= vr.size {
+		return 0, io.EOF
+	}
+	// 第一个包含 off 的分卷
+	i := sort.Search(len(vr.offsets), func(i int) bool { return vr.offsets[i] > off }) - 1
+	n := 0
+	for ; n < len(p) && i < len(vr.parts); i++ {
+		partOff := off + int64(n) - vr.offsets[i]
+		want := int(min(int64(len(p)-n), vr.parts[i].Size()-partOff))
+		m, err := vr.parts[i].ReadAt(p[n:n+want], partOff)
+		n += m
+		if err != nil && (err != io.EOF || m < want) {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
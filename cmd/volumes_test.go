@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitVolumes(t *testing.T) {
+	setupConf(t)
+	body := strings.Repeat("split volume ", 100)
+	archives := map[string][]byte{
+		"/a.zip": zipBytes(t, testEntry{Name: "a.txt", Body: body}, testEntry{Name: "dir/b.txt", Body: "beta"}),
+		"/a.7z":  testdata(t, "t0.7z"),
+	}
+	files := map[string][]byte{}
+	for name, data := range archives {
+		// 两卷, 第一卷不小于第二卷
+		half := (len(data) + 1) / 2
+		files[name] = data
+		files[name+".001"], files[name+".002"] = data[:half], data[half:]
+	}
+	files["/bad.zip.001"], files["/bad.zip.002"] = files["/a.zip.001"][:10], files["/a.zip.002"]
+	origin := newTestOrigin(t, files)
+	r := newTestRouter()
+
+	for name := range archives {
+		want := names(getData[ListResp](t, r, query("/list", "link", origin.link(name), "cascade", "true")).Content)
+		got := names(getData[ListResp](t, r, query("/list", "link", origin.link(name+".001"), "volumes", "2", "cascade", "true")).Content)
+		if len(want) == 0 || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: split listing %v, want %v", name, got, want)
+		}
+	}
+	w := get(t, r, query("/down", "link", origin.link("/a.zip.001"), "volumes", "2", "path", "/a.txt"))
+	if w.Code != 200 || w.Body.String() != body {
+		t.Errorf("down from split zip: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+
+	for name, tc := range map[string]struct {
+		link, volumes string
+		code          int
+		errorType     string
+		message       string
+	}{
+		"missing part":       {"/a.zip.001", "3", 502, "upstream_unreachable", "volume 3 of 3"},
+		"inconsistent sizes": {"/bad.zip.001", "2", 422, "", ErrVolumeSize.Error()},
+		"no volume number":   {"/a.zip", "2", 400, "", ErrVolumeLink.Error()},
+		"too many volumes":   {"/a.zip.001", "1000", 400, "", ErrVolumes.Error()},
+	} {
+		resp := decodeResp[json.RawMessage](t, get(t, r, query("/list", "link", origin.link(tc.link), "volumes", tc.volumes)))
+		if resp.Code != tc.code || !strings.Contains(resp.Message, tc.message) || (tc.errorType != "" && resp.ErrorType != tc.errorType) {
+			t.Errorf("%s: code %d, error_type %q, message %q, want %d %q", name, resp.Code, resp.ErrorType, resp.Message, tc.code, tc.message)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/mholt/archiver/v4"
+)
+
+// compressedFile 单个被压缩的文件, 例如 .gz, 视为只包含一个文件的归档
+type compressedFile struct {
+	archiver.Compression
+	name string
+	// firstMemberOnly 为 true 时 gzip 只解压第一个成员, 默认和 gunzip 一样解压并拼接所有成员
+	firstMemberOnly bool
+}
+
+// newCompressedFile 以去掉压缩扩展名后的源文件名作为其中唯一文件的文件名
+func newCompressedFile(comp archiver.Compression, sourceArchiveName string) compressedFile {
+	name := strings.TrimSuffix(path.Base(sourceArchiveName), comp.Name())
+	if name == "" || name == "." || name == "/" {
+		name = "data"
+	}
+	return compressedFile{Compression: comp, name: name}
+}
+
+func (cf compressedFile) Extract(ctx context.Context, sourceArchive io.Reader, pathsInArchive []string, handleFile archiver.FileHandler) error {
+	if pathsInArchive != nil && !fileIncluded(pathsInArchive, cf.name) {
+		return nil
+	}
+	ra, ok := sourceArchive.(sizedReaderAt)
+	if !ok {
+		return fmt.Errorf("compressed file is not seekable")
+	}
+	return handleFile(ctx, archiver.File{
+		FileInfo:      compressedFileInfo{name: cf.name},
+		NameInArchive: cf.name,
+		// 每次打开都从头解压, 遍历结束后仍然可以打开
+		Open: func() (io.ReadCloser, error) {
+			return cf.openReader(io.NewSectionReader(ra, 0, ra.Size()))
+		},
+	})
+}
+
+func (cf compressedFile) openReader(r io.Reader) (io.ReadCloser, error) {
+	if _, isGz := cf.Compression.(archiver.Gz); isGz && cf.firstMemberOnly {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		zr.Multistream(false)
+		return zr, nil
+	}
+	return cf.OpenReader(r)
+}
+
+// fileIncluded 与 archiver 中的规则相同, 文件本身或其所在目录在列表中时包含该文件
+func fileIncluded(pathsInArchive []string, name string) bool {
+	for _, p := range pathsInArchive {
+		if name == p || strings.HasPrefix(name, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// compressedFileInfo 解压前无法得知文件大小, Size 返回 -1
+type compressedFileInfo struct {
+	name string
+}
+
+func (fi compressedFileInfo) Name() string       { return fi.name }
+func (fi compressedFileInfo) Size() int64        { return -1 }
+func (fi compressedFileInfo) Mode() fs.FileMode  { return 0o644 }
+func (fi compressedFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi compressedFileInfo) IsDir() bool        { return false }
+func (fi compressedFileInfo) Sys() any           { return nil }
+
+// SetGzipFirstMemberOnly 设置单个 gzip 文件是否只解压第一个成员
+func (ae *ArchiverExtractor) SetGzipFirstMemberOnly(firstMemberOnly bool) {
+	if cf, ok := ae.Extractor.(compressedFile); ok {
+		cf.firstMemberOnly = firstMemberOnly
+		ae.Extractor = cf
+	}
+}
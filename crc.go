@@ -0,0 +1,15 @@
+package archiver
+
+import (
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archiver/v4"
+)
+
+// StoredCRC32 返回 zip 头部记录的文件内容 CRC32, 无需解压. 加密的文件 (AES 可能不记录 CRC) 和其他格式返回 false
+func StoredCRC32(f archiver.File) (uint32, bool) {
+	fh, ok := f.Header.(zip.FileHeader)
+	if !ok || f.IsDir() || IsEncrypted(f) {
+		return 0, false
+	}
+	return fh.CRC32, true
+}
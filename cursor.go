@@ -0,0 +1,69 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// ErrCursorNotFound 游标指向的位置在归档中不存在或条目不同, 通常是归档已经变化
+var ErrCursorNotFound = errors.New("cursor does not match the archive, it may have changed")
+
+// PageCursor 分页遍历的位置, Entry 为已经遍历的条目数, Name 为其中最后一个条目的 NameInArchive, 用于发现归档已经变化.
+// 零值表示从头开始
+type PageCursor struct {
+	Entry int
+	Name  string
+}
+
+// PageAfter 按遍历顺序返回 dir 下 after 之后的最多 limit 个条目, 取到足够的条目后立即停止遍历.
+// depth 为 1 时与 ExtractDirs 相同只返回直接子项 (补全没有单独记录的目录), 小于 0 时返回所有层级, 否则返回不超过 depth 层的条目.
+// keep 返回 false 的条目不计入. 还有更多条目时返回下一页的游标, 否则返回 nil
+func (ae *ArchiverExtractor) PageAfter(ctx context.Context, dir string, depth int, after PageCursor, limit int, keep func(archiver.File) bool) ([]archiver.File, *PageCursor, error) {
+	files := make([]archiver.File, 0, limit)
+	// dirs 本页中目录的下标, 用于替换补全的目录
+	dirs := make(map[string]int)
+	// entries 已经遍历的条目数, name 为当前条目的 NameInArchive
+	entries, name := 0, ""
+	var last, next *PageCursor
+	handleFile, err := ae.aliasResolvedDir(ctx, dir, ae.listingFilter(dir, depth, func(f archiver.File, replaces bool) error {
+		// 之前的页同样经过 listingFilter, 使补全的目录不会重复返回
+		if entries <= after.Entry || !keep(f) {
+			return nil
+		}
+		if replaces {
+			if i, ok := dirs[f.NameInArchive]; ok {
+				files[i] = f
+			}
+			return nil
+		}
+		if len(files) == limit {
+			next = last
+			return errStopWalk
+		}
+		if f.IsDir() {
+			dirs[f.NameInArchive] = len(files)
+		}
+		files = append(files, f)
+		last = &PageCursor{Entry: entries, Name: name}
+		return nil
+	}))
+	if err != nil {
+		return nil, nil, err
+	}
+	err = ae.extract(ctx, ae.pathsInArchive, func(ctx context.Context, f archiver.File) error {
+		entries, name = entries+1, f.NameInArchive
+		if entries == after.Entry && name != after.Name {
+			return ErrCursorNotFound
+		}
+		return handleFile(ctx, f)
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, nil, err
+	}
+	if entries < after.Entry {
+		return nil, nil, ErrCursorNotFound
+	}
+	return files, next, nil
+}
@@ -0,0 +1,126 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archiver/v4"
+	yzip "github.com/yeka/zip"
+)
+
+var (
+	// ErrEncrypted 文件已加密, 但没有提供密码
+	ErrEncrypted = errors.New("archive is encrypted")
+	// ErrWrongPassword 提供的密码无法解密文件
+	ErrWrongPassword = fmt.Errorf("%w: wrong password", ErrEncrypted)
+)
+
+// zip 中 AES 加密的文件使用的压缩方法
+const zipMethodAES = 99
+
+type sizedReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// SetPassword 设置解密归档使用的密码, 支持 zip (ZipCrypto 和 AES), 7z 和 rar
+func (ae *ArchiverExtractor) SetPassword(password string) {
+	ae.password = password
+	switch ex := ae.Extractor.(type) {
+	case archiver.SevenZip:
+		ex.Password = password
+		ae.Extractor = ex
+	case archiver.Rar:
+		ex.Password = password
+		ae.Extractor = ex
+	}
+}
+
+// IsEncrypted 判断是否为 zip 中加密的文件
+func IsEncrypted(f archiver.File) bool {
+	_, ok := encryptedZipFile(f)
+	return ok
+}
+
+// encryptedZipFile 判断是否为 zip 中加密的文件
+func encryptedZipFile(f archiver.File) (zip.FileHeader, bool) {
+	fh, ok := f.Header.(zip.FileHeader)
+	return fh, ok && fh.Flags&0x1 != 0
+}
+
+// openEncrypted 使用密码打开 zip 中加密的文件
+// 注意 ZipCrypto 加密的文件会整个读入内存后再解密
+func (ae *ArchiverExtractor) openEncrypted(fh zip.FileHeader) (io.ReadCloser, error) {
+	if ae.password == "" {
+		return nil, ErrEncrypted
+	}
+	zf, err := ae.encryptedFile(fh.Name)
+	if err != nil {
+		return nil, err
+	}
+	if fh.Method != zipMethodAES {
+		if err := ae.checkBufferSize(zf.CompressedSize64); err != nil {
+			return nil, err
+		}
+		if ok, err := ae.checkZipCryptoPassword(zf); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, ErrWrongPassword
+		}
+	}
+
+	zf.SetPassword(ae.password)
+	rc, err := zf.Open()
+	if errors.Is(err, yzip.ErrPassword) {
+		return nil, ErrWrongPassword
+	}
+	return rc, err
+}
+
+// encryptedFile 按原始文件名查找加密的文件, 第一次调用时读取中央目录建立索引
+func (ae *ArchiverExtractor) encryptedFile(name string) (*yzip.File, error) {
+	if ae.encryptedFiles == nil {
+		ra, ok := ae.sourceArchive.(sizedReaderAt)
+		if !ok {
+			return nil, fmt.Errorf("zip archive is not seekable")
+		}
+		zr, err := yzip.NewReader(ra, ra.Size())
+		if err != nil {
+			return nil, err
+		}
+
+		ae.encryptedFiles = make(map[string]*yzip.File)
+		for _, zf := range zr.File {
+			if zf.IsEncrypted() {
+				ae.encryptedFiles[zf.Name] = zf
+			}
+		}
+	}
+
+	zf, ok := ae.encryptedFiles[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return zf, nil
+}
+
+// checkZipCryptoPassword 用 ZipCrypto 加密头的最后一个字节校验密码
+func (ae *ArchiverExtractor) checkZipCryptoPassword(zf *yzip.File) (bool, error) {
+	offset, err := zf.DataOffset()
+	if err != nil {
+		return false, err
+	}
+	header := make([]byte, 12)
+	if _, err := ae.sourceArchive.(sizedReaderAt).ReadAt(header, offset); err != nil {
+		return false, err
+	}
+	check := byte(zf.CRC32 >> 24)
+	// 使用数据描述符时, 写入加密头时还不知道 CRC, 改用修改时间校验
+	if zf.Flags&0x8 != 0 {
+		check = byte(zf.ModifiedTime >> 8)
+	}
+	return yzip.NewZipCrypto([]byte(ae.password)).Decrypt(header)[11] == check, nil
+}
@@ -0,0 +1,56 @@
+package archiver
+
+import "strings"
+
+// SetCaseInsensitive 设置请求的路径是否忽略大小写匹配归档中的文件名, 例如 /Docs/File.TXT 匹配 docs/file.txt.
+// 返回的文件名仍然是归档中记录的文件名
+func (ae *ArchiverExtractor) SetCaseInsensitive(caseInsensitive bool) {
+	ae.caseInsensitive = caseInsensitive
+}
+
+// cutPrefix 返回匹配路径前缀使用的函数, 与 strings.CutPrefix 相同, 忽略大小写时为 foldPrefix
+func (ae *ArchiverExtractor) cutPrefix() func(name, prefix string) (string, bool) {
+	if ae.caseInsensitive {
+		return foldPrefix
+	}
+	return strings.CutPrefix
+}
+
+// RelPath 返回 name 中 dir 之后的部分, name 不在 dir 下时原样返回. 忽略大小写时 dir 的大小写可以与 name 不同
+func (ae *ArchiverExtractor) RelPath(name, dir string) string {
+	rel, _ := ae.cutPrefix()(name, dir)
+	return rel
+}
+
+// foldKey 返回路径用于查找的键, 忽略大小写时不同大小写的路径键相同
+func (ae *ArchiverExtractor) foldKey(p string) string {
+	if ae.caseInsensitive {
+		return strings.ToLower(p)
+	}
+	return p
+}
+
+// foldPrefix 逐个路径段忽略大小写判断 name 是否以 prefix 开头, 返回 name 中 prefix 之后的部分.
+// prefix 最后一段不以 / 结尾时需要匹配 name 中完整的一段, 例如 /docs 不匹配 /docs2
+func foldPrefix(name, prefix string) (string, bool) {
+	rest := name
+	for prefix != "" {
+		seg, after, hasSlash := strings.Cut(prefix, "/")
+		prefix = after
+		nameSeg, nameAfter, nameHasSlash := strings.Cut(rest, "/")
+		if !strings.EqualFold(seg, nameSeg) {
+			return name, false
+		}
+		switch {
+		case hasSlash && !nameHasSlash:
+			return name, false
+		case hasSlash:
+			rest = nameAfter
+		case nameHasSlash:
+			rest = rest[len(nameSeg):]
+		default:
+			rest = ""
+		}
+	}
+	return rest, true
+}
@@ -0,0 +1,77 @@
+package archiver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// listingFilter 返回按遍历顺序选出 dir 下条目的 FileHandler, 对每个选出的条目调用 emit.
+// depth 为 1 时只选出直接子项, 补全没有单独记录的目录; 补全之后才遇到记录的目录时以 replaces 为 true 调用 emit.
+// depth 小于 0 时选出所有层级 (包括 dir 本身), 否则选出不超过 depth 层的条目
+func (ae *ArchiverExtractor) listingFilter(dir string, depth int, emit func(f archiver.File, replaces bool) error) archiver.FileHandler {
+	cut := ae.cutPrefix()
+	// seen depth 为 1 时已经选出的子目录
+	seen := make(map[string]bool)
+	return func(ctx context.Context, f archiver.File) error {
+		name := "/" + f.NameInArchive
+		rel, ok := cut(name, dir)
+		if !ok {
+			return nil
+		}
+		if depth != 1 {
+			if depth >= 0 && DirDepth(dir, f.NameInArchive) > depth {
+				return nil
+			}
+			return emit(f, false)
+		}
+		child, _, nested := strings.Cut(rel, "/")
+		switch {
+		case child == "":
+			return nil
+		case !nested:
+			return emit(f, false)
+		case seen[child]:
+			if rel == child+"/" {
+				return emit(f, true)
+			}
+			return nil
+		}
+		seen[child] = true
+		if rel != child+"/" {
+			f = implicitDir(name[1:len(name)-len(rel)] + child + "/")
+		}
+		return emit(f, false)
+	}
+}
+
+// aliasResolvedDir 开启 SetResolveSymlinks 且 dir 经过符号链接时, 将链接指向的目录下的文件改名到 dir 下再交给 handleFile
+func (ae *ArchiverExtractor) aliasResolvedDir(ctx context.Context, dir string, handleFile archiver.FileHandler) (archiver.FileHandler, error) {
+	if !ae.resolveSymlinks {
+		return handleFile, nil
+	}
+	resolved, err := ae.ResolvePath(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if resolved != dir {
+		handleFile = aliasDir(resolved, dir, handleFile)
+	}
+	return handleFile, nil
+}
+
+// WalkListing 按遍历顺序对 dir 下的条目调用 handle, 不保存遍历结果. depth 与 PageAfter 相同,
+// 为 1 时补全的目录已经返回, 之后遇到的记录的目录不再返回
+func (ae *ArchiverExtractor) WalkListing(ctx context.Context, dir string, depth int, handle func(archiver.File) error) error {
+	handleFile, err := ae.aliasResolvedDir(ctx, dir, ae.listingFilter(dir, depth, func(f archiver.File, replaces bool) error {
+		if replaces {
+			return nil
+		}
+		return handle(f)
+	}))
+	if err != nil {
+		return err
+	}
+	return ae.extract(ctx, ae.pathsInArchive, handleFile)
+}
@@ -0,0 +1,166 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// errStopWalk 找到文件后停止遍历
+var errStopWalk = errors.New("stop walk")
+
+// isTar 判断格式是否为 tar 或压缩的 tar, 例如 .tar.gz
+func isTar(format archiver.Format) bool {
+	switch f := format.(type) {
+	case archiver.Tar:
+		return true
+	case archiver.CompressedArchive:
+		_, ok := f.Archival.(archiver.Tar)
+		return ok
+	}
+	return false
+}
+
+// ExtractAutoNested 与 ExtractFile 相同, 但 filePath 不存在且路径中的某一级是 tar 文件时, 进入该 tar 查找剩余的路径.
+// 只进入一层, 返回文件的 NameInArchive 为外层路径加内层路径
+func (ae *ArchiverExtractor) ExtractAutoNested(ctx context.Context, filePath string) (*archiver.File, error) {
+	f, err := ae.ExtractFile(ctx, filePath)
+	if !errors.Is(err, ErrFileNotFound) {
+		return f, err
+	}
+
+	// 找到路径中作为前缀的文件
+	var outer *archiver.File
+	err = ae.extract(ctx, ae.pathsInArchive, func(ctx context.Context, f archiver.File) error {
+		if !f.IsDir() && strings.HasPrefix(filePath, "/"+f.NameInArchive+"/") {
+			ae.keepOpen(&f)
+			outer = &f
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, err
+	}
+	if outer == nil {
+		return nil, ErrFileNotFound
+	}
+
+	rc, err := outer.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	format, stream, err := archiver.Identify(outer.Name(), rc)
+	if errors.Is(err, archiver.ErrNoMatch) || (err == nil && !isTar(format)) {
+		return nil, ErrFileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	ex := format.(archiver.Extractor)
+
+	innerPath := strings.TrimPrefix(filePath, "/"+outer.NameInArchive+"/")
+	var inner *archiver.File
+	err = ex.Extract(ctx, stream, nil, func(ctx context.Context, f archiver.File) error {
+		if !f.IsDir() && strings.TrimPrefix(f.NameInArchive, "./") == innerPath {
+			inner = &f
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, err
+	}
+	if inner == nil {
+		return nil, ErrFileNotFound
+	}
+
+	// tar 中文件的 Open 只在遍历时有效, 每次打开时重新遍历
+	name := inner.NameInArchive
+	inner.NameInArchive = outer.NameInArchive + "/" + innerPath
+	inner.Open = streamedOpen(ex, outer.Open, name)
+	return inner, nil
+}
+
+// walkOnlyOpen 判断格式中文件的 Open 是否只在遍历时有效. tar (包括 .tar.gz 等) 和 rar 只能顺序解压,
+// 遍历结束后再读取文件需要重新从头扫描归档
+func walkOnlyOpen(format archiver.Extractor) bool {
+	switch format.(type) {
+	case archiver.Zip, archiver.SevenZip, compressedFile:
+		return false
+	}
+	return true
+}
+
+// keepOpen 使遍历结束后仍然可以打开 f: 只在遍历时有效的格式改为每次打开时从头重新遍历归档, 直到找到该文件
+func (ae *ArchiverExtractor) keepOpen(f *archiver.File) {
+	if f.IsDir() || !walkOnlyOpen(ae.Extractor) {
+		return
+	}
+	name := f.NameInArchive
+	f.Open = func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			err := ae.extract(context.Background(), nil, func(ctx context.Context, f archiver.File) error {
+				if f.NameInArchive != name || f.IsDir() {
+					return nil
+				}
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				if _, err := io.Copy(pw, rc); err != nil {
+					return err
+				}
+				return errStopWalk
+			})
+			if err == nil {
+				err = ErrFileNotFound
+			} else if errors.Is(err, errStopWalk) {
+				err = nil
+			}
+			pw.CloseWithError(err)
+		}()
+		return pr, nil
+	}
+}
+
+// streamedOpen 返回的 Open 每次调用时都从 reopen 打开的归档开头遍历, 通过管道返回名为 name 的文件内容,
+// 用于只能在遍历时读取的文件 (例如 tar 中的文件). 关闭返回的 ReadCloser 时遍历随之结束
+func streamedOpen(ex archiver.Extractor, reopen func() (io.ReadCloser, error), name string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		src, err := reopen()
+		if err != nil {
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			defer src.Close()
+			err := ex.Extract(context.Background(), src, []string{name}, func(ctx context.Context, f archiver.File) error {
+				if f.NameInArchive != name || f.IsDir() {
+					return nil
+				}
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				if _, err := io.Copy(pw, rc); err != nil {
+					return err
+				}
+				return errStopWalk
+			})
+			if err == nil {
+				err = ErrFileNotFound
+			} else if errors.Is(err, errStopWalk) {
+				err = nil
+			}
+			pw.CloseWithError(err)
+		}()
+		return pr, nil
+	}
+}
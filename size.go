@@ -0,0 +1,161 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archiver/v4"
+)
+
+var (
+	// ErrImplausibleSize 文件声明的大小为负数或与归档本身不符, 通常是归档损坏或被恶意构造
+	ErrImplausibleSize = errors.New("entry declares an implausible size")
+	// ErrBufferLimit 文件需要整个读入内存, 但超过了 SetMaxBufferBytes 设置的上限
+	ErrBufferLimit = errors.New("entry is too large to buffer in memory")
+	// ErrDecompressedLimit 解压的内容超过了 SetMaxDecompressedBytes 设置的上限, 通常是压缩炸弹
+	ErrDecompressedLimit = errors.New("decompressed content exceeds the limit")
+)
+
+// ImplausibleSizeError 记录声明了不合理大小的文件
+type ImplausibleSizeError struct {
+	Name   string
+	Size   int64
+	Reason string
+}
+
+func (e *ImplausibleSizeError) Error() string {
+	return fmt.Sprintf("%s: %s declares %d bytes, %s", ErrImplausibleSize, e.Name, e.Size, e.Reason)
+}
+
+func (e *ImplausibleSizeError) Is(target error) bool { return target == ErrImplausibleSize }
+
+// maxDeflateRatio deflate 能达到的最大压缩比约为 1032:1
+const maxDeflateRatio = 1032
+
+// SetImplausibleSizeUnknown 设置遇到声明了不合理大小的文件时的处理方式,
+// 为 false 时返回 ImplausibleSizeError, 为 true 时视为大小未知, 只能完整下载
+func (ae *ArchiverExtractor) SetImplausibleSizeUnknown(unknown bool) {
+	ae.implausibleSizeUnknown = unknown
+}
+
+// SetMaxBufferBytes 设置需要整个读入内存的文件 (例如 ZipCrypto 加密的文件) 的大小上限, 0 表示不限制
+func (ae *ArchiverExtractor) SetMaxBufferBytes(n int64) {
+	ae.maxBufferBytes = n
+}
+
+// SetMaxDecompressedBytes 设置从归档中累计解压的字节数上限, 所有打开的文件共用, 0 表示不限制
+func (ae *ArchiverExtractor) SetMaxDecompressedBytes(n int64) {
+	ae.maxDecompressedBytes = n
+}
+
+// limitOpen 统计 Open 返回的内容读取的字节数, 超过上限时读取返回 ErrDecompressedLimit.
+// 声明的大小已超过剩余额度时直接拒绝打开
+func (ae *ArchiverExtractor) limitOpen(f *archiver.File) {
+	if ae.maxDecompressedBytes <= 0 || f.Open == nil {
+		return
+	}
+	open, size := f.Open, f.Size()
+	f.Open = func() (io.ReadCloser, error) {
+		if size > ae.maxDecompressedBytes-ae.decompressed.Load() {
+			return nil, ae.decompressedLimitError()
+		}
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		lr := &limitedReadCloser{ReadCloser: rc, ae: ae}
+		if ra, ok := rc.(io.ReaderAt); ok {
+			return &limitedReadAtCloser{limitedReadCloser: lr, ra: ra}, nil
+		}
+		return lr, nil
+	}
+}
+
+func (ae *ArchiverExtractor) decompressedLimitError() error {
+	return fmt.Errorf("%w of %d bytes", ErrDecompressedLimit, ae.maxDecompressedBytes)
+}
+
+// count 累计读取的字节数, 超过上限时返回错误
+func (ae *ArchiverExtractor) count(n int) error {
+	if ae.decompressed.Add(int64(n)) > ae.maxDecompressedBytes {
+		return ae.decompressedLimitError()
+	}
+	return nil
+}
+
+type limitedReadCloser struct {
+	io.ReadCloser
+	ae *ArchiverExtractor
+}
+
+func (r *limitedReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if cerr := r.ae.count(n); cerr != nil {
+		return n, cerr
+	}
+	return n, err
+}
+
+// limitedReadAtCloser 保留可随机读取的文件的 io.ReaderAt, 以便范围请求
+type limitedReadAtCloser struct {
+	*limitedReadCloser
+	ra io.ReaderAt
+}
+
+func (r *limitedReadAtCloser) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ra.ReadAt(p, off)
+	if cerr := r.ae.count(n); cerr != nil {
+		return n, cerr
+	}
+	return n, err
+}
+
+// checkBufferSize 检查读入内存的大小是否超过上限
+func (ae *ArchiverExtractor) checkBufferSize(size uint64) error {
+	if ae.maxBufferBytes > 0 && size > uint64(ae.maxBufferBytes) {
+		return ErrBufferLimit
+	}
+	return nil
+}
+
+// checkSize 用归档大小和 zip 中记录的压缩信息校验文件声明的大小
+func (ae *ArchiverExtractor) checkSize(f archiver.File) error {
+	// 单个压缩文件解压前无法得知大小, -1 是预期的
+	if _, ok := f.FileInfo.(compressedFileInfo); ok {
+		return nil
+	}
+	implausible := func(format string, args ...any) error {
+		return &ImplausibleSizeError{Name: f.NameInArchive, Size: f.Size(), Reason: fmt.Sprintf(format, args...)}
+	}
+	if f.Size() < 0 {
+		return implausible("size is negative")
+	}
+
+	fh, ok := f.Header.(zip.FileHeader)
+	if !ok {
+		return nil
+	}
+	if ra, ok := ae.sourceArchive.(sizedReaderAt); ok && fh.CompressedSize64 > uint64(ra.Size()) {
+		return implausible("compressed size %d exceeds archive size %d", fh.CompressedSize64, ra.Size())
+	}
+	encrypted := fh.Flags&0x1 != 0
+	switch {
+	case fh.Method == zip.Store && !encrypted && fh.UncompressedSize64 != fh.CompressedSize64:
+		return implausible("stored entry has compressed size %d", fh.CompressedSize64)
+	case fh.Method == zip.Deflate && fh.CompressedSize64 < math.MaxUint64/maxDeflateRatio &&
+		fh.UncompressedSize64 > (fh.CompressedSize64+1)*maxDeflateRatio:
+		return implausible("more than deflate's max ratio of compressed size %d", fh.CompressedSize64)
+	}
+	return nil
+}
+
+// unknownSizeFileInfo 大小不可信的文件, Size 返回 -1
+type unknownSizeFileInfo struct {
+	fs.FileInfo
+}
+
+func (fi unknownSizeFileInfo) Size() int64 { return -1 }
@@ -0,0 +1,59 @@
+package archiver
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// sniffLen http.DetectContentType 最多使用的字节数
+const sniffLen = 512
+
+// SetSniff 设置列出文件时是否读取每个文件开头的 512 字节用于识别内容类型
+func (ae *ArchiverExtractor) SetSniff(sniff bool) {
+	ae.sniff = sniff
+}
+
+// SniffedContentType 返回遍历时根据文件内容识别的 MIME 类型
+func (ae *ArchiverExtractor) SniffedContentType(f archiver.File) (string, bool) {
+	contentType, ok := ae.sniffed[f.NameInArchive]
+	return contentType, ok
+}
+
+// sniffKept 包装 handler, 在遍历到下一个文件前识别 handler 保留的文件的内容类型
+func (ae *ArchiverExtractor) sniffKept(files *[]archiver.File, handleFile archiver.FileHandler) archiver.FileHandler {
+	if !ae.sniff {
+		return handleFile
+	}
+	return func(ctx context.Context, f archiver.File) error {
+		n := len(*files)
+		err := handleFile(ctx, f)
+		if len(*files) > n {
+			ae.sniffFile((*files)[len(*files)-1])
+		}
+		return err
+	}
+}
+
+// sniffFile 读取文件开头识别内容类型, 无法打开的文件 (例如加密且没有密码) 跳过
+func (ae *ArchiverExtractor) sniffFile(f archiver.File) {
+	if f.IsDir() || f.Open == nil {
+		return
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(rc, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return
+	}
+	if ae.sniffed == nil {
+		ae.sniffed = make(map[string]string)
+	}
+	ae.sniffed[f.NameInArchive] = http.DetectContentType(head[:n])
+}
@@ -0,0 +1,48 @@
+package archiver
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/mholt/archiver/v4"
+)
+
+// Solid 判断 7z 归档是否为固实压缩, 即存在包含多个文件的数据块, 读取其中的文件需要从块的开头解压
+func (ae *ArchiverExtractor) Solid() (bool, error) {
+	if _, ok := ae.Extractor.(archiver.SevenZip); !ok {
+		return false, nil
+	}
+	ra, ok := ae.sourceArchive.(sizedReaderAt)
+	if !ok {
+		return false, fmt.Errorf("7z archive is not seekable")
+	}
+	r, err := sevenzip.NewReaderWithPassword(ra, ra.Size(), ae.password)
+	if err != nil {
+		return false, err
+	}
+	files := make(map[int64]int)
+	for _, f := range r.File {
+		if f.UncompressedSize == 0 || f.FileInfo().IsDir() {
+			continue
+		}
+		// sevenzip 没有导出文件所在的数据块, 通过反射读取
+		folder := reflect.ValueOf(f).Elem().FieldByName("folder").Int()
+		if files[folder]++; files[folder] > 1 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RandomAccess 判断是否无需从头扫描即可列出和定位文件, zip 和非固实的 7z 支持
+func (ae *ArchiverExtractor) RandomAccess() (bool, error) {
+	switch ae.Extractor.(type) {
+	case archiver.Zip:
+		return true, nil
+	case archiver.SevenZip:
+		solid, err := ae.Solid()
+		return !solid, err
+	}
+	return false, nil
+}
@@ -0,0 +1,139 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/mholt/archiver/v4"
+)
+
+var (
+	// ErrSymlinkEscape 符号链接指向归档根目录之外, 或是绝对路径
+	ErrSymlinkEscape = errors.New("symlink points outside the archive")
+	// ErrSymlinkLoop 解析路径时经过的符号链接过多, 通常是链接形成了循环
+	ErrSymlinkLoop = errors.New("too many levels of symbolic links")
+)
+
+// maxSymlinkHops 解析一个路径时最多经过的符号链接数, 与 Linux 的 MAXSYMLINKS 相同
+const maxSymlinkHops = 40
+
+// maxSymlinkTargetLen zip 中符号链接的目标保存在文件内容中, 超过该长度的不视为符号链接
+const maxSymlinkTargetLen = 4096
+
+// SetResolveSymlinks 设置是否在归档内解析路径中的符号链接, 开启后 ExtractFile 和 ExtractDirs 可以经过指向文件或目录的链接
+func (ae *ArchiverExtractor) SetResolveSymlinks(resolve bool) {
+	ae.resolveSymlinks = resolve
+}
+
+// IsSymlink 判断文件是否为符号链接
+func IsSymlink(f archiver.File) bool {
+	return f.Mode()&fs.ModeSymlink != 0
+}
+
+// SymlinkTarget 返回符号链接的目标. tar 记录在文件头中, zip 保存为文件内容
+func SymlinkTarget(f archiver.File) (string, error) {
+	if f.LinkTarget != "" || f.Open == nil {
+		return f.LinkTarget, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(io.LimitReader(rc, maxSymlinkTargetLen+1))
+	if err != nil {
+		return "", err
+	}
+	if len(target) > maxSymlinkTargetLen {
+		return "", fmt.Errorf("symlink %s: target is too long", f.NameInArchive)
+	}
+	return string(target), nil
+}
+
+// symlinks 返回归档中所有符号链接的路径 (不带开头和结尾的 /) 到目标的映射, 只遍历一次
+func (ae *ArchiverExtractor) symlinks(ctx context.Context) (map[string]string, error) {
+	if ae.symlinkTargets != nil {
+		return ae.symlinkTargets, nil
+	}
+	links := make(map[string]string)
+	err := ae.extract(ctx, ae.pathsInArchive, func(ctx context.Context, f archiver.File) error {
+		if !IsSymlink(f) {
+			return nil
+		}
+		target, err := SymlinkTarget(f)
+		if err != nil {
+			return err
+		}
+		links[strings.Trim(path.Clean(f.NameInArchive), "/")] = target
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ae.symlinkTargets = links
+	return links, nil
+}
+
+// ResolvePath 解析路径中的符号链接, 返回归档中实际的路径, 格式与参数相同 (以 / 开头, 目录以 / 结尾).
+// 链接指向归档之外时返回 ErrSymlinkEscape, 形成循环时返回 ErrSymlinkLoop
+func (ae *ArchiverExtractor) ResolvePath(ctx context.Context, p string) (string, error) {
+	links, err := ae.symlinks(ctx)
+	if err != nil || len(links) == 0 {
+		return p, err
+	}
+	resolved, err := resolveSymlinks(links, strings.Trim(p, "/"))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", p, err)
+	}
+	resolved = "/" + resolved
+	if resolved != "/" && strings.HasSuffix(p, "/") {
+		resolved += "/"
+	}
+	return resolved, nil
+}
+
+// resolveSymlinks 逐级解析 name 中的符号链接, 链接的目标相对于链接所在的目录
+func resolveSymlinks(links map[string]string, name string) (string, error) {
+	parts := strings.Split(name, "/")
+	resolved := ""
+	for hops, i := 0, 0; i < len(parts); i++ {
+		if parts[i] == "" || parts[i] == "." {
+			continue
+		}
+		cur := path.Join(resolved, parts[i])
+		target, ok := links[cur]
+		if !ok {
+			resolved = cur
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return "", ErrSymlinkLoop
+		}
+		if path.IsAbs(target) {
+			return "", ErrSymlinkEscape
+		}
+		next := path.Join(resolved, target)
+		if next == ".." || strings.HasPrefix(next, "../") {
+			return "", ErrSymlinkEscape
+		}
+		// 目标可能还包含链接, 与剩余部分一起从头解析
+		parts = append(strings.Split(next, "/"), parts[i+1:]...)
+		resolved, i = "", -1
+	}
+	return resolved, nil
+}
+
+// aliasDir 将 from 目录下的文件改名到 to 目录下, 使经过符号链接的目录可以按链接的路径列出
+func aliasDir(from, to string, handleFile archiver.FileHandler) archiver.FileHandler {
+	return func(ctx context.Context, f archiver.File) error {
+		if name := "/" + f.NameInArchive; strings.HasPrefix(name, from) {
+			f.NameInArchive = strings.TrimPrefix(to, "/") + strings.TrimPrefix(name, from)
+		}
+		return handleFile(ctx, f)
+	}
+}
@@ -0,0 +1,59 @@
+package archiver
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archiver/v4"
+)
+
+// ntfsExtraID zip 中记录 NTFS 时间戳的扩展字段
+const ntfsExtraID = 0x000a
+
+// Created 返回归档记录的文件创建时间, 只有 7z 和带 NTFS 扩展字段的 zip 记录, 其余情况返回 false
+func Created(f archiver.File) (time.Time, bool) {
+	switch h := f.Header.(type) {
+	case zip.FileHeader:
+		return ntfsCreated(h.Extra)
+	case sevenzip.FileHeader:
+		return h.Created, !h.Created.IsZero()
+	}
+	return time.Time{}, false
+}
+
+// ntfsCreated 从 zip 扩展字段中解析 NTFS 创建时间.
+// NTFS 字段为 4 字节保留后跟若干属性, 属性 1 依次为修改, 访问, 创建时间, 单位为 1601 年起的 100 纳秒
+func ntfsCreated(extra []byte) (time.Time, bool) {
+	for len(extra) >= 4 {
+		id, size := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != ntfsExtraID || len(field) < 4 {
+			continue
+		}
+		for attrs := field[4:]; len(attrs) >= 4; {
+			tag, attrSize := binary.LittleEndian.Uint16(attrs), int(binary.LittleEndian.Uint16(attrs[2:]))
+			if len(attrs) < 4+attrSize {
+				break
+			}
+			if tag == 1 && attrSize >= 24 {
+				if ft := binary.LittleEndian.Uint64(attrs[4+16:]); ft != 0 {
+					return fileTime(ft), true
+				}
+			}
+			attrs = attrs[4+attrSize:]
+		}
+	}
+	return time.Time{}, false
+}
+
+// fileTime 将 Windows FILETIME 转换为时间
+func fileTime(ft uint64) time.Time {
+	const epochDiff = 116444736000000000 // 1601 年到 1970 年的 100 纳秒数
+	return time.Unix(0, (int64(ft)-epochDiff)*100).UTC()
+}